@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pixambi/gbrain/internal/db"
+)
+
+// newMemApp starts the app on an in-memory store holding projects, each
+// with the given notes.
+func newMemApp(t *testing.T, opts Options, projects map[string][]db.Node) (tea.Model, *db.MemStore) {
+	t.Helper()
+	store := db.NewMemStore(nil)
+	for _, name := range slices.Sorted(maps.Keys(projects)) {
+		if err := store.AddProject(db.Project{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		all, err := store.GetProjects()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, node := range projects[name] {
+			node.ProjectID = all[len(all)-1].ID
+			if err := store.AddNode(node); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	m, _ := NewApp(store, opts).Update(tea.WindowSizeMsg{Width: 100, Height: 40})
+	return m, store
+}
+
+// sendKeys presses each key in turn.
+func sendKeys(m tea.Model, keys ...string) tea.Model {
+	for _, key := range keys {
+		msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+		switch key {
+		case "enter":
+			msg = tea.KeyMsg{Type: tea.KeyEnter}
+		case "esc":
+			msg = tea.KeyMsg{Type: tea.KeyEsc}
+		case "tab":
+			msg = tea.KeyMsg{Type: tea.KeyTab}
+		case "up":
+			msg = tea.KeyMsg{Type: tea.KeyUp}
+		case "down":
+			msg = tea.KeyMsg{Type: tea.KeyDown}
+		}
+		m, _ = m.Update(msg)
+	}
+	return m
+}
+
+func wantView(t *testing.T, m tea.Model, want string) {
+	t.Helper()
+	if view := m.View(); !strings.Contains(view, want) {
+		t.Fatalf("view does not show %q:\n%s", want, view)
+	}
+}
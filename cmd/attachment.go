@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pixambi/gbrain/internal/db"
+)
+
+// expandHome replaces a leading ~ in a path typed by the user.
+func expandHome(path string) string {
+	path = strings.TrimSpace(path)
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// exportAttachment writes an attachment into dir under its own name and
+// returns the path written. Existing files are never overwritten.
+func exportAttachment(store db.Store, attachment db.Attachment, dir string) (string, error) {
+	_, data, err := store.GetAttachmentData(attachment.NodeID, attachment.Name)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, attachment.Name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return path, nil
+}
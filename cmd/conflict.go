@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pixambi/gbrain/internal/db"
+	"github.com/pixambi/gbrain/internal/diff"
+)
+
+// saveNode stores the node being edited and goes back to the project. If
+// the node was changed elsewhere since editing began, the conflict screen
+// is shown instead.
+func (m *model) saveNode() error {
+	var err error
+	if m.currentNode.ID == 0 {
+		err = m.db.AddNode(m.currentNode)
+	} else {
+		err = m.db.UpdateNode(m.currentNode)
+	}
+	var conflict *db.ConflictError
+	if errors.As(err, &conflict) {
+		m.conflict = conflict
+		m.state = conflictView
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m.conflict = nil
+	if err := m.loadNodes(); err != nil {
+		return err
+	}
+	m.state = projectView
+	return nil
+}
+
+// keepMine saves the edited node over whatever is stored now, or as a new
+// node if the stored one was deleted.
+func (m *model) keepMine() error {
+	node := m.conflict.Mine
+	if theirs := m.conflict.Theirs; theirs.ID != 0 {
+		node.Version = theirs.Version
+	} else {
+		node.ID, node.Version = 0, 0
+	}
+	m.currentNode = node
+	return m.saveNode()
+}
+
+// keepTheirs throws the edits away and shows the node as it is stored.
+func (m *model) keepTheirs() error {
+	theirs := m.conflict.Theirs
+	m.conflict = nil
+	if err := m.loadNodes(); err != nil {
+		return err
+	}
+	if theirs.ID == 0 {
+		m.state = projectView
+		return nil
+	}
+	if err := m.showNode(theirs); err != nil {
+		return err
+	}
+	m.state = nodeView
+	return nil
+}
+
+// mergeConflict combines both sets of changes to the content and opens the
+// result in the editor, to be checked and saved over the stored node.
+func (m *model) mergeConflict() tea.Cmd {
+	mine, theirs := m.conflict.Mine, m.conflict.Theirs
+	merged, conflicts := diff.Merge(m.editBase.Content, mine.Content, theirs.Content)
+
+	node := theirs
+	if mine.Title != m.editBase.Title {
+		node.Title = mine.Title
+	}
+	node.Content = merged
+	m.currentNode = node
+	m.editBase = theirs
+	m.conflict = nil
+
+	m.textArea.SetValue(merged)
+	m.textArea.Focus()
+	m.state = nodeContentView
+	if conflicts > 0 {
+		m.status = fmt.Sprintf("%d conflicting change(s) kept between %s and %s markers; resolve them and save", conflicts, diff.MarkerMine, diff.MarkerTheirs)
+	} else {
+		m.status = "Changes merged; check the result and save"
+	}
+	return textarea.Blink
+}
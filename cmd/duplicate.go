@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pixambi/gbrain/internal/db"
+)
+
+// duplicate is a node sharing its title with an older node in the project,
+// which keeps the title and gets the links.
+type duplicate struct {
+	node  db.Node
+	owner db.Node
+}
+
+// findDuplicates lists the nodes, oldest first within each title, that
+// have the same title as an older node.
+func findDuplicates(nodes []db.Node) []duplicate {
+	var duplicates []duplicate
+	for _, group := range db.DuplicateTitles(nodes) {
+		for _, node := range group[1:] {
+			duplicates = append(duplicates, duplicate{node: node, owner: group[0]})
+		}
+	}
+	return duplicates
+}
+
+// titleOwner returns the oldest loaded node of the current project, other
+// than the node with ID id, that has title.
+func (m model) titleOwner(title string, id int) (db.Node, bool) {
+	var owner db.Node
+	for _, node := range m.nodes {
+		if node.ID != id && db.SameTitle(node.Title, title) && (owner.ID == 0 || node.ID < owner.ID) {
+			owner = node
+		}
+	}
+	return owner, owner.ID != 0
+}
+
+// titleError says why the title being typed cannot be saved, or is empty
+// if it can. A node that already shares its title may keep it.
+func (m model) titleError() string {
+	title := m.textInput.Value()
+	if m.currentNode.ID != 0 && db.SameTitle(title, m.currentNode.Title) {
+		return ""
+	}
+	if owner, taken := m.titleOwner(title, m.currentNode.ID); taken {
+		return fmt.Sprintf("'%s' is already a node in this project", owner.Title)
+	}
+	return ""
+}
+
+// retitle goes back to the title of the node being edited after the store
+// turned its title down, which happens if the node it clashes with was
+// added elsewhere since the list was loaded.
+func (m *model) retitle(err *db.DuplicateTitleError) tea.Cmd {
+	m.status = err.Error()
+	if err := m.loadNodes(); err != nil {
+		m.err = err
+		return nil
+	}
+	m.textInput.SetValue(err.Title)
+	m.textInput.Focus()
+	m.state = nodeTitleView
+	return textinput.Blink
+}
+
+// showDuplicates lists the current project's duplicate titles, or goes
+// back to the project once there are none left.
+func (m *model) showDuplicates() error {
+	if err := m.loadNodes(); err != nil {
+		return err
+	}
+	m.duplicates = findDuplicates(m.nodes)
+	if len(m.duplicates) == 0 {
+		m.state = projectView
+		return nil
+	}
+	if m.duplicateIndex >= len(m.duplicates) {
+		m.duplicateIndex = len(m.duplicates) - 1
+	}
+	m.state = duplicatesView
+	return nil
+}
+
+// renameDuplicate opens the selected duplicate's title for editing; the
+// new title goes through the usual rename.
+func (m *model) renameDuplicate() tea.Cmd {
+	node := m.duplicates[m.duplicateIndex].node
+	m.currentNode = node
+	m.editBase = node
+	m.textInput.SetValue(node.Title)
+	m.textInput.CursorEnd()
+	m.textInput.Focus()
+	m.state = nodeTitleView
+	return textinput.Blink
+}
+
+// mergeDuplicate folds the selected duplicate into the node that keeps the
+// title.
+func (m *model) mergeDuplicate() error {
+	d := m.duplicates[m.duplicateIndex]
+	if _, err := m.db.MergeNodes(d.owner.ID, d.node.ID); err != nil {
+		return err
+	}
+	m.status = fmt.Sprintf("Merged the newer '%s' into the older one; it can be restored from the trash", d.node.Title)
+	return m.showDuplicates()
+}
+
+// numberDuplicate keeps both nodes, telling the selected one apart by
+// adding the first free number to its title.
+func (m *model) numberDuplicate() error {
+	node := m.duplicates[m.duplicateIndex].node
+	title := node.Title
+	for n := 2; ; n++ {
+		title = fmt.Sprintf("%s (%d)", node.Title, n)
+		if _, taken := m.titleOwner(title, node.ID); !taken {
+			break
+		}
+	}
+	_, err := m.db.RenameNode(node.ID, title)
+	var duplicateErr *db.DuplicateTitleError
+	if errors.As(err, &duplicateErr) {
+		// Taken elsewhere since the list was loaded; the next try will
+		// see it.
+		m.status = duplicateErr.Error()
+		return m.showDuplicates()
+	}
+	if err != nil {
+		return err
+	}
+	m.status = fmt.Sprintf("Renamed the newer '%s' to '%s'", node.Title, title)
+	return m.showDuplicates()
+}
@@ -1,37 +1,47 @@
 package cmd
 
 import (
-	"regexp"
+	"fmt"
 	"strings"
-)
 
-var linkPattern = regexp.MustCompile(`\[\[([^\[\]]+)\]\]`)
+	"github.com/pixambi/gbrain/internal/db"
+	"github.com/pixambi/gbrain/internal/markup"
+)
 
-type Link struct {
-	Title    string
-	Position [2]int
+// link is a link in the node being shown. A link to a node has the node it
+// leads to, which may be in another project, once there is one.
+type link struct {
+	markup.Link
+	target *db.Node
 }
 
-func parseLinks(content string) []Link {
-	var links []Link
-
-	matches := linkPattern.FindAllStringSubmatchIndex(content, -1)
-	for _, match := range matches {
-		if len(match) >= 4 {
-			start := match[2]
-			end := match[3]
-			title := content[start:end]
-			links = append(links, Link{
-				Title:    title,
-				Position: [2]int{match[0], match[1]},
-			})
+// parseLinks finds the links in a node's body and resolves them from the
+// node's project, so [[Project/Title]] reaches into other projects.
+func (m model) parseLinks(node db.Node) []link {
+	var links []link
+	for _, l := range markup.ParseLinks(nodeBody(node.Content)) {
+		parsed := link{Link: l}
+		if !l.Embed && strings.TrimSpace(l.Title) != "" {
+			if target, err := m.db.ResolveLink(l.Title, node.ProjectID); err == nil {
+				parsed.target = &target
+			}
 		}
+		links = append(links, parsed)
 	}
-
 	return links
 }
 
-func renderContent(content string, links []Link, currentLinkIndex int) string {
+// nodeBody returns the part of a node's content shown as body text, without
+// its frontmatter. Link positions used with renderContent must be relative
+// to this.
+func nodeBody(content string) string {
+	_, body, _ := markup.SplitFrontmatter(content)
+	return body
+}
+
+// renderContent highlights links in content, showing each by its display
+// text, or a link into another project as "Project › Title".
+func (m model) renderContent(content string, links []link, currentLinkIndex int) string {
 	if len(links) == 0 {
 		return content
 	}
@@ -44,7 +54,14 @@ func renderContent(content string, links []Link, currentLinkIndex int) string {
 
 		result.WriteString(content[lastEnd:start])
 
-		linkTitle := link.Title
+		linkTitle := link.Display()
+		if link.Embed {
+			linkTitle = "[file: " + link.Title + "]"
+		} else if link.Text == "" && link.target != nil && link.target.ProjectID != m.currentNode.ProjectID {
+			shown := link.Link
+			shown.Title = m.projectName(link.target.ProjectID) + " › " + link.target.Title
+			linkTitle = shown.Display()
+		}
 
 		if i == currentLinkIndex {
 			result.WriteString(selectedLinkStyle.Render(linkTitle))
@@ -58,3 +75,46 @@ func renderContent(content string, links []Link, currentLinkIndex int) string {
 	result.WriteString(content[lastEnd:])
 	return result.String()
 }
+
+// anchorLine returns the line of body holding the heading or block that a
+// link points to.
+func anchorLine(body string, l markup.Link) (int, bool) {
+	offset, ok := markup.FindHeading(body, l.Heading)
+	if l.Block != "" {
+		offset, ok = markup.FindBlock(body, l.Block)
+	}
+	return strings.Count(body[:offset], "\n"), ok
+}
+
+// scrollToAnchor scrolls the node being shown to the heading or block that
+// a link points to, if it points to one.
+func (m *model) scrollToAnchor(l markup.Link) {
+	if l.Heading == "" && l.Block == "" {
+		return
+	}
+	line, ok := anchorLine(nodeBody(m.currentNode.Content), l)
+	switch {
+	case ok:
+		m.scroll = line
+	case l.Block != "":
+		m.status = fmt.Sprintf("No block ^%s in '%s'", l.Block, m.currentNode.Title)
+	default:
+		m.status = fmt.Sprintf("No heading '%s' in '%s'", l.Heading, m.currentNode.Title)
+	}
+}
+
+// scrollContent returns the lines of rendered content from the scroll
+// position on, as many as fit the window, noting how many are out of view.
+func (m model) scrollContent(content string) string {
+	lines := strings.Split(content, "\n")
+	top := min(m.scroll, len(lines)-1)
+	var above string
+	if top > 0 {
+		above = infoStyle.Render(fmt.Sprintf("↑ %d lines above", top)) + "\n"
+	}
+	lines = lines[top:]
+	if fit := max(m.height-14, 5); m.height > 0 && len(lines) > fit {
+		lines = append(lines[:fit-1], infoStyle.Render(fmt.Sprintf("↓ %d lines below", len(lines)-fit+1)))
+	}
+	return above + strings.Join(lines, "\n")
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// lockCheckInterval is how often the idle time is checked, which bounds how
+// late an automatic lock can be.
+const lockCheckInterval = 15 * time.Second
+
+// lockCheckMsg asks the model to lock the store if it has been idle too
+// long.
+type lockCheckMsg struct{}
+
+func lockCheck() tea.Cmd {
+	return tea.Tick(lockCheckInterval, func(time.Time) tea.Msg {
+		return lockCheckMsg{}
+	})
+}
+
+// lock forgets the store's key and shows the lock screen. Whatever was on
+// screen, including unsaved edits, is still there after unlocking.
+func (m *model) lock() tea.Cmd {
+	m.locker.Lock()
+	m.lockReturn = m.state
+	m.lockError = ""
+	m.lockInput.Reset()
+	m.lockInput.Focus()
+	m.state = lockView
+	return textinput.Blink
+}
+
+// unlock tries the passphrase that has been typed in, going back to where
+// the user was if it is right.
+func (m *model) unlock() error {
+	passphrase := m.lockInput.Value()
+	m.lockInput.Reset()
+	if err := m.locker.Unlock([]byte(passphrase)); err != nil {
+		return err
+	}
+	m.state = m.lockReturn
+	// Projects cannot be loaded until the first unlock.
+	return m.loadProjects()
+}
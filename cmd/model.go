@@ -1,12 +1,18 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/pixambi/gbrain/internal/db"
+	"github.com/pixambi/gbrain/internal/markup"
 )
 
 const (
@@ -18,11 +24,27 @@ const (
 	nodeContentView
 	confirmDeleteNodeView
 	confirmDeleteProjectView
+	searchView
+	historyView
+	trashView
+	confirmPurgeView
+	tagsView
+	tagNodesView
+	tagEditView
+	attachFileView
+	attachmentsView
+	lockView
+	conflictView
+	renamePreviewView
+	duplicatesView
+	moveTargetView
+	movePreviewView
 )
 
 type model struct {
 	state            uint
-	db               db.Db
+	db               db.Store
+	opts             Options
 	projects         []db.Project
 	nodes            []db.Node
 	textArea         textarea.Model
@@ -31,53 +53,176 @@ type model struct {
 	currentProject   db.Project
 	projectListIndex int
 	nodeListIndex    int
+	projectSort      sortMode
+	nodeSort         sortMode // Sort mode of the current project
 	width            int
 	height           int
 	err              error
+	status           string // One-line message shown until the next key press
+	// sessionPrefs holds preferences set in a read-only copy, which last
+	// only until it quits.
+	sessionPrefs map[string]string
 
-	// Link navigation
-	links            []Link
+	// Link navigation. currentLinkIndex runs over links followed by
+	// backlinks, so tab cycles through both.
+	links            []link
+	backlinks        []db.Backlink
 	currentLinkIndex int
 	history          []int // Node IDs for history
-}
+	scroll           int   // First line of the node's body in view
 
-func NewApp(db db.Db) model {
-	if err := db.Init(); err != nil {
-		log.Fatalf("Error initializing database: %v", err)
-	}
+	// Editing. editBase is the node as it was when editing began, which a
+	// save that conflicts with another change is merged against.
+	editBase   db.Node
+	conflict   *db.ConflictError
+	renamePlan db.RenamePlan
 
-	projects, err := db.GetProjects()
-	if err != nil {
-		log.Fatalf("Error getting projects: %v", err)
-	}
+	// Nodes of the current project whose title an older node already has
+	duplicates     []duplicate
+	duplicateIndex int
+
+	// Moving and copying nodes. marked holds the IDs of nodes picked with
+	// space in the project view; if there are none the selected node goes.
+	marked      map[int]bool
+	moveOpts    db.MoveOptions
+	moveTargets []db.Project
+	moveIndex   int
+	movePlan    db.MovePlan
+
+	// Search
+	searchInput     textinput.Model
+	searchResults   []db.SearchResult
+	searchIndex     int
+	searchProjectID int  // 0 when searching every project
+	searchReturn    uint // State to go back to when search is cancelled
+
+	// Revision history of the current node, newest first. compareIndex is
+	// the revision the selected one is diffed against, or -1 to compare with
+	// the revision before it.
+	revisions     []db.Revision
+	revisionIndex int
+	compareIndex  int
+
+	// Trash
+	trash      []db.TrashItem
+	trashIndex int
+
+	// Tag browser and explicit tag editing
+	tags         []db.TagCount
+	tagIndex     int
+	tagNodes     []db.Node
+	tagNodeIndex int
+	tagInput     textinput.Model
+
+	// Attachments of the current node. pathInput holds the file to attach
+	// or the directory to export to.
+	attachments     []db.Attachment
+	attachmentIndex int
+	pathInput       textinput.Model
+
+	// Encryption. locker is nil unless the store is encrypted; lockReturn
+	// is the state to go back to once it is unlocked.
+	locker       db.Locker
+	lastActivity time.Time
+	lockInput    textinput.Model
+	lockReturn   uint
+	lockError    string
+}
+
+// Options controls behaviour that is set up when the application starts.
+type Options struct {
+	// LockAfter locks an encrypted store after this long without a key
+	// press; 0 never locks it automatically.
+	LockAfter time.Duration
+	// BackupDir and BackupKeep are where backups taken from the app go and
+	// how many of them are kept.
+	BackupDir  string
+	BackupKeep int
+	// ReadOnly is set when the store is a read-only copy, and turns off the
+	// keys that would change it.
+	ReadOnly bool
+}
 
+// NewApp builds the application around store, which must already be
+// initialised. An encrypted store that is still locked opens on the lock
+// screen.
+func NewApp(store db.Store, opts Options) model {
 	ti := textinput.New()
 	ti.Placeholder = "Enter title..."
 	ti.Focus()
 	ti.CharLimit = 50
 	ti.Width = 30
 
+	si := textinput.New()
+	si.Placeholder = "Search..."
+	si.Width = 50
+
+	tg := textinput.New()
+	tg.Placeholder = "tag, another-tag"
+	tg.CharLimit = 200
+	tg.Width = 50
+
+	pi := textinput.New()
+	pi.CharLimit = 4096
+	pi.Width = 60
+
+	li := textinput.New()
+	li.Placeholder = "Passphrase"
+	li.EchoMode = textinput.EchoPassword
+	li.EchoCharacter = '•'
+	li.Width = 40
+
 	ta := textarea.New()
 	ta.Placeholder = "Enter content..."
 	ta.Focus()
 	ta.SetWidth(80)
 	ta.SetHeight(20)
 
-	return model{
+	m := model{
 		state:            projectsView,
-		db:               db,
-		projects:         projects,
+		db:               store,
+		opts:             opts,
 		textArea:         ta,
 		textInput:        ti,
+		searchInput:      si,
+		tagInput:         tg,
+		pathInput:        pi,
+		lockInput:        li,
+		lastActivity:     time.Now(),
 		projectListIndex: 0,
 		nodeListIndex:    0,
-		links:            []Link{},
+		links:            []link{},
 		currentLinkIndex: 0,
 		history:          []int{},
+		marked:           make(map[int]bool),
+		sessionPrefs:     make(map[string]string),
+	}
+
+	sortPref, err := m.preference(projectsSortPref)
+	if err != nil {
+		log.Fatalf("Error reading preferences: %v", err)
 	}
+	m.projectSort = parseSortMode(sortPref)
+
+	if locker, ok := store.(db.Locker); ok && locker.Encrypted() {
+		m.locker = locker
+		if locker.Locked() {
+			m.lockReturn = projectsView
+			m.lockInput.Focus()
+			m.state = lockView
+			return m
+		}
+	}
+	if err := m.loadProjects(); err != nil {
+		log.Fatalf("Error getting projects: %v", err)
+	}
+	return m
 }
 
 func (m model) Init() tea.Cmd {
+	if m.locker != nil && m.opts.LockAfter > 0 {
+		return tea.Batch(textinput.Blink, lockCheck())
+	}
 	return textinput.Blink
 }
 
@@ -95,10 +240,47 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.textArea.SetHeight(msg.Height - 8)
 		return m, nil
 
+	case lockCheckMsg:
+		if m.state != lockView && time.Since(m.lastActivity) >= m.opts.LockAfter {
+			return m, tea.Batch(m.lock(), lockCheck())
+		}
+		return m, lockCheck()
+
 	case tea.KeyMsg:
 		key := msg.String()
+		m.status = ""
+		m.lastActivity = time.Now()
+
+		if key == "ctrl+l" && m.locker != nil && m.state != lockView {
+			return m, m.lock()
+		}
+		if m.opts.ReadOnly && isEditKey(m.state, key) {
+			m.status = readOnlyStatus
+			return m, nil
+		}
 
 		switch m.state {
+		case lockView:
+			switch key {
+			case "ctrl+c":
+				return m, tea.Quit
+
+			case "enter":
+				err := m.unlock()
+				if errors.Is(err, db.ErrWrongPassphrase) {
+					m.lockError = "Wrong passphrase"
+					return m, nil
+				}
+				if err != nil {
+					m.err = err
+				}
+				return m, nil
+			}
+
+			m.lockError = ""
+			m.lockInput, cmd = m.lockInput.Update(msg)
+			cmds = append(cmds, cmd)
+
 		case projectsView:
 			switch key {
 			case "q", "ctrl+c", "esc":
@@ -110,6 +292,55 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.state = projectTitleView
 				return m, textinput.Blink
 
+			case "/":
+				return m, m.startSearch(0)
+
+			case "s":
+				m.projectSort = m.projectSort.next()
+				if err := m.setPreference(projectsSortPref, m.projectSort.String()); err != nil {
+					m.err = err
+					return m, nil
+				}
+				if err := m.loadProjects(); err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.projectListIndex = 0
+
+			case "#":
+				tags, err := m.db.GetTags()
+				if err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.tags = tags
+				m.tagIndex = 0
+				m.state = tagsView
+				return m, nil
+
+			case "B":
+				backuper, ok := m.db.(db.Backuper)
+				if !ok {
+					m.status = "Only the database can be backed up from here"
+					return m, nil
+				}
+				path, err := backuper.Backup(m.opts.BackupDir, m.opts.BackupKeep)
+				if err != nil {
+					m.status = fmt.Sprintf("Backup failed: %v", err)
+					return m, nil
+				}
+				m.status = fmt.Sprintf("Backed up to %s", path)
+				return m, nil
+
+			case "t":
+				if err := m.loadTrash(); err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.trashIndex = 0
+				m.state = trashView
+				return m, nil
+
 			case "d":
 				if len(m.projects) > 0 {
 					m.currentProject = m.projects[m.projectListIndex]
@@ -129,14 +360,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			case "enter":
 				if len(m.projects) > 0 {
-					m.currentProject = m.projects[m.projectListIndex]
-					nodes, err := m.db.GetNodesByProjectID(m.currentProject.ID)
-					if err != nil {
+					if err := m.openProject(m.projects[m.projectListIndex]); err != nil {
 						m.err = err
 						return m, nil
 					}
-					m.nodes = nodes
-					m.nodeListIndex = 0
 					m.state = projectView
 				}
 			}
@@ -144,17 +371,17 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case confirmDeleteProjectView:
 			switch key {
 			case "y", "Y":
-				if err := m.db.DeleteProject(m.currentProject.ID); err != nil {
+				report, err := m.db.DeleteProject(m.currentProject.ID)
+				if err != nil {
 					m.err = err
 					return m, nil
 				}
+				m.status = fmt.Sprintf("Moved '%s' and %d node(s) to the trash", m.currentProject.Name, len(report.NodeIDs))
 
-				projects, err := m.db.GetProjects()
-				if err != nil {
+				if err := m.loadProjects(); err != nil {
 					m.err = err
 					return m, nil
 				}
-				m.projects = projects
 
 				// Adjust the project list index if needed
 				if m.projectListIndex >= len(m.projects) && len(m.projects) > 0 {
@@ -185,12 +412,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						return m, nil
 					}
 
-					projects, err := m.db.GetProjects()
-					if err != nil {
+					if err := m.loadProjects(); err != nil {
 						m.err = err
 						return m, nil
 					}
-					m.projects = projects
 					m.state = projectsView
 				}
 			}
@@ -208,9 +433,43 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.textInput.Reset()
 				m.textInput.Focus()
 				m.currentNode = db.Node{ProjectID: m.currentProject.ID}
+				m.editBase = m.currentNode
 				m.state = nodeTitleView
 				return m, textinput.Blink
 
+			case "/":
+				return m, m.startSearch(m.currentProject.ID)
+
+			case " ":
+				m.toggleMark()
+
+			case "m", "c":
+				m.startMove(key == "c")
+				return m, nil
+
+			case "D":
+				m.duplicateIndex = 0
+				if err := m.showDuplicates(); err != nil {
+					m.err = err
+					return m, nil
+				}
+				if len(m.duplicates) == 0 {
+					m.status = "Every node in this project has a title of its own"
+				}
+				return m, nil
+
+			case "s":
+				m.nodeSort = m.nodeSort.next()
+				if err := m.setPreference(nodeSortPref(m.currentProject.ID), m.nodeSort.String()); err != nil {
+					m.err = err
+					return m, nil
+				}
+				if err := m.loadNodes(); err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.nodeListIndex = 0
+
 			case "d":
 				if len(m.nodes) > 0 {
 					m.currentNode = m.nodes[m.nodeListIndex]
@@ -230,14 +489,349 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			case "enter":
 				if len(m.nodes) > 0 {
-					m.currentNode = m.nodes[m.nodeListIndex]
-					m.links = parseLinks(m.currentNode.Content)
-					m.currentLinkIndex = 0
+					if err := m.showNode(m.nodes[m.nodeListIndex]); err != nil {
+						m.err = err
+						return m, nil
+					}
 					m.history = []int{}
 					m.state = nodeView
 				}
 			}
 
+		case searchView:
+			switch key {
+			case "esc":
+				m.state = m.searchReturn
+				return m, nil
+
+			case "down", "ctrl+n":
+				if m.searchIndex < len(m.searchResults)-1 {
+					m.searchIndex++
+				}
+				return m, nil
+
+			case "up", "ctrl+p":
+				if m.searchIndex > 0 {
+					m.searchIndex--
+				}
+				return m, nil
+
+			case "enter":
+				if len(m.searchResults) > 0 {
+					if err := m.openNode(m.searchResults[m.searchIndex].Node); err != nil {
+						m.err = err
+					}
+				}
+				return m, nil
+			}
+
+			previous := m.searchInput.Value()
+			m.searchInput, cmd = m.searchInput.Update(msg)
+			cmds = append(cmds, cmd)
+			if m.searchInput.Value() != previous {
+				results, err := m.db.Search(m.searchInput.Value(), db.SearchOptions{ProjectID: m.searchProjectID})
+				if err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.searchResults = results
+				m.searchIndex = 0
+			}
+
+		case tagsView:
+			switch key {
+			case "esc", "q":
+				m.state = projectsView
+				return m, nil
+
+			case "j", "down":
+				if m.tagIndex < len(m.tags)-1 {
+					m.tagIndex++
+				}
+
+			case "k", "up":
+				if m.tagIndex > 0 {
+					m.tagIndex--
+				}
+
+			case "enter":
+				if len(m.tags) > 0 {
+					nodes, err := m.db.GetNodesByTag(m.tags[m.tagIndex].Name)
+					if err != nil {
+						m.err = err
+						return m, nil
+					}
+					m.tagNodes = nodes
+					m.tagNodeIndex = 0
+					m.state = tagNodesView
+				}
+			}
+
+		case tagNodesView:
+			switch key {
+			case "esc", "q":
+				m.state = tagsView
+				return m, nil
+
+			case "j", "down":
+				if m.tagNodeIndex < len(m.tagNodes)-1 {
+					m.tagNodeIndex++
+				}
+
+			case "k", "up":
+				if m.tagNodeIndex > 0 {
+					m.tagNodeIndex--
+				}
+
+			case "enter":
+				if len(m.tagNodes) > 0 {
+					if err := m.openNode(m.tagNodes[m.tagNodeIndex]); err != nil {
+						m.err = err
+						return m, nil
+					}
+				}
+			}
+
+		case tagEditView:
+			switch key {
+			case "esc":
+				m.state = nodeView
+				return m, nil
+
+			case "enter":
+				node := m.currentNode
+				node.Tags = markup.MergeTags(strings.FieldsFunc(m.tagInput.Value(), func(r rune) bool {
+					return r == ',' || r == ' '
+				}))
+				err := m.db.UpdateNode(node)
+				var conflict *db.ConflictError
+				if errors.As(err, &conflict) {
+					// Tags are quick to type again, so show what is there
+					// now rather than offering a merge.
+					if conflict.Theirs.ID == 0 {
+						m.status = "The node was deleted elsewhere"
+						m.state = projectView
+						if err := m.loadNodes(); err != nil {
+							m.err = err
+						}
+						return m, nil
+					}
+					m.status = "The node was changed elsewhere; tags not saved"
+					node, err = conflict.Theirs, nil
+				} else if err == nil {
+					node, err = m.db.GetNode(node.ID)
+				}
+				if err != nil {
+					m.err = err
+					return m, nil
+				}
+				if err := m.showNode(node); err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.state = nodeView
+				return m, nil
+			}
+
+			m.tagInput, cmd = m.tagInput.Update(msg)
+			cmds = append(cmds, cmd)
+
+		case attachFileView:
+			switch key {
+			case "esc":
+				m.state = nodeView
+				return m, nil
+
+			case "enter":
+				path := expandHome(m.pathInput.Value())
+				data, err := os.ReadFile(path)
+				if err != nil {
+					m.status = err.Error()
+					return m, nil
+				}
+				attachment, err := m.db.AddAttachment(m.currentNode.ID, path, data)
+				if err != nil {
+					m.err = err
+					return m, nil
+				}
+				if err := m.showNode(m.currentNode); err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.status = fmt.Sprintf("Attached '%s'; reference it with ![[%s]]", attachment.Name, attachment.Name)
+				m.state = nodeView
+				return m, nil
+			}
+
+			m.pathInput, cmd = m.pathInput.Update(msg)
+			cmds = append(cmds, cmd)
+
+		case attachmentsView:
+			switch key {
+			case "esc":
+				m.state = nodeView
+				return m, nil
+
+			case "down", "ctrl+n":
+				if m.attachmentIndex < len(m.attachments)-1 {
+					m.attachmentIndex++
+				}
+				return m, nil
+
+			case "up", "ctrl+p":
+				if m.attachmentIndex > 0 {
+					m.attachmentIndex--
+				}
+				return m, nil
+
+			case "ctrl+d":
+				attachment := m.attachments[m.attachmentIndex]
+				if err := m.db.RemoveAttachment(attachment.NodeID, attachment.Name); err != nil {
+					m.err = err
+					return m, nil
+				}
+				if err := m.showNode(m.currentNode); err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.status = fmt.Sprintf("Removed '%s'", attachment.Name)
+				if len(m.attachments) == 0 {
+					m.state = nodeView
+				} else if m.attachmentIndex >= len(m.attachments) {
+					m.attachmentIndex = len(m.attachments) - 1
+				}
+				return m, nil
+
+			case "enter":
+				attachment := m.attachments[m.attachmentIndex]
+				path, err := exportAttachment(m.db, attachment, expandHome(m.pathInput.Value()))
+				if err != nil {
+					m.status = err.Error()
+					return m, nil
+				}
+				m.status = fmt.Sprintf("Exported to %s", path)
+				m.state = nodeView
+				return m, nil
+			}
+
+			m.pathInput, cmd = m.pathInput.Update(msg)
+			cmds = append(cmds, cmd)
+
+		case trashView:
+			switch key {
+			case "esc", "q":
+				m.state = projectsView
+				return m, nil
+
+			case "j", "down":
+				if m.trashIndex < len(m.trash)-1 {
+					m.trashIndex++
+				}
+
+			case "k", "up":
+				if m.trashIndex > 0 {
+					m.trashIndex--
+				}
+
+			case "r":
+				if len(m.trash) == 0 {
+					return m, nil
+				}
+				item := m.trash[m.trashIndex]
+				if err := m.db.RestoreTrash(item.ID); err != nil {
+					// Restoring a note whose project is also in the
+					// trash is a user mistake, not a fatal error.
+					m.status = err.Error()
+					return m, nil
+				}
+				if err := m.loadProjects(); err != nil {
+					m.err = err
+					return m, nil
+				}
+				if err := m.loadTrash(); err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.status = fmt.Sprintf("Restored %s", trashItemName(item))
+
+			case "p":
+				if len(m.trash) > 0 {
+					m.state = confirmPurgeView
+				}
+			}
+
+		case confirmPurgeView:
+			switch key {
+			case "y", "Y":
+				item := m.trash[m.trashIndex]
+				if _, err := m.db.PurgeTrash(item.ID); err != nil {
+					m.err = err
+					return m, nil
+				}
+				if err := m.loadTrash(); err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.status = fmt.Sprintf("Permanently deleted %s", trashItemName(item))
+				m.state = trashView
+				return m, nil
+
+			case "n", "N", "esc":
+				m.state = trashView
+				return m, nil
+			}
+
+		case historyView:
+			switch key {
+			case "esc", "q":
+				m.state = nodeView
+				return m, nil
+
+			case "j", "down":
+				if m.revisionIndex < len(m.revisions)-1 {
+					m.revisionIndex++
+				}
+
+			case "k", "up":
+				if m.revisionIndex > 0 {
+					m.revisionIndex--
+				}
+
+			case " ":
+				if m.compareIndex == m.revisionIndex {
+					m.compareIndex = -1
+				} else {
+					m.compareIndex = m.revisionIndex
+				}
+
+			case "r":
+				if len(m.revisions) == 0 {
+					return m, nil
+				}
+				revision := m.revisions[m.revisionIndex]
+				node, err := m.db.RestoreRevision(m.currentNode.ID, revision.ID)
+				var duplicateErr *db.DuplicateTitleError
+				if errors.As(err, &duplicateErr) {
+					m.status = fmt.Sprintf("Cannot restore revision #%d: %v", revision.ID, err)
+					return m, nil
+				}
+				if err != nil {
+					m.err = err
+					return m, nil
+				}
+				if err := m.loadNodes(); err != nil {
+					m.err = err
+					return m, nil
+				}
+				if err := m.showNode(node); err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.status = fmt.Sprintf("Restored revision #%d", revision.ID)
+				m.state = nodeView
+				return m, nil
+			}
+
 		case confirmDeleteNodeView:
 			switch key {
 			case "y", "Y":
@@ -246,12 +840,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, nil
 				}
 
-				nodes, err := m.db.GetNodesByProjectID(m.currentProject.ID)
-				if err != nil {
+				if err := m.loadNodes(); err != nil {
 					m.err = err
 					return m, nil
 				}
-				m.nodes = nodes
 
 				// Adjust the node list index if needed
 				if m.nodeListIndex >= len(m.nodes) && len(m.nodes) > 0 {
@@ -273,22 +865,122 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 
 			case "enter":
-				if m.textInput.Value() != "" {
-					m.currentNode.Title = m.textInput.Value()
-					if m.currentNode.Content == "" {
-						m.textArea.Reset()
-					} else {
-						m.textArea.SetValue(m.currentNode.Content)
+				title := m.textInput.Value()
+				if title == "" || m.titleError() != "" {
+					break
+				}
+				if m.currentNode.ID != 0 && title != m.currentNode.Title {
+					cmd, err := m.startRename(title)
+					var duplicateErr *db.DuplicateTitleError
+					if errors.As(err, &duplicateErr) {
+						return m, m.retitle(duplicateErr)
 					}
-					m.textArea.Focus()
-					m.state = nodeContentView
-					return m, textarea.Blink
+					if err != nil {
+						m.err = err
+					}
+					return m, cmd
 				}
+				m.currentNode.Title = title
+				return m, m.editContent()
 			}
 
 			m.textInput, cmd = m.textInput.Update(msg)
 			cmds = append(cmds, cmd)
 
+		case duplicatesView:
+			var err error
+			switch key {
+			case "esc", "q":
+				m.state = projectView
+				return m, nil
+
+			case "j", "down":
+				if m.duplicateIndex < len(m.duplicates)-1 {
+					m.duplicateIndex++
+				}
+
+			case "k", "up":
+				if m.duplicateIndex > 0 {
+					m.duplicateIndex--
+				}
+
+			case "r":
+				return m, m.renameDuplicate()
+
+			case "m":
+				err = m.mergeDuplicate()
+
+			case "n":
+				err = m.numberDuplicate()
+			}
+			if err != nil {
+				m.err = err
+			}
+			return m, nil
+
+		case moveTargetView:
+			switch key {
+			case "esc", "q":
+				m.state = projectView
+				return m, nil
+
+			case "j", "down":
+				if m.moveIndex < len(m.moveTargets)-1 {
+					m.moveIndex++
+				}
+
+			case "k", "up":
+				if m.moveIndex > 0 {
+					m.moveIndex--
+				}
+
+			case "enter":
+				if err := m.previewMove(); err != nil {
+					m.err = err
+				}
+				return m, nil
+			}
+
+		case movePreviewView:
+			var err error
+			switch key {
+			case "esc", "n":
+				m.state = moveTargetView
+				return m, nil
+
+			case "l":
+				m.moveOpts.Linked = !m.moveOpts.Linked
+				err = m.previewMove()
+
+			case "tab":
+				err = m.cycleConflictPolicy()
+
+			case "y", "enter":
+				err = m.move()
+			}
+			if err != nil {
+				m.err = err
+			}
+			return m, nil
+
+		case renamePreviewView:
+			switch key {
+			case "y", "enter":
+				cmd, err := m.rename()
+				var duplicateErr *db.DuplicateTitleError
+				if errors.As(err, &duplicateErr) {
+					return m, m.retitle(duplicateErr)
+				}
+				if err != nil {
+					m.err = err
+				}
+				return m, cmd
+
+			case "n", "esc":
+				m.state = nodeTitleView
+				return m, textinput.Blink
+			}
+
 		case nodeContentView:
 			switch key {
 			case "esc":
@@ -299,24 +991,51 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			case "ctrl+s":
 				m.currentNode.Content = m.textArea.Value()
-				if err := m.db.AddNode(m.currentNode); err != nil {
-					m.err = err
-					return m, nil
+				err := m.saveNode()
+				var duplicateErr *db.DuplicateTitleError
+				if errors.As(err, &duplicateErr) {
+					return m, m.retitle(duplicateErr)
 				}
-
-				nodes, err := m.db.GetNodesByProjectID(m.currentProject.ID)
 				if err != nil {
 					m.err = err
-					return m, nil
 				}
-				m.nodes = nodes
-				m.state = projectView
 				return m, nil
 			}
 
 			m.textArea, cmd = m.textArea.Update(msg)
 			cmds = append(cmds, cmd)
 
+		case conflictView:
+			var err error
+			switch key {
+			case "m":
+				err = m.keepMine()
+
+			case "t":
+				err = m.keepTheirs()
+
+			case "e":
+				if m.conflict.Theirs.ID == 0 {
+					m.status = "The node was deleted; there is nothing to merge with"
+					return m, nil
+				}
+				return m, m.mergeConflict()
+
+			case "esc":
+				m.currentNode = m.conflict.Mine
+				m.textArea.Focus()
+				m.state = nodeContentView
+				return m, textarea.Blink
+			}
+			var duplicateErr *db.DuplicateTitleError
+			if errors.As(err, &duplicateErr) {
+				return m, m.retitle(duplicateErr)
+			}
+			if err != nil {
+				m.err = err
+			}
+			return m, nil
+
 		case nodeView:
 			switch key {
 			case "esc", "q":
@@ -325,6 +1044,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 
 			case "e":
+				m.editBase = m.currentNode
 				m.textInput.SetValue(m.currentNode.Title)
 				m.textInput.Focus()
 				m.textArea.SetValue(m.currentNode.Content)
@@ -335,21 +1055,92 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.state = confirmDeleteNodeView
 				return m, nil
 
+			case "g":
+				m.tagInput.SetValue(strings.Join(m.currentNode.Tags, ", "))
+				m.tagInput.CursorEnd()
+				m.tagInput.Focus()
+				m.state = tagEditView
+				return m, textinput.Blink
+
+			case "a":
+				m.pathInput.Reset()
+				m.pathInput.Placeholder = "Path of file to attach..."
+				m.pathInput.Focus()
+				m.state = attachFileView
+				return m, textinput.Blink
+
+			case "x":
+				if len(m.attachments) == 0 {
+					m.status = "This node has no attachments"
+					return m, nil
+				}
+				return m, m.startExport(0)
+
+			case "h":
+				revisions, err := m.db.GetRevisions(m.currentNode.ID)
+				if err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.revisions = revisions
+				m.revisionIndex = 0
+				m.compareIndex = -1
+				m.state = historyView
+				return m, nil
+
+			case "j", "down":
+				if m.scroll < strings.Count(nodeBody(m.currentNode.Content), "\n") {
+					m.scroll++
+				}
+
+			case "k", "up":
+				if m.scroll > 0 {
+					m.scroll--
+				}
+
 			case "tab":
-				if len(m.links) > 0 {
-					m.currentLinkIndex = (m.currentLinkIndex + 1) % len(m.links)
+				if total := len(m.links) + len(m.backlinks); total > 0 {
+					m.currentLinkIndex = (m.currentLinkIndex + 1) % total
 				}
 
 			case "enter":
-				if len(m.links) > 0 && m.currentLinkIndex < len(m.links) {
-					linkedNodeTitle := m.links[m.currentLinkIndex].Title
-					linkedNode, err := m.db.GetNodeByTitle(linkedNodeTitle, m.currentProject.ID)
-					if err == nil {
-						m.history = append(m.history, m.currentNode.ID)
-						m.currentNode = linkedNode
-						m.links = parseLinks(m.currentNode.Content)
-						m.currentLinkIndex = 0
+				if m.currentLinkIndex < len(m.links) && m.links[m.currentLinkIndex].Embed {
+					name := m.links[m.currentLinkIndex].Title
+					for i, attachment := range m.attachments {
+						if attachment.Name == name {
+							return m, m.startExport(i)
+						}
+					}
+					m.status = fmt.Sprintf("No attachment named '%s'; press 'a' to attach it", name)
+					return m, nil
+				}
+
+				var target db.Node
+				var anchor markup.Link
+				err := fmt.Errorf("no link selected")
+				if m.currentLinkIndex < len(m.links) {
+					selected := m.links[m.currentLinkIndex]
+					anchor = selected.Link
+					if strings.TrimSpace(selected.Title) == "" {
+						m.scrollToAnchor(anchor)
+						return m, nil
+					}
+					if selected.target == nil {
+						m.status = fmt.Sprintf("No node titled '%s' yet", selected.Title)
+						return m, nil
 					}
+					target, err = *selected.target, nil
+				} else if i := m.currentLinkIndex - len(m.links); i < len(m.backlinks) {
+					target, err = m.db.GetNode(m.backlinks[i].NodeID)
+				}
+				if err == nil {
+					previousID := m.currentNode.ID
+					if err := m.followNode(target); err != nil {
+						m.err = err
+						return m, nil
+					}
+					m.history = append(m.history, previousID)
+					m.scrollToAnchor(anchor)
 				}
 
 			case "b":
@@ -358,10 +1149,11 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					previousNodeID := m.history[lastIndex]
 					previousNode, err := m.db.GetNode(previousNodeID)
 					if err == nil {
-						m.currentNode = previousNode
+						if err := m.followNode(previousNode); err != nil {
+							m.err = err
+							return m, nil
+						}
 						m.history = m.history[:lastIndex]
-						m.links = parseLinks(m.currentNode.Content)
-						m.currentLinkIndex = 0
 					}
 				}
 			}
@@ -370,3 +1162,163 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	return m, tea.Batch(cmds...)
 }
+
+// showNode makes node the current node and loads its outgoing and incoming
+// links and its attachments.
+func (m *model) showNode(node db.Node) error {
+	backlinks, err := m.db.GetBacklinks(node.ID)
+	if err != nil {
+		return err
+	}
+	attachments, err := m.db.GetAttachments(node.ID)
+	if err != nil {
+		return err
+	}
+	m.currentNode = node
+	m.links = m.parseLinks(node)
+	m.backlinks = backlinks
+	m.attachments = attachments
+	m.currentLinkIndex = 0
+	m.scroll = 0
+	return nil
+}
+
+// startExport switches to the attachments view with one selected and the
+// export directory defaulting to the working directory.
+func (m *model) startExport(index int) tea.Cmd {
+	dir, err := os.Getwd()
+	if err != nil {
+		dir = ""
+	}
+	m.attachmentIndex = index
+	m.pathInput.SetValue(dir)
+	m.pathInput.CursorEnd()
+	m.pathInput.Placeholder = "Directory to export to..."
+	m.pathInput.Focus()
+	m.state = attachmentsView
+	return textinput.Blink
+}
+
+// startSearch switches to the search view, scoped to a project or to every
+// project when projectID is 0.
+func (m *model) startSearch(projectID int) tea.Cmd {
+	m.searchReturn = m.state
+	m.searchProjectID = projectID
+	m.searchResults = nil
+	m.searchIndex = 0
+	m.searchInput.Reset()
+	m.searchInput.Focus()
+	m.state = searchView
+	return textinput.Blink
+}
+
+// openNode shows node in the node view from outside its project's list,
+// switching the current project if needed so that going back lands in the
+// right place.
+func (m *model) openNode(node db.Node) error {
+	if err := m.followNode(node); err != nil {
+		return err
+	}
+	m.history = []int{}
+	m.state = nodeView
+	return nil
+}
+
+// followNode shows node, switching the current project first if node is in
+// another one, with node selected in its list.
+func (m *model) followNode(node db.Node) error {
+	if node.ProjectID != m.currentProject.ID {
+		project, err := m.db.GetProject(node.ProjectID)
+		if err != nil {
+			return err
+		}
+		if err := m.openProject(project); err != nil {
+			return err
+		}
+	}
+	for i, n := range m.nodes {
+		if n.ID == node.ID {
+			m.nodeListIndex = i
+		}
+	}
+	return m.showNode(node)
+}
+
+// projectName returns the name of a loaded project.
+func (m model) projectName(id int) string {
+	for _, project := range m.projects {
+		if project.ID == id {
+			return project.Name
+		}
+	}
+	return ""
+}
+
+// loadTrash refreshes the trash list, keeping the selection in range.
+func (m *model) loadTrash() error {
+	trash, err := m.db.GetTrash()
+	if err != nil {
+		return err
+	}
+	m.trash = trash
+	if m.trashIndex >= len(m.trash) && len(m.trash) > 0 {
+		m.trashIndex = len(m.trash) - 1
+	}
+	return nil
+}
+
+// trashItemName describes a trash item for messages.
+func trashItemName(item db.TrashItem) string {
+	if item.Kind == db.TrashProject {
+		return fmt.Sprintf("project '%s'", item.Project.Name)
+	}
+	return fmt.Sprintf("'%s'", item.Nodes[0].Title)
+}
+
+// loadProjects refreshes the project list in the current sort order.
+func (m *model) loadProjects() error {
+	projects, err := m.db.GetProjects()
+	if err != nil {
+		return err
+	}
+
+	var links map[int]int
+	if m.projectSort == sortByLinks {
+		nodes, err := m.db.GetNodes()
+		if err != nil {
+			return err
+		}
+		links = make(map[int]int)
+		for _, node := range nodes {
+			links[node.ProjectID] += linkCount(node)
+		}
+	}
+
+	sortProjects(projects, m.projectSort, links)
+	m.projects = projects
+	return nil
+}
+
+// loadNodes refreshes the current project's node list in its sort order.
+func (m *model) loadNodes() error {
+	nodes, err := m.db.GetNodesByProjectID(m.currentProject.ID)
+	if err != nil {
+		return err
+	}
+	sortNodes(nodes, m.nodeSort)
+	m.nodes = nodes
+	return nil
+}
+
+// openProject makes project current, restoring its remembered sort mode.
+func (m *model) openProject(project db.Project) error {
+	sortPref, err := m.preference(nodeSortPref(project.ID))
+	if err != nil {
+		return err
+	}
+	m.currentProject = project
+	m.nodeSort = parseSortMode(sortPref)
+	m.nodeListIndex = 0
+	clear(m.marked)
+	return m.loadNodes()
+}
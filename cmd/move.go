@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/pixambi/gbrain/internal/db"
+)
+
+// conflictPolicies is the order tab cycles through in the move preview.
+var conflictPolicies = []db.ConflictPolicy{db.ConflictNumber, db.ConflictSkip, db.ConflictMerge}
+
+// toggleMark marks or unmarks the selected node for moving.
+func (m *model) toggleMark() {
+	if len(m.nodes) == 0 {
+		return
+	}
+	id := m.nodes[m.nodeListIndex].ID
+	if m.marked[id] {
+		delete(m.marked, id)
+	} else {
+		m.marked[id] = true
+	}
+}
+
+// moveIDs returns the marked nodes in list order, or the selected node if
+// none are marked.
+func (m model) moveIDs() []int {
+	var ids []int
+	for _, node := range m.nodes {
+		if m.marked[node.ID] {
+			ids = append(ids, node.ID)
+		}
+	}
+	if len(ids) == 0 && len(m.nodes) > 0 {
+		ids = append(ids, m.nodes[m.nodeListIndex].ID)
+	}
+	return ids
+}
+
+// nodeTitle returns the title of a node in the current project's list.
+func (m model) nodeTitle(id int) string {
+	for _, node := range m.nodes {
+		if node.ID == id {
+			return node.Title
+		}
+	}
+	return ""
+}
+
+// startMove asks where to move or copy the nodes to.
+func (m *model) startMove(copying bool) {
+	m.moveTargets = slices.DeleteFunc(slices.Clone(m.projects), func(p db.Project) bool {
+		return p.ID == m.currentProject.ID
+	})
+	if len(m.moveIDs()) == 0 {
+		return
+	}
+	if len(m.moveTargets) == 0 {
+		m.status = "There is no other project to move to"
+		return
+	}
+	m.moveOpts = db.MoveOptions{Copy: copying}
+	m.moveIndex = 0
+	m.state = moveTargetView
+}
+
+// previewMove works out the move to the chosen project with the current
+// options and shows it.
+func (m *model) previewMove() error {
+	plan, err := m.db.PreviewMove(m.moveIDs(), m.moveTargets[m.moveIndex].ID, m.moveOpts)
+	if err != nil {
+		return err
+	}
+	m.movePlan = plan
+	m.state = movePreviewView
+	return nil
+}
+
+// cycleConflictPolicy picks the next way of handling a title that the
+// destination already has.
+func (m *model) cycleConflictPolicy() error {
+	i := slices.Index(conflictPolicies, m.moveOpts.Conflict)
+	m.moveOpts.Conflict = conflictPolicies[(i+1)%len(conflictPolicies)]
+	return m.previewMove()
+}
+
+// move carries out the previewed move and goes back to the project.
+func (m *model) move() error {
+	target := m.moveTargets[m.moveIndex]
+	plan, err := m.db.MoveNodes(m.moveIDs(), target.ID, m.moveOpts)
+	if err != nil {
+		return err
+	}
+	clear(m.marked)
+	if err := m.loadNodes(); err != nil {
+		return err
+	}
+	if m.nodeListIndex >= len(m.nodes) {
+		m.nodeListIndex = max(len(m.nodes)-1, 0)
+	}
+
+	verb := "Moved"
+	if m.moveOpts.Copy {
+		verb = "Copied"
+	}
+	moved, links := 0, 0
+	for _, node := range plan.Nodes {
+		if !node.Skipped {
+			moved++
+		}
+		links += node.Links
+	}
+	for _, change := range plan.Changes {
+		links += change.Links
+	}
+	m.status = fmt.Sprintf("%s %d node(s) to '%s'", verb, moved, target.Name)
+	if links > 0 {
+		m.status += fmt.Sprintf(", updating %d link(s)", links)
+	}
+	m.state = projectView
+	return nil
+}
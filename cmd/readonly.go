@@ -0,0 +1,39 @@
+package cmd
+
+import "slices"
+
+const readOnlyStatus = "Read-only copy: quit the other gbrain to make changes"
+
+// editKeys are the keys, by state, that change the store. They do nothing
+// in a read-only copy; the views they lead to are never reached.
+var editKeys = map[uint][]string{
+	projectsView:    {"n", "d"},
+	projectView:     {"n", "d", "m", "c"},
+	nodeView:        {"e", "d", "g", "a"},
+	attachmentsView: {"ctrl+d"},
+	trashView:       {"r", "p"},
+	historyView:     {"r"},
+	duplicatesView:  {"r", "m", "n"},
+}
+
+func isEditKey(state uint, key string) bool {
+	return slices.Contains(editKeys[state], key)
+}
+
+// setPreference saves a preference, or in a read-only copy keeps it for the
+// session only.
+func (m *model) setPreference(key, value string) error {
+	if m.opts.ReadOnly {
+		m.sessionPrefs[key] = value
+		return nil
+	}
+	return m.db.SetPreference(key, value)
+}
+
+// preference reads a preference, preferring one set for the session.
+func (m *model) preference(key string) (string, error) {
+	if value, ok := m.sessionPrefs[key]; ok {
+		return value, nil
+	}
+	return m.db.GetPreference(key)
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/pixambi/gbrain/internal/db"
+)
+
+func TestReadOnlyBlocksEdits(t *testing.T) {
+	m, store := newMemApp(t, Options{ReadOnly: true}, map[string][]db.Node{
+		"Notes": {{Title: "Alpha", Content: "text"}},
+	})
+	m = sendKeys(m, "n")
+	wantView(t, m, readOnlyStatus)
+	m = sendKeys(m, "enter", "enter", "d")
+	wantView(t, m, readOnlyStatus)
+	if nodes, _ := store.GetNodes(); len(nodes) != 1 {
+		t.Errorf("nodes = %+v", nodes)
+	}
+}
+
+func TestReadOnlySortLastsForSession(t *testing.T) {
+	m, store := newMemApp(t, Options{ReadOnly: true}, map[string][]db.Node{
+		"A": {{Title: "One"}},
+		"B": {{Title: "Two"}},
+	})
+	m = sendKeys(m, "enter", "s")
+	wantView(t, m, "sorted by last modified")
+
+	// Switching projects and back reads the sort mode again.
+	m = sendKeys(m, "esc", "down", "enter", "esc", "up", "enter")
+	wantView(t, m, "Project: A")
+	wantView(t, m, "sorted by last modified")
+
+	if pref, err := store.GetPreference(nodeSortPref(1)); err != nil || pref != "" {
+		t.Errorf("read-only copy saved preference %q, %v", pref, err)
+	}
+}
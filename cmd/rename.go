@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// editContent opens the content of the current node in the editor.
+func (m *model) editContent() tea.Cmd {
+	if m.currentNode.Content == "" {
+		m.textArea.Reset()
+	} else {
+		m.textArea.SetValue(m.currentNode.Content)
+	}
+	m.textArea.Focus()
+	m.state = nodeContentView
+	return textarea.Blink
+}
+
+// startRename works out what renaming the node being edited to title
+// involves. If no links have to be rewritten it goes ahead; otherwise the
+// nodes affected are shown first.
+func (m *model) startRename(title string) (tea.Cmd, error) {
+	plan, err := m.db.PreviewRename(m.currentNode.ID, title)
+	if err != nil {
+		return nil, err
+	}
+	m.renamePlan = plan
+	if len(plan.Changes) == 0 {
+		return m.rename()
+	}
+	m.state = renamePreviewView
+	return nil, nil
+}
+
+// rename carries out the previewed rename, which is saved straight away,
+// and goes on to edit the content of the renamed node.
+func (m *model) rename() (tea.Cmd, error) {
+	plan, err := m.db.RenameNode(m.currentNode.ID, m.renamePlan.Node.Title)
+	if err != nil {
+		return nil, err
+	}
+	m.currentNode = plan.Node
+	m.editBase = plan.Node
+	if err := m.loadNodes(); err != nil {
+		return nil, err
+	}
+	if len(plan.Changes) > 0 {
+		links := 0
+		for _, change := range plan.Changes {
+			links += change.Links
+		}
+		m.status = fmt.Sprintf("Renamed, updating %d link(s) in %d node(s)", links, len(plan.Changes))
+	}
+	return m.editContent(), nil
+}
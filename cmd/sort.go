@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pixambi/gbrain/internal/db"
+	"github.com/pixambi/gbrain/internal/markup"
+)
+
+type sortMode int
+
+const (
+	sortByTitle sortMode = iota
+	sortByModified
+	sortByCreated
+	sortByLinks
+	sortModeCount
+)
+
+// sortModeKeys are the names sort modes are stored under in preferences.
+var sortModeKeys = [...]string{"title", "modified", "created", "links"}
+
+var sortModeLabels = [...]string{"title", "last modified", "created", "number of links"}
+
+func (s sortMode) String() string {
+	return sortModeKeys[s]
+}
+
+func (s sortMode) label() string {
+	return sortModeLabels[s]
+}
+
+func (s sortMode) next() sortMode {
+	return (s + 1) % sortModeCount
+}
+
+// parseSortMode reads a stored sort mode, falling back to sorting by title.
+func parseSortMode(value string) sortMode {
+	for i, key := range sortModeKeys {
+		if key == value {
+			return sortMode(i)
+		}
+	}
+	return sortByTitle
+}
+
+// Preference keys for the remembered sort modes.
+const projectsSortPref = "sort.projects"
+
+func nodeSortPref(projectID int) string {
+	return "sort.project." + strconv.Itoa(projectID)
+}
+
+// linkCount counts a node's links to other nodes, ignoring attachment
+// embeds and links within the node.
+func linkCount(node db.Node) int {
+	n := 0
+	for _, link := range markup.ParseLinks(node.Content) {
+		if !link.Embed && strings.TrimSpace(link.Title) != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func lessTitle(a, b string) bool {
+	return strings.ToLower(a) < strings.ToLower(b)
+}
+
+// sortNodes orders nodes by mode. Time and link orders put the newest or
+// most linked first; ties fall back to title.
+func sortNodes(nodes []db.Node, mode sortMode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		switch mode {
+		case sortByModified:
+			if !a.Modified.Equal(b.Modified) {
+				return a.Modified.After(b.Modified)
+			}
+		case sortByCreated:
+			if !a.Created.Equal(b.Created) {
+				return a.Created.After(b.Created)
+			}
+		case sortByLinks:
+			if la, lb := linkCount(a), linkCount(b); la != lb {
+				return la > lb
+			}
+		}
+		return lessTitle(a.Title, b.Title)
+	})
+}
+
+// sortProjects orders projects by mode. links holds the total number of
+// links in each project's nodes and is only consulted when sorting by links.
+func sortProjects(projects []db.Project, mode sortMode, links map[int]int) {
+	sort.SliceStable(projects, func(i, j int) bool {
+		a, b := projects[i], projects[j]
+		switch mode {
+		case sortByModified:
+			if !a.Modified.Equal(b.Modified) {
+				return a.Modified.After(b.Modified)
+			}
+		case sortByCreated:
+			if !a.Created.Equal(b.Created) {
+				return a.Created.After(b.Created)
+			}
+		case sortByLinks:
+			if links[a.ID] != links[b.ID] {
+				return links[a.ID] > links[b.ID]
+			}
+		}
+		return lessTitle(a.Name, b.Name)
+	})
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pixambi/gbrain/internal/db"
+)
+
+func TestSortNodes(t *testing.T) {
+	day := func(n int) time.Time { return time.Date(2020, 1, n, 0, 0, 0, 0, time.UTC) }
+	nodes := []db.Node{
+		{Title: "beta", Created: day(1), Modified: day(9), Content: "[[a]] [[c]]"},
+		{Title: "Alpha", Created: day(3), Modified: day(4), Content: "[[b]] ![[pic.png]]"},
+		{Title: "gamma", Created: day(2), Modified: day(9), Content: "[[#heading]]"},
+	}
+	for mode, want := range map[sortMode][]string{
+		sortByTitle:    {"Alpha", "beta", "gamma"},
+		sortByModified: {"beta", "gamma", "Alpha"},
+		sortByCreated:  {"Alpha", "gamma", "beta"},
+		sortByLinks:    {"beta", "Alpha", "gamma"},
+	} {
+		sortNodes(nodes, mode)
+		var got []string
+		for _, node := range nodes {
+			got = append(got, node.Title)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("by %s: %q, want %q", mode, got, want)
+		}
+	}
+}
+
+func TestParseSortMode(t *testing.T) {
+	for mode := sortByTitle; mode < sortModeCount; mode++ {
+		if got := parseSortMode(mode.String()); got != mode {
+			t.Errorf("parseSortMode(%q) = %v", mode, got)
+		}
+	}
+	for _, value := range []string{"", "newest"} {
+		if got := parseSortMode(value); got != sortByTitle {
+			t.Errorf("parseSortMode(%q) = %v", value, got)
+		}
+	}
+}
+
+func TestSortRememberedPerProject(t *testing.T) {
+	m, store := newMemApp(t, Options{}, map[string][]db.Node{
+		"A": {{Title: "One"}},
+		"B": {{Title: "Two"}},
+	})
+	// With no links the projects stay in title order.
+	m = sendKeys(m, "s", "s", "s")
+	wantView(t, m, "sorted by number of links")
+	m = sendKeys(m, "enter", "s")
+	wantView(t, m, "sorted by last modified")
+	m = sendKeys(m, "esc", "down", "enter")
+	wantView(t, m, "Project: B")
+	wantView(t, m, "sorted by title")
+
+	// A new session picks up where this one left off.
+	m, _ = NewApp(store, Options{}).Update(tea.WindowSizeMsg{Width: 100, Height: 40})
+	wantView(t, m, "sorted by number of links")
+	m = sendKeys(m, "enter")
+	wantView(t, m, "Project: A")
+	wantView(t, m, "sorted by last modified")
+}
@@ -2,9 +2,14 @@ package cmd
 
 import (
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/pixambi/gbrain/internal/db"
+	"github.com/pixambi/gbrain/internal/diff"
+	"github.com/pixambi/gbrain/internal/markup"
 )
 
 var (
@@ -59,6 +64,37 @@ var (
 	editModeStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color("244"))
 
+	highlightStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("226")).
+			Bold(true)
+
+	propertyKeyStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("244")).
+				Bold(true)
+
+	propertyPanelStyle = lipgloss.NewStyle().
+				BorderStyle(lipgloss.RoundedBorder()).
+				BorderForeground(lipgloss.Color("238")).
+				Padding(0, 1)
+
+	tagStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("141"))
+
+	insertStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("35"))
+
+	deleteStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("196"))
+
+	statusStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("35")).
+			Padding(0, 1)
+
+	conflictPanelStyle = lipgloss.NewStyle().
+				BorderStyle(lipgloss.RoundedBorder()).
+				BorderForeground(lipgloss.Color("238")).
+				Padding(0, 1)
+
 	warningStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color("202")).
 			Bold(true).
@@ -72,12 +108,31 @@ func (m model) View() string {
 
 	var s strings.Builder
 
-	s.WriteString(appNameStyle.Render("Gbrain"))
+	if m.opts.ReadOnly {
+		s.WriteString(lipgloss.JoinHorizontal(lipgloss.Center,
+			appNameStyle.Render("Gbrain"), warningStyle.Render("READ-ONLY COPY")))
+	} else {
+		s.WriteString(appNameStyle.Render("Gbrain"))
+	}
 	s.WriteString("\n\n")
 
 	switch m.state {
+	case lockView:
+		s.WriteString(titleStyle.Render("Locked"))
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("Enter the passphrase to unlock the database."))
+		s.WriteString("\n\n")
+		s.WriteString(m.lockInput.View())
+		if m.lockError != "" {
+			s.WriteString("\n\n")
+			s.WriteString(errorStyle.Render(m.lockError))
+		}
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("enter: unlock • ctrl+c: quit"))
+
 	case projectsView:
 		s.WriteString(titleStyle.Render("Projects"))
+		s.WriteString(infoStyle.Render(fmt.Sprintf("sorted by %s", m.projectSort.label())))
 		s.WriteString("\n\n")
 
 		if len(m.projects) == 0 {
@@ -94,12 +149,16 @@ func (m model) View() string {
 		}
 
 		s.WriteString("\n\n")
-		s.WriteString(infoStyle.Render("j/k: navigate • n: new project • d: delete project • enter: open • q: quit"))
+		help := "j/k: navigate • n: new project • d: delete project • enter: open • s: sort • /: search • #: tags • t: trash • B: backup • q: quit"
+		if m.locker != nil {
+			help += " • ctrl+l: lock"
+		}
+		s.WriteString(infoStyle.Render(help))
 
 	case confirmDeleteProjectView:
 		s.WriteString(warningStyle.Render("Delete Project"))
 		s.WriteString("\n\n")
-		s.WriteString(warningStyle.Render(fmt.Sprintf("Are you sure you want to delete '%s' and all its nodes?", m.currentProject.Name)))
+		s.WriteString(warningStyle.Render(fmt.Sprintf("Move '%s' and all its nodes to the trash?", m.currentProject.Name)))
 		s.WriteString("\n\n")
 		s.WriteString(infoStyle.Render("y: confirm delete • n: cancel"))
 
@@ -112,6 +171,7 @@ func (m model) View() string {
 
 	case projectView:
 		s.WriteString(titleStyle.Render(fmt.Sprintf("Project: %s", m.currentProject.Name)))
+		s.WriteString(infoStyle.Render(fmt.Sprintf("sorted by %s", m.nodeSort.label())))
 		s.WriteString("\n\n")
 
 		if len(m.nodes) == 0 {
@@ -122,18 +182,60 @@ func (m model) View() string {
 				if i == m.nodeListIndex {
 					style = selectedItemStyle
 				}
-				s.WriteString(style.Render(node.Title))
+				title := node.Title
+				if m.marked[node.ID] {
+					title = "* " + title
+				}
+				s.WriteString(style.Render(title))
+				s.WriteString("\n")
+			}
+		}
+
+		if duplicates := findDuplicates(m.nodes); len(duplicates) > 0 {
+			s.WriteString("\n")
+			s.WriteString(warningStyle.Render(fmt.Sprintf("%d node(s) share a title with an older node; press 'D' to resolve", len(duplicates))))
+		}
+
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("j/k: navigate • n: new node • d: delete node • enter: view • space: mark • m: move • c: copy • s: sort • /: search • D: duplicate titles • esc: back"))
+
+	case searchView:
+		if m.searchProjectID == 0 {
+			s.WriteString(titleStyle.Render("Search all projects"))
+		} else {
+			s.WriteString(titleStyle.Render(fmt.Sprintf("Search: %s", m.currentProject.Name)))
+		}
+		s.WriteString("\n\n")
+		s.WriteString(m.searchInput.View())
+		s.WriteString("\n\n")
+
+		if m.searchInput.Value() != "" && len(m.searchResults) == 0 {
+			s.WriteString(infoStyle.Render("No matches."))
+		}
+		for i, result := range m.searchResults {
+			style := itemStyle
+			if i == m.searchIndex {
+				style = selectedItemStyle
+			}
+			title := result.Node.Title
+			if m.searchProjectID == 0 {
+				title = fmt.Sprintf("%s / %s", m.projectName(result.Node.ProjectID), title)
+			}
+			s.WriteString(style.Render(title))
+			s.WriteString("\n")
+			if result.Snippet != "" {
+				s.WriteString(itemStyle.Render(renderHighlights(result.Snippet, result.Highlights)))
 				s.WriteString("\n")
 			}
 		}
 
 		s.WriteString("\n\n")
-		s.WriteString(infoStyle.Render("j/k: navigate • n: new node • d: delete node • enter: view • esc: back"))
+		s.WriteString(infoStyle.Render("type to search • \"phrase\" • prefix* • up/down: navigate • enter: open • esc: back"))
 
 	case confirmDeleteNodeView:
 		s.WriteString(warningStyle.Render("Delete Node"))
 		s.WriteString("\n\n")
-		s.WriteString(warningStyle.Render(fmt.Sprintf("Are you sure you want to delete '%s'?", m.currentNode.Title)))
+		s.WriteString(warningStyle.Render(fmt.Sprintf("Move '%s' to the trash?", m.currentNode.Title)))
 		s.WriteString("\n\n")
 		s.WriteString(infoStyle.Render("y: confirm delete • n: cancel"))
 
@@ -145,6 +247,10 @@ func (m model) View() string {
 		s.WriteString(titleStyle.Render(fmt.Sprintf("%s Node Title", action)))
 		s.WriteString("\n\n")
 		s.WriteString(m.textInput.View())
+		if problem := m.titleError(); problem != "" {
+			s.WriteString("\n")
+			s.WriteString(errorStyle.Render(problem))
+		}
 		s.WriteString("\n\n")
 		s.WriteString(infoStyle.Render("enter: continue to content • esc: cancel"))
 
@@ -155,23 +261,432 @@ func (m model) View() string {
 		s.WriteString("\n\n")
 		s.WriteString(infoStyle.Render("ctrl+s: save • esc: back to title"))
 		s.WriteString("\n")
-		s.WriteString(editModeStyle.Render("Note: Use [[Node Title]] to create links"))
+		s.WriteString(editModeStyle.Render("Note: Use [[Node Title]] to create links, a --- fenced block or key:: value for properties"))
 
 	case nodeView:
 		s.WriteString(titleStyle.Render(m.currentNode.Title))
+		s.WriteString("\n")
+		s.WriteString(infoStyle.Render(fmt.Sprintf("created %s • modified %s",
+			m.currentNode.Created.Format("2006-01-02 15:04"),
+			m.currentNode.Modified.Format("2006-01-02 15:04"))))
+		if len(m.currentNode.Aliases) > 0 {
+			s.WriteString("\n")
+			s.WriteString(infoStyle.Render("formerly " + strings.Join(m.currentNode.Aliases, ", ")))
+		}
+		if tags := m.currentNode.AllTags(); len(tags) > 0 {
+			s.WriteString("\n")
+			s.WriteString(itemStyle.Render(tagStyle.Render("#" + strings.Join(tags, " #"))))
+		}
 		s.WriteString("\n\n")
 
-		content := renderContent(m.currentNode.Content, m.links, m.currentLinkIndex)
-		s.WriteString(content)
+		if len(m.currentNode.Properties) > 0 {
+			s.WriteString(renderProperties(m.currentNode.Properties))
+			s.WriteString("\n")
+		}
+
+		content := m.renderContent(nodeBody(m.currentNode.Content), m.links, m.currentLinkIndex)
+		s.WriteString(m.scrollContent(content))
+
+		if len(m.attachments) > 0 {
+			s.WriteString("\n\n")
+			s.WriteString(headerStyle.Render("Attachments"))
+			s.WriteString("\n")
+			for _, attachment := range m.attachments {
+				s.WriteString(itemStyle.Render(fmt.Sprintf("%s  %s", attachment.Name, infoStyle.Render(fmt.Sprintf("%s • %s", db.FormatSize(int64(attachment.Size)), attachment.MIME)))))
+				s.WriteString("\n")
+			}
+		}
+
+		if len(m.backlinks) > 0 {
+			s.WriteString("\n\n")
+			s.WriteString(headerStyle.Render("Linked from"))
+			s.WriteString("\n")
+			for i, backlink := range m.backlinks {
+				style := linkStyle
+				if len(m.links)+i == m.currentLinkIndex {
+					style = selectedLinkStyle
+				}
+				title := backlink.Title
+				if backlink.ProjectID != m.currentNode.ProjectID {
+					title = m.projectName(backlink.ProjectID) + " › " + title
+				}
+				s.WriteString(itemStyle.Render(style.Render(title)))
+				s.WriteString("\n")
+				if backlink.Snippet != "" {
+					s.WriteString(itemStyle.Render(infoStyle.Render(backlink.Snippet)))
+					s.WriteString("\n")
+				}
+			}
+		}
 
 		s.WriteString("\n\n")
 
-		if len(m.links) > 0 {
-			s.WriteString(infoStyle.Render("tab: cycle links • enter: follow link • b: go back • e: edit • d: delete • esc: back"))
+		if len(m.links) > 0 || len(m.backlinks) > 0 {
+			s.WriteString(infoStyle.Render("j/k: scroll • tab: cycle links • enter: follow link • b: go back • e: edit • g: tags • a: attach • x: export • h: history • d: delete • esc: back"))
 		} else {
-			s.WriteString(infoStyle.Render("e: edit • d: delete • esc: back"))
+			s.WriteString(infoStyle.Render("j/k: scroll • e: edit • g: tags • a: attach • x: export • h: history • d: delete • esc: back"))
+		}
+
+	case tagsView:
+		s.WriteString(titleStyle.Render("Tags"))
+		s.WriteString("\n\n")
+
+		if len(m.tags) == 0 {
+			s.WriteString(infoStyle.Render("No tags yet. Add #tags to a note or press 'g' on one."))
+		}
+		for i, tag := range m.tags {
+			style := itemStyle
+			if i == m.tagIndex {
+				style = selectedItemStyle
+			}
+			s.WriteString(style.Render(fmt.Sprintf("#%s (%d)", tag.Name, tag.Count)))
+			s.WriteString("\n")
+		}
+
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("j/k: navigate • enter: show nodes • esc: back"))
+
+	case tagNodesView:
+		s.WriteString(titleStyle.Render(fmt.Sprintf("Tag: #%s", m.tags[m.tagIndex].Name)))
+		s.WriteString("\n\n")
+
+		for i, node := range m.tagNodes {
+			style := itemStyle
+			if i == m.tagNodeIndex {
+				style = selectedItemStyle
+			}
+			s.WriteString(style.Render(fmt.Sprintf("%s / %s", m.projectName(node.ProjectID), node.Title)))
+			s.WriteString("\n")
+		}
+
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("j/k: navigate • enter: view • esc: back"))
+
+	case tagEditView:
+		s.WriteString(titleStyle.Render(fmt.Sprintf("Tags: %s", m.currentNode.Title)))
+		s.WriteString("\n\n")
+		s.WriteString(m.tagInput.View())
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("enter: save • esc: cancel"))
+		s.WriteString("\n")
+		s.WriteString(editModeStyle.Render("Note: #tags written in the content are added automatically"))
+
+	case attachFileView:
+		s.WriteString(titleStyle.Render(fmt.Sprintf("Attach to: %s", m.currentNode.Title)))
+		s.WriteString("\n\n")
+		s.WriteString(m.pathInput.View())
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("enter: attach • esc: cancel"))
+
+	case attachmentsView:
+		s.WriteString(titleStyle.Render(fmt.Sprintf("Attachments: %s", m.currentNode.Title)))
+		s.WriteString("\n\n")
+		for i, attachment := range m.attachments {
+			style := itemStyle
+			if i == m.attachmentIndex {
+				style = selectedItemStyle
+			}
+			s.WriteString(style.Render(fmt.Sprintf("%s (%s)", attachment.Name, db.FormatSize(int64(attachment.Size)))))
+			s.WriteString("\n")
 		}
+		s.WriteString("\n")
+		s.WriteString(m.pathInput.View())
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("up/down: select • enter: export to directory • ctrl+d: remove attachment • esc: back"))
+
+	case trashView:
+		s.WriteString(titleStyle.Render("Trash"))
+		s.WriteString("\n\n")
+
+		if len(m.trash) == 0 {
+			s.WriteString(infoStyle.Render("The trash is empty."))
+			s.WriteString("\n\n")
+			s.WriteString(infoStyle.Render("esc: back"))
+			break
+		}
+
+		for i, item := range m.trash {
+			style := itemStyle
+			if i == m.trashIndex {
+				style = selectedItemStyle
+			}
+			var label string
+			if item.Kind == db.TrashProject {
+				label = fmt.Sprintf("Project: %s (%d nodes)", item.Project.Name, len(item.Nodes))
+			} else {
+				label = fmt.Sprintf("%s / %s", item.Project.Name, item.Nodes[0].Title)
+			}
+			s.WriteString(style.Render(fmt.Sprintf("%s  %s", item.DeletedAt.Format("2006-01-02 15:04"), label)))
+			s.WriteString("\n")
+		}
+
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("j/k: navigate • r: restore • p: delete permanently • esc: back"))
+
+	case confirmPurgeView:
+		s.WriteString(warningStyle.Render("Delete Permanently"))
+		s.WriteString("\n\n")
+		s.WriteString(warningStyle.Render(fmt.Sprintf("Permanently delete %s and its history? This cannot be undone.", trashItemName(m.trash[m.trashIndex]))))
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("y: confirm delete • n: cancel"))
+
+	case renamePreviewView:
+		plan := m.renamePlan
+		s.WriteString(titleStyle.Render(fmt.Sprintf("Rename: %s → %s", m.currentNode.Title, plan.Node.Title)))
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render(fmt.Sprintf("Links to '%s' in these nodes will be updated:", m.currentNode.Title)))
+		s.WriteString("\n\n")
+		for _, change := range plan.Changes {
+			title := change.Title
+			if change.NodeID == plan.Node.ID {
+				title += " (this node)"
+			}
+			s.WriteString(itemStyle.Render(fmt.Sprintf("• %s: %d link(s)", title, change.Links)))
+			s.WriteString("\n")
+		}
+		s.WriteString("\n")
+		if slices.Contains(plan.Node.Aliases, m.currentNode.Title) {
+			s.WriteString(infoStyle.Render(fmt.Sprintf("'%s' is kept as an alias, so links to it elsewhere still work. The rename is saved straight away.", m.currentNode.Title)))
+		} else {
+			s.WriteString(infoStyle.Render("The rename is saved straight away."))
+		}
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("y: rename and update links • n: back to the title"))
+
+	case moveTargetView:
+		verb := "Move"
+		if m.moveOpts.Copy {
+			verb = "Copy"
+		}
+		s.WriteString(titleStyle.Render(fmt.Sprintf("%s %d node(s) to", verb, len(m.moveIDs()))))
+		s.WriteString("\n\n")
+		for i, project := range m.moveTargets {
+			style := itemStyle
+			if i == m.moveIndex {
+				style = selectedItemStyle
+			}
+			s.WriteString(style.Render(project.Name))
+			s.WriteString("\n")
+		}
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("j/k: navigate • enter: choose • esc: cancel"))
+
+	case movePreviewView:
+		s.WriteString(m.renderMovePlan())
+
+	case duplicatesView:
+		s.WriteString(titleStyle.Render(fmt.Sprintf("Duplicate titles: %s", m.currentProject.Name)))
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("Links can reach only one node with each title. Each of these newer nodes needs a title of its own:"))
+		s.WriteString("\n\n")
+		for i, d := range m.duplicates {
+			style := itemStyle
+			if i == m.duplicateIndex {
+				style = selectedItemStyle
+			}
+			s.WriteString(style.Render(fmt.Sprintf("%s  created %s, older one %s", d.node.Title,
+				d.node.Created.Format("2006-01-02 15:04"), d.owner.Created.Format("2006-01-02 15:04"))))
+			s.WriteString("\n")
+		}
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("j/k: navigate • r: rename • m: merge into the older node • n: keep both, numbering this one • esc: back"))
+
+	case conflictView:
+		s.WriteString(titleStyle.Render(fmt.Sprintf("Conflict: %s", m.conflict.Mine.Title)))
+		s.WriteString("\n\n")
+		s.WriteString(m.renderConflict())
+		s.WriteString("\n\n")
+		if m.conflict.Theirs.ID == 0 {
+			s.WriteString(infoStyle.Render("m: save mine as a new node • t: discard mine • esc: back to editing"))
+		} else {
+			s.WriteString(infoStyle.Render("m: keep mine • t: keep theirs • e: merge and edit • esc: back to editing"))
+		}
+
+	case historyView:
+		s.WriteString(titleStyle.Render(fmt.Sprintf("History: %s", m.currentNode.Title)))
+		s.WriteString("\n\n")
+
+		if len(m.revisions) == 0 {
+			s.WriteString(infoStyle.Render("No revisions recorded."))
+			s.WriteString("\n\n")
+			s.WriteString(infoStyle.Render("esc: back"))
+			break
+		}
+
+		for i, revision := range m.revisions {
+			style := itemStyle
+			if i == m.revisionIndex {
+				style = selectedItemStyle
+			}
+			marker := " "
+			if i == m.compareIndex {
+				marker = "*"
+			}
+			s.WriteString(style.Render(fmt.Sprintf("%s #%d  %s  %s", marker, revision.ID, revision.Time.Format("2006-01-02 15:04"), revision.Title)))
+			s.WriteString("\n")
+		}
+
+		s.WriteString("\n")
+		s.WriteString(m.renderRevisionDiff())
+		s.WriteString("\n\n")
+		s.WriteString(infoStyle.Render("j/k: navigate • space: mark for comparison • r: restore • esc: back"))
 	}
 
+	if m.status != "" {
+		s.WriteString("\n\n")
+		s.WriteString(statusStyle.Render(m.status))
+	}
+
+	return s.String()
+}
+
+// renderHighlights renders text with the given byte ranges emphasised.
+func renderHighlights(text string, highlights [][2]int) string {
+	var result strings.Builder
+	lastEnd := 0
+	for _, h := range highlights {
+		result.WriteString(text[lastEnd:h[0]])
+		result.WriteString(highlightStyle.Render(text[h[0]:h[1]]))
+		lastEnd = h[1]
+	}
+	result.WriteString(text[lastEnd:])
+	return result.String()
+}
+
+// renderRevisionDiff shows the changes between the compared revision and
+// the selected one.
+func (m model) renderRevisionDiff() string {
+	selected := m.revisions[m.revisionIndex]
+	var base db.Revision
+	switch {
+	case m.compareIndex >= 0:
+		base = m.revisions[m.compareIndex]
+	case m.revisionIndex+1 < len(m.revisions):
+		base = m.revisions[m.revisionIndex+1]
+	}
+
+	var s strings.Builder
+	if base.ID == 0 {
+		s.WriteString(headerStyle.Render(fmt.Sprintf("Revision #%d", selected.ID)))
+	} else {
+		s.WriteString(headerStyle.Render(fmt.Sprintf("Changes from #%d to #%d", base.ID, selected.ID)))
+	}
+	s.WriteString("\n")
+
+	if base.ID != 0 && base.Title != selected.Title {
+		s.WriteString(deleteStyle.Render("- title: " + base.Title))
+		s.WriteString("\n")
+		s.WriteString(insertStyle.Render("+ title: " + selected.Title))
+		s.WriteString("\n")
+	}
+	for _, line := range diff.Lines(base.Content, selected.Content) {
+		switch line.Op {
+		case diff.Insert:
+			s.WriteString(insertStyle.Render("+ " + line.Text))
+		case diff.Delete:
+			s.WriteString(deleteStyle.Render("- " + line.Text))
+		default:
+			s.WriteString(editModeStyle.Render("  " + line.Text))
+		}
+		s.WriteString("\n")
+	}
+	return s.String()
+}
+
+// renderConflict shows the stored node and the edited one side by side.
+func (m model) renderConflict() string {
+	mine, theirs := m.conflict.Mine, m.conflict.Theirs
+	if theirs.ID == 0 {
+		return warningStyle.Render("This node was deleted after you started editing it.") + "\n\n" +
+			conflictPanelStyle.Render(conflictPanel("Mine", mine, m.height-14))
+	}
+
+	width := max((m.width-8)/2, 20)
+	lines := m.height - 16
+	return warningStyle.Render("This node was changed elsewhere after you started editing it.") + "\n\n" +
+		lipgloss.JoinHorizontal(lipgloss.Top,
+			conflictPanelStyle.Width(width).Render(conflictPanel("Mine", mine, lines)),
+			" ",
+			conflictPanelStyle.Width(width).Render(conflictPanel(
+				fmt.Sprintf("Theirs, saved %s", theirs.Modified.Format("2006-01-02 15:04")), theirs, lines)))
+}
+
+// conflictPanel renders a version of a node in at most maxLines lines of
+// content.
+func conflictPanel(label string, node db.Node, maxLines int) string {
+	lines := strings.Split(strings.TrimSuffix(node.Content, "\n"), "\n")
+	if maxLines = max(maxLines, 5); len(lines) > maxLines {
+		lines = append(lines[:maxLines-1], editModeStyle.Render(fmt.Sprintf("… %d more lines", len(lines)-maxLines+1)))
+	}
+	return headerStyle.Render(label) + "\n" + propertyKeyStyle.Render(node.Title) + "\n\n" + strings.Join(lines, "\n")
+}
+
+// renderProperties renders a node's properties as an aligned key/value
+// panel, in key order.
+func renderProperties(props map[string]markup.Value) string {
+	keys := make([]string, 0, len(props))
+	width := 0
+	for key := range props {
+		keys = append(keys, key)
+		width = max(width, len(key))
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = propertyKeyStyle.Render(fmt.Sprintf("%-*s", width, key)) + "  " + props[key].String()
+	}
+	return propertyPanelStyle.Render(strings.Join(lines, "\n"))
+}
+
+// renderMovePlan describes the previewed move or copy and its options.
+func (m model) renderMovePlan() string {
+	var s strings.Builder
+	plan, opts := m.movePlan, m.moveOpts
+	target := m.moveTargets[m.moveIndex]
+	verb := "Move"
+	if opts.Copy {
+		verb = "Copy"
+	}
+	s.WriteString(titleStyle.Render(fmt.Sprintf("%s to %s", verb, target.Name)))
+	s.WriteString("\n\n")
+
+	for _, node := range plan.Nodes {
+		line := m.nodeTitle(node.NodeID)
+		switch {
+		case node.Skipped:
+			line += fmt.Sprintf(": skipped, '%s' already has it", target.Name)
+		case node.Conflict != 0 && opts.Conflict == db.ConflictMerge:
+			line += fmt.Sprintf(": merged into the node already in '%s'", target.Name)
+		case node.Title != m.nodeTitle(node.NodeID):
+			line += fmt.Sprintf(" → %s", node.Title)
+		}
+		if node.Linked {
+			line += " (linked)"
+		}
+		if node.Links > 0 {
+			line += fmt.Sprintf(", %d link(s) updated", node.Links)
+		}
+		s.WriteString(itemStyle.Render("• " + line))
+		s.WriteString("\n")
+	}
+	if len(plan.Changes) > 0 {
+		s.WriteString("\n")
+		s.WriteString(infoStyle.Render("Links in these nodes will be updated to keep them working:"))
+		s.WriteString("\n")
+		for _, change := range plan.Changes {
+			s.WriteString(itemStyle.Render(fmt.Sprintf("• %s: %d link(s)", change.Title, change.Links)))
+			s.WriteString("\n")
+		}
+	}
+
+	linked := "off"
+	if opts.Linked {
+		linked = "on"
+	}
+	s.WriteString("\n")
+	s.WriteString(infoStyle.Render(fmt.Sprintf("Take linked nodes along: %s • When the title is taken: %s", linked, opts.Conflict)))
+	s.WriteString("\n\n")
+	s.WriteString(infoStyle.Render(fmt.Sprintf("y: %s • l: toggle linked nodes • tab: change what happens to taken titles • esc: back", strings.ToLower(verb))))
 	return s.String()
 }
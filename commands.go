@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+	"github.com/pixambi/gbrain/internal/db"
+)
+
+// env is what a command needs to know about where notes are kept.
+type env struct {
+	dbPath     string
+	notesDir   string
+	backupDir  string
+	backupKeep int
+	opts       *db.Options
+}
+
+type command struct {
+	usage string
+	run   func(e env, args []string) error
+}
+
+// commands run in place of the terminal UI when named on the command line.
+var commands = map[string]command{
+	"encrypt": {"encrypt the database with a passphrase", runEncrypt},
+	"decrypt": {"remove encryption from the database", runDecrypt},
+	"passwd":  {"change the passphrase of an encrypted database", runPasswd},
+	"backup":  {"write a snapshot of the database to [file] or the backup directory", runBackup},
+	"restore": {"replace the database with the snapshot in <file>", runRestore},
+	"check":   {"look for damaged records and broken links; --repair fixes them", runCheck},
+	"compact": {"shrink the database file; --secure also purges the trash and wipes the old file and, if asked, the backups", runCompact},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: gbrain [flags] [command]\n\nFlags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+func runCommand(e env, args []string) error {
+	c, ok := commands[args[0]]
+	if !ok {
+		usage()
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return c.run(e, args[1:])
+}
+
+// openDb opens the database for a command that only makes sense for it,
+// rather than for a markdown directory.
+func openDb(e env, name string) (*db.Db, error) {
+	if e.notesDir != "" {
+		return nil, fmt.Errorf("%s only applies to the database, not to a notes directory", name)
+	}
+	store, err := db.NewDb(e.dbPath, e.opts)
+	if errors.Is(err, db.ErrInUse) {
+		return nil, fmt.Errorf("%w; quit it before running %s", err, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Init(); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("initializing database: %w", err)
+	}
+	return store, nil
+}
+
+func runEncrypt(e env, args []string) error {
+	store, err := openDb(e, "encrypt")
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if store.Encrypted() {
+		return fmt.Errorf("the database is already encrypted; use passwd to change the passphrase")
+	}
+
+	passphrase, err := readNewPassphrase("New passphrase: ")
+	if err != nil {
+		return err
+	}
+	if err := store.EnableEncryption(passphrase); err != nil {
+		return err
+	}
+	fmt.Println("Database encrypted.")
+	warnPlainBackups(e)
+	return nil
+}
+
+func runDecrypt(e env, args []string) error {
+	store, err := openDb(e, "decrypt")
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if !store.Encrypted() {
+		return fmt.Errorf("the database is not encrypted")
+	}
+
+	if err := unlock(store); err != nil {
+		return err
+	}
+	if err := store.DisableEncryption(); err != nil {
+		return err
+	}
+	fmt.Println("Database decrypted.")
+	return nil
+}
+
+func runPasswd(e env, args []string) error {
+	store, err := openDb(e, "passwd")
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if !store.Encrypted() {
+		return fmt.Errorf("the database is not encrypted; use encrypt to set a passphrase")
+	}
+
+	if err := unlock(store); err != nil {
+		return err
+	}
+	passphrase, err := readNewPassphrase("New passphrase: ")
+	if err != nil {
+		return err
+	}
+	if err := store.ChangePassphrase(passphrase); err != nil {
+		return err
+	}
+	fmt.Println("Passphrase changed.")
+	return nil
+}
+
+func runBackup(e env, args []string) error {
+	if e.notesDir != "" {
+		return fmt.Errorf("backup only applies to the database; copy the notes directory instead")
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("usage: gbrain backup [file]")
+	}
+	store, err := db.NewDbReadOnly(e.dbPath)
+	if errors.Is(err, db.ErrInUse) {
+		return fmt.Errorf("%w; use the backup key (B) in the running app instead", err)
+	}
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	path := ""
+	if len(args) == 1 {
+		path = args[0]
+		err = store.Snapshot(path)
+	} else {
+		path, err = store.Backup(e.backupDir, e.backupKeep)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Backed up to %s\n", path)
+	return nil
+}
+
+func runRestore(e env, args []string) error {
+	if e.notesDir != "" {
+		return fmt.Errorf("restore only applies to the database")
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: gbrain restore <file>")
+	}
+	info, err := db.ValidateSnapshot(args[0])
+	if err != nil {
+		return fmt.Errorf("%s cannot be restored: %w", args[0], err)
+	}
+	saved, err := db.Restore(args[0], e.dbPath)
+	if errors.Is(err, db.ErrInUse) {
+		return fmt.Errorf("%w; quit it before restoring", err)
+	}
+	if err != nil {
+		return err
+	}
+	if info.Encrypted {
+		fmt.Printf("Restored %s (encrypted).\n", args[0])
+	} else {
+		fmt.Printf("Restored %s: %d projects, %d nodes.\n", args[0], info.Projects, info.Nodes)
+	}
+	fmt.Printf("The previous database was saved as %s\n", saved)
+	return nil
+}
+
+func runCheck(e env, args []string) error {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "fix the problems found")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	store, err := openDb(e, "check")
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if store.Locked() {
+		if err := unlock(store); err != nil {
+			return err
+		}
+	}
+
+	report, err := store.Check(*repair)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Checked %d projects and %d nodes.\n", report.Projects, report.Nodes)
+	for _, problem := range report.Problems {
+		fmt.Printf("%-18s %s: %s\n", problem.Kind, problem.Where, problem.Detail)
+		if problem.Repair != "" {
+			fmt.Printf("%-18s -> %s\n", "", problem.Repair)
+		}
+	}
+	for _, notice := range report.Notices {
+		fmt.Printf("%-18s %s: %s\n", notice.Kind, notice.Where, notice.Detail)
+	}
+	switch {
+	case len(report.Problems) == 0:
+		fmt.Println("No problems found.")
+	case *repair:
+		fmt.Printf("Repaired %d problem(s). The database before repair was saved as %s\n", len(report.Problems), report.Backup)
+	default:
+		return fmt.Errorf("%d problem(s) found; run gbrain check --repair to fix them", len(report.Problems))
+	}
+	return nil
+}
+
+func runCompact(e env, args []string) error {
+	flags := flag.NewFlagSet("compact", flag.ContinueOnError)
+	secure := flags.Bool("secure", false, "purge the trash and the history of deleted notes, then overwrite the old file")
+	wipeBackups := flags.Bool("wipe-backups", false, "with --secure, also overwrite and remove every backup without asking")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	store, err := openDb(e, "compact")
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := store.Compact(*secure)
+	if err != nil {
+		return err
+	}
+	for _, row := range []struct {
+		label string
+		stats db.FileStats
+	}{{"Before", report.Before}, {"After", report.After}} {
+		fmt.Printf("%-7s %10s  %6d pages of %d bytes, %d free\n",
+			row.label+":", db.FormatSize(row.stats.Size), row.stats.Pages, row.stats.PageSize, row.stats.FreePages)
+	}
+	fmt.Printf("Saved %s.\n", db.FormatSize(report.Before.Size-report.After.Size))
+	if *secure {
+		fmt.Printf("Purged %d note(s) from the trash and %d revision(s) of deleted notes, and overwrote the old file.\n",
+			len(report.Purged.NodeIDs), report.Purged.Revisions)
+		fmt.Println("Earlier revisions of existing notes are kept.")
+		if warnBackups(e, "These backups still hold deleted notes:") &&
+			(*wipeBackups || confirm("Overwrite and remove them? [y/N] ")) {
+			return wipeAllBackups(e)
+		}
+	}
+	return nil
+}
+
+// wipeAllBackups overwrites and removes the backups listed by warnBackups.
+func wipeAllBackups(e env) error {
+	backups := listBackups(e)
+	for _, path := range backups {
+		if err := db.WipeFile(path); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Wiped %d backup(s).\n", len(backups))
+	return nil
+}
+
+func unlock(store *db.Db) error {
+	passphrase, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	return store.Unlock(passphrase)
+}
+
+// readNewPassphrase asks for a passphrase twice.
+func readNewPassphrase(prompt string) ([]byte, error) {
+	passphrase, err := readPassphrase(prompt)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+	again, err := readPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, again) {
+		return nil, fmt.Errorf("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// readPassphrase prompts on stderr and reads a line from the terminal
+// without echoing it, or a plain line when input is not a terminal.
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if term.IsTerminal(os.Stdin.Fd()) {
+		passphrase, err := term.ReadPassword(os.Stdin.Fd())
+		fmt.Fprintln(os.Stderr)
+		return passphrase, err
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return nil, fmt.Errorf("reading passphrase: %w", err)
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// confirm asks a yes or no question on stderr, taking anything but yes as
+// no. It is always no when input is not a terminal.
+func confirm(prompt string) bool {
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		return false
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	line, _ := stdin.ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+// warnPlainBackups points out backups taken before the database was
+// encrypted, which still hold everything in plain text.
+func warnPlainBackups(e env) {
+	if warnBackups(e, "These backups were made before encrypting and are not encrypted:") {
+		fmt.Println("Delete them if they should not be readable without the passphrase.")
+	}
+}
+
+// listBackups returns the .bak copies next to the database, taken before
+// migrating, repairing and the like, and the snapshots in the backup
+// directory.
+func listBackups(e env) []string {
+	backups, _ := filepath.Glob(e.dbPath + ".*.bak")
+	snapshots, _ := db.ListBackups(e.backupDir)
+	return append(backups, snapshots...)
+}
+
+// warnBackups lists the .bak copies next to the database and the snapshots
+// in the backup directory under heading, and reports whether there were any.
+func warnBackups(e env, heading string) bool {
+	backups := listBackups(e)
+	if len(backups) == 0 {
+		return false
+	}
+	fmt.Println(heading)
+	for _, path := range backups {
+		fmt.Printf("  %s\n", path)
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pixambi/gbrain/internal/db"
+)
+
+// newTestEnv returns an env whose database and backups live in a temporary
+// directory, with a database holding project "P" and a note "Note".
+func newTestEnv(t *testing.T) env {
+	t.Helper()
+	dir := t.TempDir()
+	e := env{dbPath: filepath.Join(dir, "gbrain.db"), backupDir: filepath.Join(dir, "backups"), backupKeep: 3}
+	store, err := openDb(e, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.AddProject(db.Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddNode(db.Node{ProjectID: 1, Title: "Note", Content: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestCompactSecureWipesBackups(t *testing.T) {
+	e := newTestEnv(t)
+	if err := runBackup(e, nil); err != nil {
+		t.Fatal(err)
+	}
+	bak := e.dbPath + ".repair-20240101-000000.bak"
+	if err := os.WriteFile(bak, []byte("old notes"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without being asked the backups stay.
+	if err := runCompact(e, []string{"-secure"}); err != nil {
+		t.Fatal(err)
+	}
+	if backups := listBackups(e); len(backups) != 2 {
+		t.Fatalf("backups = %v", backups)
+	}
+
+	if err := runCompact(e, []string{"-secure", "-wipe-backups"}); err != nil {
+		t.Fatal(err)
+	}
+	if backups := listBackups(e); len(backups) != 0 {
+		t.Errorf("backups after wiping = %v", backups)
+	}
+	if _, err := os.Stat(bak); !os.IsNotExist(err) {
+		t.Errorf("stat %s = %v", bak, err)
+	}
+}
+
+// noteContent returns the content of note 1 in e's database.
+func noteContent(t *testing.T, e env) string {
+	t.Helper()
+	store, err := openDb(e, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	node, err := store.GetNode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node.Content
+}
+
+// setNoteContent changes the content of note 1 in e's database.
+func setNoteContent(t *testing.T, e env, content string) {
+	t.Helper()
+	store, err := openDb(e, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	node, err := store.GetNode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Content = content
+	if err := store.UpdateNode(node); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupRestoreCommands(t *testing.T) {
+	e := newTestEnv(t)
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := runBackup(e, []string{snapshot}); err != nil {
+		t.Fatal(err)
+	}
+	setNoteContent(t, e, "second")
+
+	if err := runRestore(e, []string{snapshot}); err != nil {
+		t.Fatal(err)
+	}
+	if got := noteContent(t, e); got != "first" {
+		t.Errorf("content after restoring = %q, want %q", got, "first")
+	}
+	saved, _ := filepath.Glob(e.dbPath + ".pre-restore-*.bak")
+	if len(saved) != 1 {
+		t.Fatalf("pre-restore copies = %v", saved)
+	}
+	if got := noteContent(t, env{dbPath: saved[0]}); got != "second" {
+		t.Errorf("content of the replaced database = %q, want %q", got, "second")
+	}
+
+	if err := runRestore(e, []string{filepath.Join(t.TempDir(), "missing.db")}); err == nil {
+		t.Error("restored a missing snapshot")
+	}
+}
+
+func TestBackupRestoreWhileOpen(t *testing.T) {
+	e := newTestEnv(t)
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := runBackup(e, []string{snapshot}); err != nil {
+		t.Fatal(err)
+	}
+	setNoteContent(t, e, "second")
+
+	store, err := openDb(e, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runBackup(e, nil); !errors.Is(err, db.ErrInUse) {
+		t.Errorf("backup of an open database = %v, want ErrInUse", err)
+	}
+	if err := runRestore(e, []string{snapshot}); !errors.Is(err, db.ErrInUse) {
+		t.Errorf("restore over an open database = %v, want ErrInUse", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := noteContent(t, e); got != "second" {
+		t.Errorf("content after a refused restore = %q", got)
+	}
+}
+
+func TestBackupOnStart(t *testing.T) {
+	e := newTestEnv(t)
+	if err := os.MkdirAll(e.backupDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"gbrain-20240101-000000.db", "gbrain-20240102-000000.db", "gbrain-20240103-000000.db"} {
+		if err := runBackup(e, []string{filepath.Join(e.backupDir, name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := openDb(e, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if err := backupOnStart(store, e.backupDir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ := db.ListBackups(e.backupDir); len(backups) != 3 {
+		t.Errorf("backups with backups turned off = %v", backups)
+	}
+	if err := backupOnStart(db.NewMemStore(nil), e.backupDir, 2); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ := db.ListBackups(e.backupDir); len(backups) != 3 {
+		t.Errorf("backups after starting on a markdown store = %v", backups)
+	}
+
+	if err := backupOnStart(store, e.backupDir, 2); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := db.ListBackups(e.backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || filepath.Base(backups[0]) != "gbrain-20240103-000000.db" {
+		t.Errorf("backups kept = %v", backups)
+	}
+
+	// A read-only copy leaves backups to the gbrain that has the file.
+	readOnly, err := db.NewDbCopy(e.dbPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	if err := backupOnStart(readOnly, e.backupDir, 1); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := db.ListBackups(e.backupDir); !slices.Equal(after, backups) {
+		t.Errorf("backups after starting read-only = %v", after)
+	}
+}
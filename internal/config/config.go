@@ -0,0 +1,64 @@
+// Package config loads user settings from ~/.gbrain/config.json.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// Config holds user-tunable settings. Fields missing from the file keep
+// their defaults.
+type Config struct {
+	// RevisionLimit is the number of revisions kept for each note; 0 keeps
+	// every revision.
+	RevisionLimit int `json:"revision_limit"`
+	// RevisionMaxAgeDays discards revisions older than this many days,
+	// always keeping the newest; 0 disables age-based pruning.
+	RevisionMaxAgeDays int `json:"revision_max_age_days"`
+	// TrashMaxAgeDays purges deleted notes and projects this many days
+	// after deletion; 0 keeps them until purged by hand.
+	TrashMaxAgeDays int `json:"trash_max_age_days"`
+	// NotesDir, if set, stores notes as markdown files in this directory
+	// instead of in ~/.gbrain/gbrain.db. A leading ~/ is the home directory.
+	NotesDir string `json:"notes_dir"`
+	// LockAfterMinutes locks an encrypted database after this many minutes
+	// without a key press; 0 never locks it automatically.
+	LockAfterMinutes int `json:"lock_after_minutes"`
+	// BackupDir is where backups are written; empty means
+	// ~/.gbrain/backups. A leading ~/ is the home directory.
+	BackupDir string `json:"backup_dir"`
+	// BackupKeep is the number of backups kept in BackupDir. A backup is
+	// taken each time gbrain starts; 0 disables those and keeps every
+	// backup taken by hand.
+	BackupKeep int `json:"backup_keep"`
+}
+
+func Default() Config {
+	return Config{
+		RevisionLimit:    100,
+		TrashMaxAgeDays:  30,
+		LockAfterMinutes: 15,
+		BackupKeep:       7,
+	}
+}
+
+// Load reads the config file at path, returning the defaults if it does not
+// exist.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		return cfg, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,307 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Attachment contents are stored once per distinct SHA-256 hash and shared
+// by every node that attaches the same bytes:
+//
+//	attachments/blobs/<hash>                 -> file contents
+//	attachments/refs/<hash>/<nodeID><name>   -> ""
+//	attachments/nodes/<nodeID>/<name>        -> JSON Attachment
+//
+// A blob is deleted when its last reference goes. In an encrypted database
+// the hash and name are keyed hashes and the values are sealed.
+
+type Attachment struct {
+	NodeID int
+	Name   string
+	Hash   string
+	Size   int
+	MIME   string
+	Added  time.Time
+}
+
+// FormatSize renders a byte count for display.
+func FormatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+type attachmentBuckets struct {
+	blobs, refs, nodes *bbolt.Bucket
+}
+
+func (t *Tx) attachmentBuckets() (attachmentBuckets, error) {
+	root, err := t.bucket("attachments")
+	if err != nil {
+		return attachmentBuckets{}, err
+	}
+	b := attachmentBuckets{
+		blobs: root.Bucket([]byte("blobs")),
+		refs:  root.Bucket([]byte("refs")),
+		nodes: root.Bucket([]byte("nodes")),
+	}
+	if b.blobs == nil || b.refs == nil || b.nodes == nil {
+		return b, fmt.Errorf("bucket attachments is incomplete")
+	}
+	return b, nil
+}
+
+// attachmentBlobsPath is the path of the bucket of attachment contents.
+const attachmentBlobsPath = "attachments/blobs"
+
+// attachmentPath is the path of a node's attachment bucket.
+func attachmentPath(nodeID int) string {
+	return fmt.Sprintf("attachments/nodes/%d", nodeID)
+}
+
+func attachmentRefKey(nodeID int, name string) []byte {
+	return append(itob(nodeID), name...)
+}
+
+// attachmentName reduces a path to the file name it is stored under.
+func attachmentName(name string) (string, error) {
+	name = filepath.Base(name)
+	if name == "." || name == "/" || name == "" {
+		return "", fmt.Errorf("invalid attachment name")
+	}
+	return name, nil
+}
+
+// AddAttachment stores data as an attachment of a node, replacing any
+// attachment of the same name.
+func (d *Db) AddAttachment(nodeID int, name string, data []byte) (Attachment, error) {
+	var attachment Attachment
+	err := d.Update(func(tx *Tx) error {
+		var err error
+		attachment, err = tx.AddAttachment(nodeID, name, data)
+		return err
+	})
+	return attachment, err
+}
+
+// GetAttachments lists a node's attachments by name.
+func (d *Db) GetAttachments(nodeID int) ([]Attachment, error) {
+	var attachments []Attachment
+	err := d.View(func(tx *Tx) error {
+		var err error
+		attachments, err = tx.GetAttachments(nodeID)
+		return err
+	})
+	return attachments, err
+}
+
+// GetAttachmentData returns an attachment and its contents.
+func (d *Db) GetAttachmentData(nodeID int, name string) (Attachment, []byte, error) {
+	var attachment Attachment
+	var data []byte
+	err := d.View(func(tx *Tx) error {
+		var err error
+		attachment, data, err = tx.GetAttachmentData(nodeID, name)
+		return err
+	})
+	return attachment, data, err
+}
+
+func (d *Db) RemoveAttachment(nodeID int, name string) error {
+	return d.Update(func(tx *Tx) error {
+		return tx.RemoveAttachment(nodeID, name)
+	})
+}
+
+// newAttachment describes data stored under name, which must already have
+// been through attachmentName.
+func newAttachment(nodeID int, name string, data []byte) Attachment {
+	sum := sha256.Sum256(data)
+	mimeType := mime.TypeByExtension(filepath.Ext(name))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	return Attachment{
+		NodeID: nodeID,
+		Name:   name,
+		Hash:   hex.EncodeToString(sum[:]),
+		Size:   len(data),
+		MIME:   mimeType,
+		Added:  time.Now(),
+	}
+}
+
+func (t *Tx) AddAttachment(nodeID int, name string, data []byte) (Attachment, error) {
+	name, err := attachmentName(name)
+	if err != nil {
+		return Attachment{}, err
+	}
+	if _, err := t.GetNode(nodeID); err != nil {
+		return Attachment{}, err
+	}
+	if err := t.RemoveAttachment(nodeID, name); err != nil {
+		return Attachment{}, err
+	}
+
+	attachment := newAttachment(nodeID, name, data)
+	attachment.Hash = t.contentHash(data)
+	return attachment, t.storeAttachment(attachment, data)
+}
+
+// storeAttachment writes an attachment record and its contents, which are
+// shared with any other attachment of the same contents.
+func (t *Tx) storeAttachment(attachment Attachment, data []byte) error {
+	b, err := t.attachmentBuckets()
+	if err != nil {
+		return err
+	}
+
+	hash := t.contentHash(data)
+	attachment.Hash = hash
+	if b.blobs.Get([]byte(hash)) == nil {
+		sealed, err := t.seal(attachmentBlobsPath, []byte(hash), data)
+		if err != nil {
+			return err
+		}
+		if err := b.blobs.Put([]byte(hash), sealed); err != nil {
+			return err
+		}
+	}
+	refs, err := b.refs.CreateBucketIfNotExists([]byte(hash))
+	if err != nil {
+		return err
+	}
+	if err := refs.Put(attachmentRefKey(attachment.NodeID, t.nameKey(attachment.Name)), nil); err != nil {
+		return err
+	}
+
+	nb, err := b.nodes.CreateBucketIfNotExists(itob(attachment.NodeID))
+	if err != nil {
+		return err
+	}
+	return t.putRecord(nb, attachmentPath(attachment.NodeID), []byte(t.nameKey(attachment.Name)), attachment)
+}
+
+func (t *Tx) GetAttachments(nodeID int) ([]Attachment, error) {
+	b, err := t.attachmentBuckets()
+	if err != nil {
+		return nil, err
+	}
+	nb := b.nodes.Bucket(itob(nodeID))
+	if nb == nil {
+		return nil, nil
+	}
+
+	var attachments []Attachment
+	err = nb.ForEach(func(k, v []byte) error {
+		var attachment Attachment
+		if err := t.decodeRecord(attachmentPath(nodeID), k, v, &attachment); err != nil {
+			return err
+		}
+		attachments = append(attachments, attachment)
+		return nil
+	})
+	sort.Slice(attachments, func(i, j int) bool { return attachments[i].Name < attachments[j].Name })
+	return attachments, err
+}
+
+func (t *Tx) getAttachment(b attachmentBuckets, nodeID int, name string) (Attachment, bool, error) {
+	var attachment Attachment
+	nb := b.nodes.Bucket(itob(nodeID))
+	if nb == nil {
+		return attachment, false, nil
+	}
+	key := []byte(t.nameKey(name))
+	v := nb.Get(key)
+	if v == nil {
+		return attachment, false, nil
+	}
+	return attachment, true, t.decodeRecord(attachmentPath(nodeID), key, v, &attachment)
+}
+
+func (t *Tx) GetAttachmentData(nodeID int, name string) (Attachment, []byte, error) {
+	b, err := t.attachmentBuckets()
+	if err != nil {
+		return Attachment{}, nil, err
+	}
+	attachment, ok, err := t.getAttachment(b, nodeID, name)
+	if err != nil {
+		return Attachment{}, nil, err
+	}
+	if !ok {
+		return Attachment{}, nil, fmt.Errorf("attachment not found")
+	}
+	v := b.blobs.Get([]byte(attachment.Hash))
+	if v == nil {
+		return Attachment{}, nil, fmt.Errorf("attachment %s is missing its contents", name)
+	}
+	data, err := t.open(attachmentBlobsPath, []byte(attachment.Hash), v)
+	if err != nil {
+		return Attachment{}, nil, err
+	}
+	// Values are only valid for the life of the transaction.
+	return attachment, append([]byte(nil), data...), nil
+}
+
+// RemoveAttachment deletes an attachment, and its contents if no other
+// attachment shares them. Removing a missing attachment is not an error.
+func (t *Tx) RemoveAttachment(nodeID int, name string) error {
+	b, err := t.attachmentBuckets()
+	if err != nil {
+		return err
+	}
+	attachment, ok, err := t.getAttachment(b, nodeID, name)
+	if err != nil || !ok {
+		return err
+	}
+
+	if refs := b.refs.Bucket([]byte(attachment.Hash)); refs != nil {
+		if err := refs.Delete(attachmentRefKey(nodeID, t.nameKey(name))); err != nil {
+			return err
+		}
+		if k, _ := refs.Cursor().First(); k == nil {
+			if err := b.refs.DeleteBucket([]byte(attachment.Hash)); err != nil {
+				return err
+			}
+			if err := b.blobs.Delete([]byte(attachment.Hash)); err != nil {
+				return err
+			}
+		}
+	}
+
+	nb := b.nodes.Bucket(itob(nodeID))
+	if err := nb.Delete([]byte(t.nameKey(name))); err != nil {
+		return err
+	}
+	if k, _ := nb.Cursor().First(); k == nil {
+		return b.nodes.DeleteBucket(itob(nodeID))
+	}
+	return nil
+}
+
+// deleteAttachments removes every attachment of a node and returns how
+// many there were.
+func (t *Tx) deleteAttachments(nodeID int) (int, error) {
+	attachments, err := t.GetAttachments(nodeID)
+	if err != nil {
+		return 0, err
+	}
+	for _, attachment := range attachments {
+		if err := t.RemoveAttachment(nodeID, attachment.Name); err != nil {
+			return 0, err
+		}
+	}
+	return len(attachments), nil
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFormatSize(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:       "0 B",
+		1023:    "1023 B",
+		1024:    "1.0 KB",
+		1536:    "1.5 KB",
+		5 << 20: "5.0 MB",
+		3 << 30: "3072.0 MB",
+	} {
+		if got := FormatSize(n); got != want {
+			t.Errorf("FormatSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+// blobCount returns how many distinct attachment contents s holds.
+func blobCount(t *testing.T, s Store) int {
+	t.Helper()
+	switch s := s.(type) {
+	case *Db:
+		n := 0
+		if err := s.View(func(tx *Tx) error {
+			b, err := tx.attachmentBuckets()
+			n = countKeys(b.blobs)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	case *MarkdownStore:
+		entries, err := os.ReadDir(filepath.Join(s.dir, markdownMeta, "blobs"))
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		return len(entries)
+	case *MemStore:
+		return len(s.blobs)
+	}
+	t.Fatalf("no blobs in %T", s)
+	return 0
+}
+
+func TestAttachments(t *testing.T) {
+	for name, s := range searchStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.AddProject(Project{Name: "P"}); err != nil {
+				t.Fatal(err)
+			}
+			a := mustNode(t, s, 1, "A", "![[pic.png]]")
+			b := mustNode(t, s, 1, "B", "")
+			data := []byte("the same bytes")
+
+			wantBlobs := func(want int) {
+				t.Helper()
+				if got := blobCount(t, s); got != want {
+					t.Errorf("%d blobs stored, want %d", got, want)
+				}
+			}
+			wantData := func(nodeID int, name string, want []byte) {
+				t.Helper()
+				attachment, got, err := s.GetAttachmentData(nodeID, name)
+				if err != nil {
+					t.Fatalf("GetAttachmentData(%d, %q): %v", nodeID, name, err)
+				}
+				if !bytes.Equal(got, want) || attachment.Size != len(want) || attachment.Name != name {
+					t.Errorf("GetAttachmentData(%d, %q) = %+v, %q", nodeID, name, attachment, got)
+				}
+			}
+
+			pic, err := s.AddAttachment(a.ID, "pic.png", data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pic.NodeID != a.ID || pic.Size != len(data) || pic.MIME != "image/png" || pic.Added.IsZero() {
+				t.Errorf("AddAttachment = %+v", pic)
+			}
+
+			// The same bytes under another node and name share the blob.
+			copied, err := s.AddAttachment(b.ID, "../elsewhere/copy.png", data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if copied.Name != "copy.png" || copied.Hash != pic.Hash {
+				t.Errorf("second attachment = %+v, first %+v", copied, pic)
+			}
+			wantBlobs(1)
+
+			notes, err := s.AddAttachment(a.ID, "notes.txt", []byte("other"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if notes.Hash == pic.Hash || notes.MIME != "text/plain; charset=utf-8" {
+				t.Errorf("third attachment = %+v", notes)
+			}
+			wantBlobs(2)
+			attachments, err := s.GetAttachments(a.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, attachment := range attachments {
+				names = append(names, attachment.Name)
+			}
+			if !slices.Equal(names, []string{"notes.txt", "pic.png"}) {
+				t.Errorf("GetAttachments = %q", names)
+			}
+			wantData(a.ID, "notes.txt", []byte("other"))
+			wantData(b.ID, "copy.png", data)
+
+			// Replacing notes.txt with the shared bytes frees its old blob.
+			if _, err := s.AddAttachment(a.ID, "notes.txt", data); err != nil {
+				t.Fatal(err)
+			}
+			wantBlobs(1)
+			wantData(a.ID, "notes.txt", data)
+
+			// The blob outlives every reference but the last.
+			for _, ref := range []struct {
+				nodeID int
+				name   string
+			}{{a.ID, "pic.png"}, {a.ID, "notes.txt"}} {
+				if err := s.RemoveAttachment(ref.nodeID, ref.name); err != nil {
+					t.Fatal(err)
+				}
+				wantBlobs(1)
+			}
+			wantData(b.ID, "copy.png", data)
+			if err := s.RemoveAttachment(b.ID, "copy.png"); err != nil {
+				t.Fatal(err)
+			}
+			wantBlobs(0)
+
+			if err := s.RemoveAttachment(b.ID, "copy.png"); err != nil {
+				t.Errorf("removing a missing attachment: %v", err)
+			}
+			if _, _, err := s.GetAttachmentData(b.ID, "copy.png"); err == nil {
+				t.Error("GetAttachmentData found a removed attachment")
+			}
+			if got, err := s.GetAttachments(a.ID); err != nil || len(got) != 0 {
+				t.Errorf("GetAttachments after removal = %+v, %v", got, err)
+			}
+			if _, err := s.AddAttachment(a.ID, "", data); err == nil {
+				t.Error("AddAttachment accepted an empty name")
+			}
+			if _, err := s.AddAttachment(99, "x.txt", data); err == nil {
+				t.Error("AddAttachment accepted a missing node")
+			}
+		})
+	}
+}
+
+func TestAttachmentsSurviveReopening(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestMarkdownStore(t, dir)
+	if err := s.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	a := mustNode(t, s, 1, "A", "")
+	b := mustNode(t, s, 1, "B", "")
+	for _, id := range []int{a.ID, b.ID} {
+		if _, err := s.AddAttachment(id, "f.bin", []byte{0, 1, 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = newTestMarkdownStore(t, dir)
+	if err := s.RemoveAttachment(a.ID, "f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, data, err := s.GetAttachmentData(b.ID, "f.bin"); err != nil || !bytes.Equal(data, []byte{0, 1, 2}) {
+		t.Errorf("GetAttachmentData = %v, %v", data, err)
+	}
+	if n := blobCount(t, s); n != 1 {
+		t.Errorf("%d blobs after removing a shared attachment", n)
+	}
+	if err := s.RemoveAttachment(b.ID, "f.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if n := blobCount(t, s); n != 0 {
+		t.Errorf("%d blobs after removing the last attachment", n)
+	}
+}
@@ -0,0 +1,346 @@
+package db
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/pixambi/gbrain/internal/markup"
+	"go.etcd.io/bbolt"
+)
+
+// The backlinks bucket is keyed by link target rather than by node ID so
+// that links to notes that do not exist yet are picked up as soon as the
+// note is created:
+//
+//	backlinks/<projectID>/<normalized title>/<sourceNodeID> -> ""
+//
+// A link that reads as [[Project/Title]] for another project is also
+// listed under that project, so that its notes find the link without a
+// scan:
+//
+//	qualified_backlinks/<targetProjectID>/<normalized title>/<sourceNodeID> -> ""
+//
+// Which projects a link names depends on their names, so when a project is
+// added, renamed or deleted the links that named it, or name it now, are
+// read again.
+
+// Backlink is a node that links to another node.
+type Backlink struct {
+	NodeID    int
+	Title     string
+	ProjectID int
+	Snippet   string // Text surrounding the first link to the target
+}
+
+const backlinkSnippetRadius = 40
+
+// linkTargets returns the distinct normalized titles node links to.
+func linkTargets(node Node) []string {
+	seen := make(map[string]bool)
+	var targets []string
+	for _, link := range markup.ParseLinks(node.Content) {
+		title := normalizeTitle(link.Title)
+		if link.Embed || title == "" || seen[title] {
+			continue
+		}
+		seen[title] = true
+		targets = append(targets, title)
+	}
+	return targets
+}
+
+// qualifiedTargets returns the distinct projects and normalized titles that
+// node links to as [[Project/Title]], other than its own project.
+func qualifiedTargets(node Node, projects []Project) []projectSplit {
+	var targets []projectSplit
+	for _, target := range linkTargets(node) {
+		for _, split := range projectSplits(target, projects) {
+			split.title = normalizeTitle(split.title)
+			if split.project.ID == node.ProjectID || split.title == "" || slices.Contains(targets, split) {
+				continue
+			}
+			targets = append(targets, split)
+		}
+	}
+	return targets
+}
+
+func (t *Tx) indexBacklinks(node Node) error {
+	root, err := t.bucket("backlinks")
+	if err != nil {
+		return err
+	}
+	for _, target := range linkTargets(node) {
+		if err := putLinkSource(root, node.ProjectID, target, node.ID); err != nil {
+			return err
+		}
+	}
+	return t.indexQualifiedBacklinks(node)
+}
+
+func (t *Tx) indexQualifiedBacklinks(node Node) error {
+	projects, err := t.linkProjects()
+	if err != nil {
+		return err
+	}
+	root, err := t.bucket("qualified_backlinks")
+	if err != nil {
+		return err
+	}
+	for _, target := range qualifiedTargets(node, projects) {
+		if err := putLinkSource(root, target.project.ID, target.title, node.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Tx) unindexBacklinks(node Node) error {
+	root, err := t.bucket("backlinks")
+	if err != nil {
+		return err
+	}
+	for _, target := range linkTargets(node) {
+		if err := deleteLinkSource(root, node.ProjectID, target, node.ID); err != nil {
+			return err
+		}
+	}
+
+	projects, err := t.linkProjects()
+	if err != nil {
+		return err
+	}
+	if root, err = t.bucket("qualified_backlinks"); err != nil {
+		return err
+	}
+	for _, target := range qualifiedTargets(node, projects) {
+		if err := deleteLinkSource(root, target.project.ID, target.title, node.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putLinkSource lists nodeID as linking to target in a project in root, a
+// bucket laid out like backlinks.
+func putLinkSource(root *bbolt.Bucket, projectID int, target string, nodeID int) error {
+	pb, err := root.CreateBucketIfNotExists(itob(projectID))
+	if err != nil {
+		return err
+	}
+	tb, err := pb.CreateBucketIfNotExists([]byte(target))
+	if err != nil {
+		return err
+	}
+	return tb.Put(itob(nodeID), nil)
+}
+
+// deleteLinkSource reverses putLinkSource, dropping the target's bucket
+// once it is empty.
+func deleteLinkSource(root *bbolt.Bucket, projectID int, target string, nodeID int) error {
+	pb := root.Bucket(itob(projectID))
+	if pb == nil {
+		return nil
+	}
+	tb := pb.Bucket([]byte(target))
+	if tb == nil {
+		return nil
+	}
+	if err := tb.Delete(itob(nodeID)); err != nil {
+		return err
+	}
+	if k, _ := tb.Cursor().First(); k == nil {
+		return pb.DeleteBucket([]byte(target))
+	}
+	return nil
+}
+
+// reindexQualifiedBacklinks brings the qualified_backlinks index up to date
+// after project projectID has been added, renamed to name or, with name
+// empty, deleted. Only nodes whose links named the project, or name it
+// now, are read again: the first are listed under the project in
+// qualified_backlinks, and the second in backlinks under a title starting
+// with the name and a slash.
+func (t *Tx) reindexQualifiedBacklinks(projectID int, name string) error {
+	t.projects = nil
+	if t.encrypted {
+		return nil
+	}
+	root, err := t.bucket("qualified_backlinks")
+	if err != nil {
+		return err
+	}
+	var ids []int
+	if pb := root.Bucket(itob(projectID)); pb != nil {
+		err := pb.ForEach(func(title, _ []byte) error {
+			return pb.Bucket(title).ForEach(func(k, _ []byte) error {
+				ids = append(ids, btoi(k))
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+		if err := root.DeleteBucket(itob(projectID)); err != nil {
+			return err
+		}
+	}
+	if name = normalizeTitle(name); name != "" {
+		sources, err := t.linkSourcesByPrefix(name+"/", name+" /")
+		if err != nil {
+			return err
+		}
+		ids = append(ids, sources...)
+	}
+
+	slices.Sort(ids)
+	for _, id := range slices.Compact(ids) {
+		node, err := t.GetNode(id)
+		if err != nil {
+			return err
+		}
+		if err := t.indexQualifiedBacklinks(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkSourcesByPrefix returns the IDs of nodes with a link, in the
+// backlinks index, to a title starting with one of prefixes.
+func (t *Tx) linkSourcesByPrefix(prefixes ...string) ([]int, error) {
+	root, err := t.bucket("backlinks")
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	err = root.ForEach(func(projectID, _ []byte) error {
+		pb := root.Bucket(projectID)
+		for _, prefix := range prefixes {
+			c := pb.Cursor()
+			for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+				err := pb.Bucket(k).ForEach(func(source, _ []byte) error {
+					ids = append(ids, btoi(source))
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// linkProjects returns the projects that links are read against, loading
+// them once per transaction.
+func (t *Tx) linkProjects() ([]Project, error) {
+	if t.projects == nil {
+		projects, err := t.GetProjects()
+		if err != nil {
+			return nil, err
+		}
+		t.projects = projects
+	}
+	return t.projects, nil
+}
+
+// backlinkSources returns the IDs of nodes linking to title in a project,
+// from the backlinks index or, with qualified set, from other projects as
+// [[Project/Title]].
+func (t *Tx) backlinkSources(title string, projectID int, qualified bool) ([]int, error) {
+	name := "backlinks"
+	if qualified {
+		name = "qualified_backlinks"
+	}
+	root, err := t.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+	var tb *bbolt.Bucket
+	if pb := root.Bucket(itob(projectID)); pb != nil {
+		tb = pb.Bucket([]byte(normalizeTitle(title)))
+	}
+	if tb == nil {
+		return nil, nil
+	}
+	var ids []int
+	err = tb.ForEach(func(k, _ []byte) error {
+		ids = append(ids, btoi(k))
+		return nil
+	})
+	return ids, err
+}
+
+// GetBacklinks returns every other node that links to the given node,
+// including nodes in other projects linking to it as [[Project/Title]].
+func (d *Db) GetBacklinks(nodeID int) ([]Backlink, error) {
+	var backlinks []Backlink
+	err := d.View(func(tx *Tx) error {
+		var err error
+		backlinks, err = tx.GetBacklinks(nodeID)
+		return err
+	})
+	return backlinks, err
+}
+
+func (t *Tx) GetBacklinks(nodeID int) ([]Backlink, error) {
+	node, err := t.GetNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	project, err := t.GetProject(node.ProjectID)
+	if err != nil {
+		project = Project{ID: node.ProjectID}
+	}
+	if t.encrypted {
+		nodes, err := t.GetNodes()
+		return scanBacklinks(nodes, node, project), err
+	}
+
+	// Links to an alias count as well, each source once, in ID order.
+	keys := titleKeys(node)
+	var ids []int
+	for _, key := range keys {
+		for _, qualified := range []bool{false, true} {
+			sources, err := t.backlinkSources(key, node.ProjectID, qualified)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, sources...)
+		}
+	}
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+
+	var backlinks []Backlink
+	for _, id := range ids {
+		if id == nodeID {
+			continue
+		}
+		source, err := t.GetNode(id)
+		if err != nil {
+			return nil, err
+		}
+		backlinks = append(backlinks, newBacklink(source, project, keys))
+	}
+	return backlinks, nil
+}
+
+// newBacklink describes source as linking to one of the normalized titles
+// in keys in project.
+func newBacklink(source Node, project Project, keys []string) Backlink {
+	backlink := Backlink{
+		NodeID:    source.ID,
+		Title:     source.Title,
+		ProjectID: source.ProjectID,
+	}
+	for _, link := range markup.ParseLinks(source.Content) {
+		if !link.Embed && linkReaches(link.Title, source.ProjectID, project, keys) {
+			backlink.Snippet = markup.Snippet(source.Content, link.Position[0], link.Position[1], backlinkSnippetRadius)
+			break
+		}
+	}
+	return backlink
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func backlinkIDs(t *testing.T, s Store, nodeID int) []int {
+	t.Helper()
+	backlinks, err := s.GetBacklinks(nodeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, backlink := range backlinks {
+		ids = append(ids, backlink.NodeID)
+	}
+	return ids
+}
+
+func TestQualifiedBacklinks(t *testing.T) {
+	for name, s := range map[string]Store{"db": newTestDb(t, nil), "mem": NewMemStore(nil)} {
+		t.Run(name, func(t *testing.T) {
+			for _, project := range []string{"Src", "Dst"} {
+				if err := s.AddProject(Project{Name: project}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			target := mustNode(t, s, 1, "Target", "")
+			local := mustNode(t, s, 1, "Local", "[[target]]")
+			remote := mustNode(t, s, 2, "Remote", "see [[Src/Target]]")
+			early := mustNode(t, s, 2, "Early", "see [[Later/Target]]")
+
+			want := []int{local.ID, remote.ID}
+			if got := backlinkIDs(t, s, target.ID); !slices.Equal(got, want) {
+				t.Errorf("backlinks = %v, want %v", got, want)
+			}
+			backlinks, _ := s.GetBacklinks(target.ID)
+			if len(backlinks) == 2 && backlinks[1].Snippet != "see [[Src/Target]]" {
+				t.Errorf("snippet = %q", backlinks[1].Snippet)
+			}
+
+			// Renaming the project changes which links reach it.
+			if err := s.UpdateProject(Project{ID: 1, Name: "Later"}); err != nil {
+				t.Fatal(err)
+			}
+			want = []int{local.ID, early.ID}
+			if got := backlinkIDs(t, s, target.ID); !slices.Equal(got, want) {
+				t.Errorf("backlinks after rename = %v, want %v", got, want)
+			}
+
+			// Editing the source drops the link.
+			early.Content = "no links"
+			if err := s.UpdateNode(early); err != nil {
+				t.Fatal(err)
+			}
+			want = []int{local.ID}
+			if got := backlinkIDs(t, s, target.ID); !slices.Equal(got, want) {
+				t.Errorf("backlinks after edit = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// qualifiedIndex lists every entry of the qualified_backlinks index as
+// project/title/source.
+func qualifiedIndex(t *testing.T, tx *Tx) []string {
+	t.Helper()
+	root, err := tx.bucket("qualified_backlinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	err = root.ForEach(func(projectID, _ []byte) error {
+		pb := root.Bucket(projectID)
+		return pb.ForEach(func(title, _ []byte) error {
+			return pb.Bucket(title).ForEach(func(source, _ []byte) error {
+				entries = append(entries, fmt.Sprintf("%d/%s/%d", btoi(projectID), title, btoi(source)))
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// checkQualifiedIndex compares the qualified_backlinks index with one built
+// from scratch.
+func checkQualifiedIndex(t *testing.T, d *Db, want []string) {
+	t.Helper()
+	errRollback := errors.New("rollback")
+	err := d.Update(func(tx *Tx) error {
+		got := qualifiedIndex(t, tx)
+		if !slices.Equal(got, want) {
+			t.Errorf("qualified_backlinks = %v, want %v", got, want)
+		}
+		if err := tx.RebuildIndexes(); err != nil {
+			return err
+		}
+		if rebuilt := qualifiedIndex(t, tx); !slices.Equal(got, rebuilt) {
+			t.Errorf("qualified_backlinks = %v, rebuilt %v", got, rebuilt)
+		}
+		return errRollback
+	})
+	if err != errRollback {
+		t.Fatal(err)
+	}
+}
+
+func TestQualifiedBacklinksFollowProjects(t *testing.T) {
+	d := newTestDb(t, nil)
+	for _, project := range []string{"Src", "Other"} {
+		if err := d.AddProject(Project{Name: project}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustNode(t, d, 1, "Target", "")
+	a := mustNode(t, d, 2, "A", "[[ Src / Target ]] and [[New/Target]]")
+	b := mustNode(t, d, 2, "B", "[[src/target]]")
+	mustNode(t, d, 2, "C", "[[Other/C]] and [[Target]]")
+	checkQualifiedIndex(t, d, []string{
+		fmt.Sprintf("1/target/%d", a.ID),
+		fmt.Sprintf("1/target/%d", b.ID),
+	})
+
+	if err := d.UpdateProject(Project{ID: 1, Name: "New"}); err != nil {
+		t.Fatal(err)
+	}
+	checkQualifiedIndex(t, d, []string{fmt.Sprintf("1/target/%d", a.ID)})
+
+	if err := d.AddProject(Project{Name: "Src"}); err != nil {
+		t.Fatal(err)
+	}
+	checkQualifiedIndex(t, d, []string{
+		fmt.Sprintf("1/target/%d", a.ID),
+		fmt.Sprintf("3/target/%d", a.ID),
+		fmt.Sprintf("3/target/%d", b.ID),
+	})
+
+	report, err := d.DeleteProject(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkQualifiedIndex(t, d, []string{
+		fmt.Sprintf("3/target/%d", a.ID),
+		fmt.Sprintf("3/target/%d", b.ID),
+	})
+
+	if err := d.RestoreTrash(report.TrashID); err != nil {
+		t.Fatal(err)
+	}
+	checkQualifiedIndex(t, d, []string{
+		fmt.Sprintf("1/target/%d", a.ID),
+		fmt.Sprintf("3/target/%d", a.ID),
+		fmt.Sprintf("3/target/%d", b.ID),
+	})
+}
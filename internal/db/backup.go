@@ -0,0 +1,250 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrInUse is returned when another process has the database open.
+var ErrInUse = errors.New("the database is open in another gbrain process")
+
+// lockTimeout is how long opening a file waits for another process to let
+// go of it.
+const lockTimeout = time.Second
+
+// openBolt opens a bbolt file, giving up with ErrInUse if another process
+// holds it. Read-only opens share the file with other readers.
+func openBolt(path string, readOnly bool) (*bbolt.DB, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: lockTimeout, ReadOnly: readOnly})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return nil, ErrInUse
+	}
+	return db, err
+}
+
+// NewDbReadOnly opens the database at path without changing it, for taking
+// snapshots. It is not initialised and fails with ErrInUse while gbrain is
+// running against the same file.
+func NewDbReadOnly(path string) (*Db, error) {
+	db, err := openBolt(path, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Db{db: db}, nil
+}
+
+// Snapshot writes a consistent copy of the database, as it was when the
+// copy started, to path. Other transactions carry on while it is written.
+func (d *Db) Snapshot(path string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	tmp := path + ".tmp"
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		return tx.CopyFile(tmp, 0600)
+	})
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+const (
+	backupPrefix     = "gbrain-"
+	backupSuffix     = ".db"
+	backupTimeFormat = "20060102-150405"
+)
+
+// Backup writes a timestamped snapshot into dir and then deletes the oldest
+// snapshots there beyond keep. A keep of 0 or less deletes nothing.
+func (d *Db) Backup(dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, backupPrefix+time.Now().Format(backupTimeFormat)+backupSuffix)
+	if err := d.Snapshot(path); err != nil {
+		return "", err
+	}
+	if keep <= 0 {
+		return path, nil
+	}
+
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return path, err
+	}
+	for len(backups) > keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return path, err
+		}
+		backups = backups[1:]
+	}
+	return path, nil
+}
+
+// ListBackups returns the snapshots taken by Backup in dir, oldest first.
+func ListBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && isBackupName(name) {
+			backups = append(backups, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func isBackupName(name string) bool {
+	if len(name) != len(backupPrefix)+len(backupTimeFormat)+len(backupSuffix) ||
+		name[:len(backupPrefix)] != backupPrefix || name[len(name)-len(backupSuffix):] != backupSuffix {
+		return false
+	}
+	_, err := time.Parse(backupTimeFormat, name[len(backupPrefix):len(name)-len(backupSuffix)])
+	return err == nil
+}
+
+// SnapshotInfo summarises a snapshot that passed validation.
+type SnapshotInfo struct {
+	SchemaVersion int
+	Encrypted     bool
+	Projects      int
+	Nodes         int
+}
+
+// ValidateSnapshot checks that path is an intact gbrain database that this
+// release can open: every page is consistent, the base buckets exist, the
+// schema is not too new and, unless it is encrypted, every project and node
+// decodes.
+func ValidateSnapshot(path string) (SnapshotInfo, error) {
+	var info SnapshotInfo
+	db, err := openBolt(path, true)
+	if err != nil {
+		return info, err
+	}
+	defer db.Close()
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		if err := checkPages(tx); err != nil {
+			return err
+		}
+		for _, name := range []string{"nodes", "projects"} {
+			if tx.Bucket([]byte(name)) == nil {
+				return fmt.Errorf("not a gbrain database: bucket %s is missing", name)
+			}
+		}
+
+		info.SchemaVersion = readSchemaVersion(tx)
+		if latest := SchemaVersion(); info.SchemaVersion > latest {
+			return &SchemaError{Found: info.SchemaVersion, Supported: latest}
+		}
+		_, encrypted, err := readEncryptionMeta(tx)
+		if err != nil {
+			return fmt.Errorf("reading encryption settings: %w", err)
+		}
+		info.Encrypted = encrypted
+
+		t := &Tx{tx: tx, encrypted: encrypted}
+		check := func(name string, out any, count *int) error {
+			return tx.Bucket([]byte(name)).ForEach(func(k, v []byte) error {
+				*count++
+				if encrypted {
+					return nil
+				}
+				if err := t.decodeRecord(name, k, v, out); err != nil {
+					return fmt.Errorf("%s %x: %w", name, k, err)
+				}
+				return nil
+			})
+		}
+		if err := check("projects", &Project{}, &info.Projects); err != nil {
+			return err
+		}
+		return check("nodes", &Node{}, &info.Nodes)
+	})
+	return info, err
+}
+
+// checkPages checks that every page reachable in tx is consistent.
+func checkPages(tx *bbolt.Tx) error {
+	// Drain every error so that the checker is done with tx before it is
+	// closed.
+	var corrupt error
+	for err := range tx.Check() {
+		if corrupt == nil {
+			corrupt = err
+		}
+	}
+	if corrupt != nil {
+		return fmt.Errorf("file is corrupt: %w", corrupt)
+	}
+	return nil
+}
+
+// Restore validates the snapshot at src and then puts it in place of the
+// database at path, which must not be open. The database being replaced is
+// kept next to it, and its path returned.
+func Restore(src, path string) (string, error) {
+	if _, err := ValidateSnapshot(src); err != nil {
+		return "", fmt.Errorf("%s: %w", src, err)
+	}
+
+	current, err := openBolt(path, false)
+	if err != nil {
+		return "", err
+	}
+	d := &Db{db: current}
+	defer d.Close()
+	saved, err := d.backup("pre-restore")
+	if err != nil {
+		return "", fmt.Errorf("saving the current database: %w", err)
+	}
+
+	tmp := path + ".restore"
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return saved, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return saved, err
+	}
+	return saved, nil
+}
+
+// copyFile copies src to a new file dst and syncs it to disk.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
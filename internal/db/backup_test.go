@@ -0,0 +1,169 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// nodeTitles returns the titles of every node in s, in ID order.
+func nodeTitles(t *testing.T, s Store) []string {
+	t.Helper()
+	nodes, err := s.GetNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(nodes, func(a, b Node) int { return a.ID - b.ID })
+	var titles []string
+	for _, node := range nodes {
+		titles = append(titles, node.Title)
+	}
+	return titles
+}
+
+func TestBackupRotation(t *testing.T) {
+	d := newTestDb(t, nil)
+	dir := t.TempDir()
+	older := []string{"gbrain-20240101-000000.db", "gbrain-20240102-000000.db", "gbrain-20240103-000000.db", "gbrain-20240104-000000.db"}
+	for _, name := range append(older, "gbrain-latest.db", "notes.txt") {
+		if err := d.Snapshot(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path, err := d.Backup(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups, err := ListBackups(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, older[2]), filepath.Join(dir, older[3]), path}
+	if !slices.Equal(backups, want) {
+		t.Errorf("backups kept = %v, want %v", backups, want)
+	}
+	for _, name := range []string{"gbrain-latest.db", "notes.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("rotation touched %s: %v", name, err)
+		}
+	}
+
+	// Keeping none means keeping all.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Backup(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ := ListBackups(dir); len(backups) != 3 {
+		t.Errorf("backups after Backup(dir, 0) = %v", backups)
+	}
+}
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	d := newTestDb(t, nil)
+	if err := d.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	kept := mustNode(t, d, 1, "Kept", "as backed up")
+	mustNode(t, d, 1, "Deleted later", "")
+	path, err := d.Backup(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nodeTitles(t, d)
+
+	kept.Content = "changed after the backup"
+	if err := d.UpdateNode(kept); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteNode(2); err != nil {
+		t.Fatal(err)
+	}
+	mustNode(t, d, 1, "Added later", "")
+	changed := nodeTitles(t, d)
+	dbPath := d.db.Path()
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := Restore(path, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = openTestDb(t, dbPath, nil)
+	if got := nodeTitles(t, d); !slices.Equal(got, want) {
+		t.Errorf("nodes after restoring = %q, want %q", got, want)
+	}
+	if node, err := d.GetNode(kept.ID); err != nil || node.Content != "as backed up" {
+		t.Errorf("restored node = %+v, %v", node, err)
+	}
+	if found, err := d.Search("backed", SearchOptions{}); err != nil || len(found) != 1 {
+		t.Errorf("Search after restoring = %+v, %v", found, err)
+	}
+
+	// The database that was replaced is kept.
+	previous := openTestDb(t, saved, nil)
+	if got := nodeTitles(t, previous); !slices.Equal(got, changed) {
+		t.Errorf("nodes in %s = %q, want %q", saved, got, changed)
+	}
+}
+
+func TestRestoreInUse(t *testing.T) {
+	d := newTestDb(t, nil)
+	if err := d.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	mustNode(t, d, 1, "Note", "")
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := d.Snapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	mustNode(t, d, 1, "Newer", "")
+
+	if _, err := Restore(snapshot, d.db.Path()); !errors.Is(err, ErrInUse) {
+		t.Errorf("Restore over an open database = %v, want ErrInUse", err)
+	}
+	if got := nodeTitles(t, d); !slices.Equal(got, []string{"Note", "Newer"}) {
+		t.Errorf("nodes after a refused restore = %q", got)
+	}
+
+	// A reader, such as gbrain backup, keeps it out as well.
+	path := d.db.Path()
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewDbReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if _, err := Restore(snapshot, path); !errors.Is(err, ErrInUse) {
+		t.Errorf("Restore over a database being read = %v, want ErrInUse", err)
+	}
+}
+
+func TestRestoreRejectsDamagedSnapshots(t *testing.T) {
+	d := newTestDb(t, nil)
+	path := d.db.Path()
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	damaged := filepath.Join(t.TempDir(), "damaged.db")
+	if err := os.WriteFile(damaged, []byte("not a database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(damaged, path); err == nil {
+		t.Fatal("restored a damaged snapshot")
+	}
+	if after, err := os.ReadFile(path); err != nil || !slices.Equal(after, before) {
+		t.Errorf("the database changed after a refused restore: %v", err)
+	}
+}
@@ -0,0 +1,504 @@
+package db
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/pixambi/gbrain/internal/markup"
+	"go.etcd.io/bbolt"
+)
+
+// Records that cannot be read, or whose key cannot be an ID, are moved out
+// of the way by a repair rather than deleted:
+//
+//	quarantine/<bucket path>/<key> -> the value as it was stored
+//
+// Nothing reads the quarantine; it only keeps the data for anyone who wants
+// to recover it by hand.
+
+// ProblemKind classifies what Check found.
+type ProblemKind int
+
+const (
+	// ProblemDecode is a record that cannot be read.
+	ProblemDecode ProblemKind = iota
+	// ProblemKey is a record that disagrees with the key it is stored
+	// under, or is stored under a key that is not an ID.
+	ProblemKey
+	// ProblemOrphan is a node whose project does not exist.
+	ProblemOrphan
+	// ProblemDuplicateTitle is a node with the same title as an older node
+	// in its project, which links can never reach.
+	ProblemDuplicateTitle
+	// ProblemDanglingLink is a [[link]] to a title that no node has yet.
+	// It is only ever reported as a notice, since linking to a note before
+	// writing it is normal.
+	ProblemDanglingLink
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case ProblemDecode:
+		return "unreadable record"
+	case ProblemKey:
+		return "key mismatch"
+	case ProblemOrphan:
+		return "orphaned node"
+	case ProblemDuplicateTitle:
+		return "duplicate title"
+	case ProblemDanglingLink:
+		return "dangling link"
+	}
+	return fmt.Sprintf("problem %d", int(k))
+}
+
+// Problem is one thing Check found wrong.
+type Problem struct {
+	Kind   ProblemKind
+	Where  string // Bucket path and key, such as nodes/12 or revisions/12/3
+	Detail string
+	// Repair describes what a repair did about it; empty when checking
+	// only.
+	Repair string
+}
+
+// CheckReport is the result of Check.
+type CheckReport struct {
+	Projects int
+	Nodes    int
+	Problems []Problem
+	// Notices are findings that need no repair, such as links to notes
+	// that have not been written yet.
+	Notices []Problem
+	Backup  string // Copy taken before repairing, if any
+}
+
+// recoveredProject is where a repair moves orphaned nodes.
+const recoveredProject = "Recovered"
+
+// Check verifies every record in the database and looks for orphaned
+// nodes and duplicate titles, noting dangling links along the way. With
+// repair set it also fixes the problems in the same transaction:
+// unreadable records are quarantined, records are made to agree with their
+// keys, orphans are moved to a project called Recovered, duplicates are
+// renamed and the indexes are rebuilt. A backup is taken before repairing.
+// Dangling links are left alone.
+func (d *Db) Check(repair bool) (CheckReport, error) {
+	var report CheckReport
+	err := d.View(func(tx *Tx) error {
+		var err error
+		report, err = tx.Check(false)
+		return err
+	})
+	if err != nil || !repair || len(report.Problems) == 0 {
+		return report, err
+	}
+
+	backup, err := d.backup("pre-repair")
+	if err != nil {
+		return CheckReport{}, fmt.Errorf("backing up before repair: %w", err)
+	}
+	err = d.Update(func(tx *Tx) error {
+		var err error
+		report, err = tx.Check(true)
+		return err
+	})
+	report.Backup = backup
+	return report, err
+}
+
+type checker struct {
+	t        *Tx
+	repair   bool
+	report   CheckReport
+	nodes    []Node
+	projects map[int]Project
+}
+
+func (t *Tx) Check(repair bool) (CheckReport, error) {
+	if t.encrypted && t.key == nil {
+		// Every record would look unreadable.
+		return CheckReport{}, ErrLocked
+	}
+	c := &checker{t: t, repair: repair, projects: make(map[int]Project)}
+	if err := c.checkRecords(); err != nil {
+		return c.report, err
+	}
+	if repair {
+		// Everything left can be read, so the indexes can be trusted again
+		// before the fixes below save through them.
+		if err := t.RebuildIndexes(); err != nil {
+			return c.report, err
+		}
+	}
+	for _, step := range []func() error{c.checkOrphans, c.checkDuplicates, c.checkLinks} {
+		if err := step(); err != nil {
+			return c.report, err
+		}
+	}
+	c.report.Projects = len(c.projects)
+	c.report.Nodes = len(c.nodes)
+	return c.report, nil
+}
+
+func (c *checker) add(kind ProblemKind, where, detail, repair string) {
+	if !c.repair {
+		repair = ""
+	}
+	c.report.Problems = append(c.report.Problems, Problem{Kind: kind, Where: where, Detail: detail, Repair: repair})
+}
+
+func (c *checker) checkRecords() error {
+	b, err := c.t.bucket("projects")
+	if err != nil {
+		return err
+	}
+	projects, err := checkBucket(c, b, "projects", func(project *Project, id int) (string, bool) {
+		return fixID(&project.ID, id), true
+	})
+	if err != nil {
+		return err
+	}
+	for _, project := range projects {
+		c.projects[project.ID] = project
+	}
+
+	if b, err = c.t.bucket("nodes"); err != nil {
+		return err
+	}
+	c.nodes, err = checkBucket(c, b, "nodes", func(node *Node, id int) (string, bool) {
+		return fixID(&node.ID, id), true
+	})
+	if err != nil {
+		return err
+	}
+
+	if b, err = c.t.bucket("trash"); err != nil {
+		return err
+	}
+	_, err = checkBucket(c, b, "trash", func(item *TrashItem, id int) (string, bool) {
+		return fixID(&item.ID, id), true
+	})
+	if err != nil {
+		return err
+	}
+
+	revisions, err := c.t.bucket("revisions")
+	if err != nil {
+		return err
+	}
+	err = c.checkNested(revisions, "revisions", func(b *bbolt.Bucket, path string, nodeID int) error {
+		_, err := checkBucket(c, b, path, func(rev *Revision, id int) (string, bool) {
+			if problem := fixID(&rev.ID, id); problem != "" {
+				return problem, true
+			}
+			return fixNodeID(&rev.NodeID, nodeID), true
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	ab, err := c.t.attachmentBuckets()
+	if err != nil {
+		return err
+	}
+	return c.checkNested(ab.nodes, "attachments/nodes", func(b *bbolt.Bucket, path string, nodeID int) error {
+		_, err := checkNamed(c, b, path, func(a *Attachment, key string) (string, bool) {
+			if c.t.nameKey(a.Name) != key {
+				if c.t.encrypted {
+					return "record is for another attachment", false
+				}
+				problem := fmt.Sprintf("record is named %q", a.Name)
+				a.Name = key
+				return problem, true
+			}
+			return fixNodeID(&a.NodeID, nodeID), true
+		})
+		return err
+	})
+}
+
+// fixID corrects a record's ID to match its key, describing the mismatch.
+func fixID(field *int, id int) string {
+	if *field == id {
+		return ""
+	}
+	problem := fmt.Sprintf("record has ID %d", *field)
+	*field = id
+	return problem
+}
+
+func fixNodeID(field *int, nodeID int) string {
+	if *field == nodeID {
+		return ""
+	}
+	problem := fmt.Sprintf("record belongs to node %d", *field)
+	*field = nodeID
+	return problem
+}
+
+// checkNested runs check on each bucket nested in root under a node ID.
+func (c *checker) checkNested(root *bbolt.Bucket, name string, check func(b *bbolt.Bucket, path string, nodeID int) error) error {
+	var keys, values [][]byte
+	err := root.ForEach(func(k, v []byte) error {
+		k = append([]byte(nil), k...)
+		switch {
+		case v != nil:
+			values = append(values, k)
+		case len(k) != 8:
+			// Nothing looks a bucket like this up, so it does no harm.
+			c.add(ProblemKey, fmt.Sprintf("%s/%q", name, k), "bucket key is not a node ID", "left in place")
+		default:
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range values {
+		c.add(ProblemKey, fmt.Sprintf("%s/%q", name, k), "value where a per-node bucket belongs", "quarantined")
+		if err := c.quarantineIf(root, name, k); err != nil {
+			return err
+		}
+	}
+	for _, k := range keys {
+		path := fmt.Sprintf("%s/%d", name, btoi(k))
+		if err := check(root.Bucket(k), path, btoi(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBucket decodes every record of a bucket keyed by ID. fix compares a
+// record with the ID from its key, corrects it and describes what was
+// wrong; ok is false if the record cannot be trusted even so. It returns
+// the records that can be used, corrected.
+func checkBucket[T any](c *checker, b *bbolt.Bucket, path string, fix func(rec *T, id int) (problem string, ok bool)) ([]T, error) {
+	return checkRecordsOf(c, b, path, func(k []byte) (string, bool) {
+		if len(k) != 8 {
+			return "", false
+		}
+		return fmt.Sprintf("%s/%d", path, btoi(k)), true
+	}, func(rec *T, k []byte) (string, bool) {
+		return fix(rec, btoi(k))
+	})
+}
+
+// checkNamed is checkBucket for a bucket keyed by name.
+func checkNamed[T any](c *checker, b *bbolt.Bucket, path string, fix func(rec *T, key string) (problem string, ok bool)) ([]T, error) {
+	return checkRecordsOf(c, b, path, func(k []byte) (string, bool) {
+		return path + "/" + string(k), true
+	}, func(rec *T, k []byte) (string, bool) {
+		return fix(rec, string(k))
+	})
+}
+
+func checkRecordsOf[T any](c *checker, b *bbolt.Bucket, path string, where func(k []byte) (string, bool), fix func(rec *T, k []byte) (string, bool)) ([]T, error) {
+	type entry struct{ k, v []byte }
+	var entries []entry
+	err := b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			entries = append(entries, entry{append([]byte(nil), k...), append([]byte(nil), v...)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []T
+	for _, e := range entries {
+		at, ok := where(e.k)
+		if !ok {
+			c.add(ProblemKey, fmt.Sprintf("%s/%q", path, e.k), "key is not an ID", "quarantined")
+			if err := c.quarantineIf(b, path, e.k); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var rec T
+		if err := c.t.decodeRecord(path, e.k, e.v, &rec); err != nil {
+			c.add(ProblemDecode, at, err.Error(), "quarantined")
+			if err := c.quarantineIf(b, path, e.k); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		problem, ok := fix(&rec, e.k)
+		if problem == "" {
+			records = append(records, rec)
+			continue
+		}
+		if !ok {
+			c.add(ProblemKey, at, problem, "quarantined")
+			if err := c.quarantineIf(b, path, e.k); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		c.add(ProblemKey, at, problem, "corrected to match its key")
+		if c.repair {
+			if err := c.t.putRecord(b, path, e.k, rec); err != nil {
+				return nil, err
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func (c *checker) quarantineIf(b *bbolt.Bucket, path string, k []byte) error {
+	if !c.repair {
+		return nil
+	}
+	return c.quarantine(b, path, k)
+}
+
+// quarantine moves the value at k in b to the quarantine bucket.
+func (c *checker) quarantine(b *bbolt.Bucket, path string, k []byte) error {
+	root, err := c.t.tx.CreateBucketIfNotExists([]byte("quarantine"))
+	if err != nil {
+		return err
+	}
+	qb, err := root.CreateBucketIfNotExists([]byte(path))
+	if err != nil {
+		return err
+	}
+	if err := qb.Put(k, b.Get(k)); err != nil {
+		return err
+	}
+	return b.Delete(k)
+}
+
+func (c *checker) checkOrphans() error {
+	var recovered *Project
+	for i, node := range c.nodes {
+		if _, ok := c.projects[node.ProjectID]; ok {
+			continue
+		}
+		where := fmt.Sprintf("nodes/%d", node.ID)
+		detail := fmt.Sprintf("%q belongs to missing project %d", node.Title, node.ProjectID)
+		repair := fmt.Sprintf("moved to project %s", recoveredProject)
+		if !c.repair {
+			c.add(ProblemOrphan, where, detail, repair)
+			continue
+		}
+
+		if recovered == nil {
+			project, err := c.recoveredProject()
+			if err != nil {
+				return err
+			}
+			recovered = &project
+		}
+		title, err := c.t.freeTitle(node.Title, recovered.ID, node.ID)
+		if err != nil {
+			return err
+		}
+		if title != node.Title {
+			repair += fmt.Sprintf(" as %q", title)
+		}
+		c.add(ProblemOrphan, where, detail, repair)
+		node.Title = title
+		node.ProjectID = recovered.ID
+		if err := c.t.putNode(&node, false); err != nil {
+			return err
+		}
+		c.nodes[i] = node
+	}
+	return nil
+}
+
+// recoveredProject returns the project orphans are moved to, creating it
+// if there is none.
+func (c *checker) recoveredProject() (Project, error) {
+	for _, project := range c.projects {
+		if project.Name == recoveredProject {
+			return project, nil
+		}
+	}
+	project := Project{Name: recoveredProject}
+	if err := c.t.AddProject(&project); err != nil {
+		return Project{}, err
+	}
+	c.projects[project.ID] = project
+	return project, nil
+}
+
+// checkDuplicates keeps the oldest node of each title in a project and
+// renames the others with a number after the title, skipping numbers that
+// would give a title or alias already in use.
+func (c *checker) checkDuplicates() error {
+	type titleKey struct {
+		projectID int
+		title     string
+	}
+	titles := make(map[titleKey]bool)
+	used := make(map[titleKey]bool)
+	var duplicates []int
+	for i, node := range c.nodes {
+		key := titleKey{node.ProjectID, normalizeTitle(node.Title)}
+		if titles[key] {
+			duplicates = append(duplicates, i)
+		}
+		titles[key] = true
+		for _, title := range titleKeys(node) {
+			used[titleKey{node.ProjectID, title}] = true
+		}
+	}
+
+	for _, i := range duplicates {
+		node := c.nodes[i]
+		title, err := numberedTitle(node.Title, func(candidate string) (bool, error) {
+			return used[titleKey{node.ProjectID, normalizeTitle(candidate)}], nil
+		})
+		if err != nil {
+			return err
+		}
+		c.add(ProblemDuplicateTitle, fmt.Sprintf("nodes/%d", node.ID),
+			fmt.Sprintf("%q is also the title of an older node", node.Title),
+			fmt.Sprintf("renamed to %q", title))
+		used[titleKey{node.ProjectID, normalizeTitle(title)}] = true
+		if !c.repair {
+			continue
+		}
+		node.Title = title
+		if err := c.t.putNode(&node, false); err != nil {
+			return err
+		}
+		c.nodes[i] = node
+	}
+	return nil
+}
+
+// checkLinks notes links to titles that no node in the project has as
+// its title or an alias, and that do not name a node in another project as
+// [[Project/Title]]. They are notices rather than problems: the link starts
+// working once the note is written, so a repair leaves them alone.
+func (c *checker) checkLinks() error {
+	projects := slices.SortedFunc(maps.Values(c.projects), func(a, b Project) int { return a.ID - b.ID })
+	resolver := newLinkResolver(projects, c.nodes)
+
+	for _, node := range c.nodes {
+		for _, link := range markup.ParseLinks(node.Content) {
+			if link.Embed || normalizeTitle(link.Title) == "" {
+				continue
+			}
+			if _, ok := resolver.resolve(link.Title, node.ProjectID); ok {
+				continue
+			}
+			c.report.Notices = append(c.report.Notices, Problem{
+				Kind:   ProblemDanglingLink,
+				Where:  fmt.Sprintf("nodes/%d", node.ID),
+				Detail: fmt.Sprintf("%q links to [[%s]], which has not been written yet", node.Title, link.Title),
+			})
+		}
+	}
+	return nil
+}
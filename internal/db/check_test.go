@@ -0,0 +1,80 @@
+package db
+
+import "testing"
+
+func TestCheckDanglingLinksAreNotices(t *testing.T) {
+	d := newTestDb(t, nil)
+	if err := d.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	mustNode(t, d, 1, "A", "see [[Later]] and [[B]]")
+	mustNode(t, d, 1, "B", "")
+
+	for _, repair := range []bool{false, true} {
+		report, err := d.Check(repair)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Problems) != 0 {
+			t.Errorf("Check(%v) problems = %+v", repair, report.Problems)
+		}
+		if len(report.Notices) != 1 || report.Notices[0].Kind != ProblemDanglingLink {
+			t.Errorf("Check(%v) notices = %+v", repair, report.Notices)
+		}
+		if report.Backup != "" {
+			t.Errorf("Check(%v) took a backup with nothing to repair", repair)
+		}
+	}
+	if node, err := d.GetNodeByTitle("Later", 1); err == nil {
+		t.Errorf("repair created %+v for a dangling link", node)
+	}
+}
+
+func TestCheckDuplicateTitles(t *testing.T) {
+	d := newTestDb(t, nil)
+	if err := d.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	older := mustNode(t, d, 1, "X", "older")
+	if err := d.AddNode(Node{ProjectID: 1, Title: "Y", Aliases: []string{"x (2)"}}); err != nil {
+		t.Fatal(err)
+	}
+	younger := mustNode(t, d, 1, "Younger", "younger")
+	// Give the younger node the same title, as older versions allowed.
+	err := d.Update(func(tx *Tx) error {
+		b, err := tx.bucket("nodes")
+		if err != nil {
+			return err
+		}
+		younger.Title = "x"
+		if err := tx.putRecord(b, "nodes", itob(younger.ID), younger); err != nil {
+			return err
+		}
+		return tx.RebuildIndexes()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, repair := range []bool{false, true} {
+		report, err := d.Check(repair)
+		if err != nil {
+			t.Fatalf("Check(%v): %v", repair, err)
+		}
+		if len(report.Problems) != 1 || report.Problems[0].Kind != ProblemDuplicateTitle {
+			t.Fatalf("Check(%v) problems = %+v", repair, report.Problems)
+		}
+		if repair && report.Problems[0].Repair != `renamed to "x (3)"` {
+			t.Errorf("repair = %q", report.Problems[0].Repair)
+		}
+	}
+	if node, err := d.GetNode(younger.ID); err != nil || node.Title != "x (3)" {
+		t.Errorf("younger node = %+v, %v", node, err)
+	}
+	if node, err := d.GetNode(older.ID); err != nil || node.Title != "X" {
+		t.Errorf("older node = %+v, %v", node, err)
+	}
+	if report, err := d.Check(false); err != nil || len(report.Problems) != 0 {
+		t.Errorf("Check after repair = %+v, %v", report, err)
+	}
+}
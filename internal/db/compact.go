@@ -0,0 +1,212 @@
+package db
+
+import (
+	"os"
+
+	"go.etcd.io/bbolt"
+)
+
+// bbolt never gives pages back to the file system: deleting records only
+// adds their pages to the freelist, where the old contents stay until the
+// page is reused. Compacting copies the live records into a fresh file,
+// which is as small as it can be and holds nothing that was deleted.
+
+// FileStats describes the database file.
+type FileStats struct {
+	Size      int64
+	PageSize  int
+	Pages     int // Pages in the file
+	FreePages int // Pages holding nothing live, ready for reuse
+}
+
+// CompactReport compares the file before and after Compact.
+type CompactReport struct {
+	Before FileStats
+	After  FileStats
+	// Purged is what a secure compaction removed from the trash and the
+	// history of deleted nodes before rewriting the file.
+	Purged DeleteReport
+}
+
+// Stats describes the database file as it is now.
+func (d *Db) Stats() (FileStats, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.fileStats()
+}
+
+// fileStats must be called with d.mu held.
+func (d *Db) fileStats() (FileStats, error) {
+	info, err := os.Stat(d.db.Path())
+	if err != nil {
+		return FileStats{}, err
+	}
+	stats := d.db.Stats()
+	pageSize := d.db.Info().PageSize
+	return FileStats{
+		Size:      info.Size(),
+		PageSize:  pageSize,
+		Pages:     int(info.Size() / int64(pageSize)),
+		FreePages: stats.FreePageN + stats.PendingPageN,
+	}, nil
+}
+
+// Compact rewrites the database into a fresh file and swaps it in. If
+// secure is set the trash is emptied and the revisions of nodes that no
+// longer exist are dropped first, and the old file is overwritten with
+// zeros before it is let go, so that deleted notes are not left on disk.
+// Earlier revisions of existing nodes are kept, as are backups.
+func (d *Db) Compact(secure bool) (CompactReport, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var report CompactReport
+	var err error
+	if report.Before, err = d.fileStats(); err != nil {
+		return report, err
+	}
+	if secure {
+		err := d.db.Update(func(btx *bbolt.Tx) error {
+			var err error
+			report.Purged, err = d.newTx(btx).purgeDeleted()
+			return err
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+	if err := d.rewriteFile(secure); err != nil {
+		return report, err
+	}
+	report.After, err = d.fileStats()
+	return report, err
+}
+
+// purgeDeleted purges everything in the trash and the revisions left behind
+// by nodes that no longer exist.
+func (t *Tx) purgeDeleted() (DeleteReport, error) {
+	var report DeleteReport
+	items, err := t.GetTrash()
+	if err != nil {
+		return report, err
+	}
+	for _, item := range items {
+		purged, err := t.PurgeTrash(item.ID)
+		if err != nil {
+			return report, err
+		}
+		report.NodeIDs = append(report.NodeIDs, purged.NodeIDs...)
+		report.Revisions += purged.Revisions
+		report.Attachments += purged.Attachments
+	}
+
+	nodes, err := t.bucket("nodes")
+	if err != nil {
+		return report, err
+	}
+	root, err := t.bucket("revisions")
+	if err != nil {
+		return report, err
+	}
+	var orphans []int
+	err = root.ForEach(func(k, _ []byte) error {
+		if nodes.Get(k) == nil {
+			orphans = append(orphans, btoi(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	for _, id := range orphans {
+		n, err := t.deleteRevisions(id)
+		if err != nil {
+			return report, err
+		}
+		report.Revisions += n
+	}
+	return report, nil
+}
+
+// rewriteFile copies the database into a fresh file and swaps it in, wiping
+// the old file if secure is set. It must be called with d.mu held.
+func (d *Db) rewriteFile(secure bool) error {
+	path := d.db.Path()
+	tmp := path + ".rewrite"
+	dst, err := bbolt.Open(tmp, 0600, &bbolt.Options{})
+	if err != nil {
+		return err
+	}
+	if err := bbolt.Compact(dst, d.db, 0); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// Keep hold of the old file so that it can still be wiped once the new
+	// one has taken its name.
+	var old *os.File
+	if secure {
+		if old, err = os.OpenFile(path, os.O_WRONLY, 0); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		defer old.Close()
+	}
+	if err := d.db.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	renameErr := os.Rename(tmp, path)
+	if renameErr != nil {
+		os.Remove(tmp)
+	} else if old != nil {
+		renameErr = wipe(old)
+	}
+
+	// Whatever happened above, the database has to be open again.
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{})
+	if err != nil {
+		return err
+	}
+	d.db = db
+	return renameErr
+}
+
+// WipeFile overwrites the file at path with zeros and removes it, for
+// getting rid of backups that still hold deleted notes.
+func WipeFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = wipe(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// wipe overwrites the whole of f with zeros and syncs it to disk.
+func wipe(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zeros := make([]byte, 1<<20)
+	for written := int64(0); written < info.Size(); {
+		n := min(int64(len(zeros)), info.Size()-written)
+		if _, err := f.WriteAt(zeros[:n], written); err != nil {
+			return err
+		}
+		written += n
+	}
+	return f.Sync()
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestCompact(t *testing.T) {
+	d := newTestDb(t, nil)
+	if err := d.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	kept := mustNode(t, d, 1, "Kept", "kept")
+	gone := mustNode(t, d, 1, "Gone", "canary")
+	if err := d.DeleteNode(gone.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := d.Compact(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.After.Size == 0 || len(report.Purged.NodeIDs) != 0 {
+		t.Errorf("Compact(false) = %+v", report)
+	}
+	if trash, _ := d.GetTrash(); len(trash) != 1 {
+		t.Errorf("trash after plain compaction = %+v", trash)
+	}
+	if node, err := d.GetNode(kept.ID); err != nil || node.Content != "kept" {
+		t.Errorf("GetNode after compaction = %+v, %v", node, err)
+	}
+}
+
+func TestCompactSecure(t *testing.T) {
+	d := newTestDb(t, nil)
+	if err := d.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	kept := mustNode(t, d, 1, "Kept", "kept")
+	trashed := mustNode(t, d, 1, "Trashed", "trashedcanary")
+	orphan := mustNode(t, d, 1, "Orphan", "orphan")
+	if err := d.DeleteNode(trashed.ID); err != nil {
+		t.Fatal(err)
+	}
+	// Leave revisions behind without a node, the way older versions did.
+	err := d.Update(func(tx *Tx) error {
+		nodes, err := tx.bucket("nodes")
+		if err != nil {
+			return err
+		}
+		return nodes.Delete(itob(orphan.ID))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := d.Compact(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Purged.NodeIDs) != 1 || report.Purged.NodeIDs[0] != trashed.ID || report.Purged.Revisions != 2 {
+		t.Errorf("Purged = %+v", report.Purged)
+	}
+	if trash, _ := d.GetTrash(); len(trash) != 0 {
+		t.Errorf("trash after secure compaction = %+v", trash)
+	}
+	if revisions, _ := d.GetRevisions(orphan.ID); len(revisions) != 0 {
+		t.Errorf("orphaned revisions after secure compaction = %+v", revisions)
+	}
+	if revisions, _ := d.GetRevisions(kept.ID); len(revisions) != 1 {
+		t.Errorf("revisions of a live node = %+v", revisions)
+	}
+	raw, err := os.ReadFile(d.db.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("trashedcanary")) {
+		t.Error("the trashed node is still in the file")
+	}
+}
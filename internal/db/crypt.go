@@ -0,0 +1,517 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+	"golang.org/x/crypto/argon2"
+)
+
+// An encrypted database seals every node, project, revision, trash item and
+// attachment with AES-256-GCM under a key derived from a passphrase with
+// Argon2id. The KDF parameters, the salt and a sealed check value are kept
+// in plain text at
+//
+//	meta/encryption -> JSON encryptionMeta
+//
+// Each value is sealed with its bucket path and key as additional data, so
+// that a sealed value moved to another record no longer opens. The key
+// itself only ever lives in memory. The derived indexes are left
+// empty, since titles, tags and words are exactly what encryption is meant
+// to hide, and queries scan the decrypted records instead.
+
+var (
+	// ErrLocked is returned for any access to an encrypted database that has
+	// not been unlocked.
+	ErrLocked = errors.New("database is locked")
+	// ErrWrongPassphrase is returned by Unlock when the passphrase does not
+	// match.
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+)
+
+type kdfParams struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+	Salt    []byte
+}
+
+// defaultKDF follows the second recommended option of RFC 9106 for
+// memory-constrained environments, with a few extra passes since unlocking
+// happens rarely.
+var defaultKDF = kdfParams{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+type encryptionMeta struct {
+	KDF   kdfParams
+	Check []byte // encryptionCheck sealed under the key
+}
+
+var (
+	encryptionKey   = []byte("encryption")
+	encryptionCheck = []byte("gbrain")
+)
+
+const (
+	// sealedVersion is the first byte of every sealed value, ahead of the
+	// nonce.
+	sealedVersion = 2
+	// unboundVersion marks values sealed without additional data, before
+	// schema version 11.
+	unboundVersion = 1
+)
+
+// checkAD is the additional data the check value is sealed with.
+var checkAD = sealedAD("meta", encryptionKey)
+
+// sealedAD is the additional data for the value stored under key in the
+// bucket at path, such as "nodes" or "revisions/12".
+func sealedAD(path string, key []byte) []byte {
+	ad := append([]byte(path), 0)
+	return append(ad, key...)
+}
+
+type cipherKey struct {
+	aead cipher.AEAD
+	mac  []byte
+}
+
+func newKDFParams() (kdfParams, error) {
+	params := defaultKDF
+	params.Salt = make([]byte, 16)
+	if _, err := rand.Read(params.Salt); err != nil {
+		return params, err
+	}
+	return params, nil
+}
+
+func deriveKey(passphrase []byte, params kdfParams) (*cipherKey, error) {
+	material := argon2.IDKey(passphrase, params.Salt, params.Time, params.Memory, params.Threads, 64)
+	block, err := aes.NewCipher(material[:32])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &cipherKey{aead: aead, mac: material[32:]}, nil
+}
+
+func (k *cipherKey) seal(plain, ad []byte) ([]byte, error) {
+	n := k.aead.NonceSize()
+	out := make([]byte, 1+n, 1+n+len(plain)+k.aead.Overhead())
+	out[0] = sealedVersion
+	if _, err := rand.Read(out[1:]); err != nil {
+		return nil, err
+	}
+	return k.aead.Seal(out, out[1:], plain, ad), nil
+}
+
+// open reverses seal, given the same additional data. A nil ad opens a value
+// sealed without any, before schema version 11.
+func (k *cipherKey) open(sealed, ad []byte) ([]byte, error) {
+	n := k.aead.NonceSize()
+	if len(sealed) < 1+n || (sealed[0] != sealedVersion && sealed[0] != unboundVersion) {
+		return nil, fmt.Errorf("value is not encrypted")
+	}
+	want := byte(sealedVersion)
+	if ad == nil {
+		want = unboundVersion
+	}
+	if sealed[0] != want {
+		return nil, fmt.Errorf("value is sealed in format %d, expected %d", sealed[0], want)
+	}
+	return k.aead.Open(nil, sealed[1:1+n], sealed[1+n:], ad)
+}
+
+// digest is a keyed hash, used in place of plain hashes and names in keys
+// so that they do not give away what is stored.
+func (k *cipherKey) digest(data []byte) string {
+	h := hmac.New(sha256.New, k.mac)
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newEncryptionMeta derives a key from passphrase with fresh parameters.
+func newEncryptionMeta(passphrase []byte) (encryptionMeta, *cipherKey, error) {
+	params, err := newKDFParams()
+	if err != nil {
+		return encryptionMeta{}, nil, err
+	}
+	key, err := deriveKey(passphrase, params)
+	if err != nil {
+		return encryptionMeta{}, nil, err
+	}
+	check, err := key.seal(encryptionCheck, checkAD)
+	if err != nil {
+		return encryptionMeta{}, nil, err
+	}
+	return encryptionMeta{KDF: params, Check: check}, key, nil
+}
+
+func readEncryptionMeta(tx *bbolt.Tx) (encryptionMeta, bool, error) {
+	var meta encryptionMeta
+	b := tx.Bucket([]byte("meta"))
+	if b == nil {
+		return meta, false, nil
+	}
+	v := b.Get(encryptionKey)
+	if v == nil {
+		return meta, false, nil
+	}
+	return meta, true, json.Unmarshal(v, &meta)
+}
+
+// seal encrypts the value for key in the bucket at path if the database is
+// encrypted.
+func (t *Tx) seal(path string, key, plain []byte) ([]byte, error) {
+	if !t.encrypted {
+		return plain, nil
+	}
+	if t.key == nil {
+		return nil, ErrLocked
+	}
+	return t.key.seal(plain, sealedAD(path, key))
+}
+
+// open reverses seal. The result may share memory with v, which is only
+// valid for the life of the transaction.
+func (t *Tx) open(path string, key, v []byte) ([]byte, error) {
+	if !t.encrypted {
+		return v, nil
+	}
+	if t.key == nil {
+		return nil, ErrLocked
+	}
+	if t.unbound && len(v) > 0 && v[0] == unboundVersion {
+		return t.key.open(v, nil)
+	}
+	return t.key.open(v, sealedAD(path, key))
+}
+
+// putRecord stores v as JSON under key in b, the bucket at path, sealed if
+// the database is encrypted.
+func (t *Tx) putRecord(b *bbolt.Bucket, path string, key []byte, v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sealed, err := t.seal(path, key, buf)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, sealed)
+}
+
+// decodeRecord decodes a value stored by putRecord.
+func (t *Tx) decodeRecord(path string, key, v []byte, out any) error {
+	plain, err := t.open(path, key, v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plain, out)
+}
+
+// contentHash names attachment contents: their SHA-256, or a keyed hash if
+// the database is encrypted.
+func (t *Tx) contentHash(data []byte) string {
+	if t.encrypted && t.key != nil {
+		return t.key.digest(data)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// nameKey is the key an attachment name is stored under.
+func (t *Tx) nameKey(name string) string {
+	if t.encrypted && t.key != nil {
+		return t.key.digest([]byte(name))
+	}
+	return name
+}
+
+// recrypt rewrites every sealed value from one key to another; a nil key
+// means plain text. The derived indexes are rebuilt to match: emptied when
+// encrypting and filled again when decrypting.
+func recrypt(btx *bbolt.Tx, opts Options, from, to *cipherKey) error {
+	src := &Tx{tx: btx, opts: opts, encrypted: from != nil, key: from}
+	dst := &Tx{tx: btx, opts: opts, encrypted: to != nil, key: to}
+	return resealAll(src, dst)
+}
+
+// resealAll rewrites every value read through src as dst would write it.
+// Both must share a bbolt transaction.
+func resealAll(src, dst *Tx) error {
+	reseal := func(b *bbolt.Bucket, path string) error {
+		var keys, values [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			plain, err := src.open(path, k, v)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, append([]byte(nil), k...))
+			values = append(values, append([]byte(nil), plain...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i, k := range keys {
+			sealed, err := dst.seal(path, k, values[i])
+			if err != nil {
+				return err
+			}
+			if err := b.Put(k, sealed); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, name := range []string{"nodes", "projects", "trash"} {
+		b, err := src.bucket(name)
+		if err != nil {
+			return err
+		}
+		if err := reseal(b, name); err != nil {
+			return err
+		}
+	}
+	revisions, err := src.bucket("revisions")
+	if err != nil {
+		return err
+	}
+	err = revisions.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		return reseal(revisions.Bucket(k), revisionPath(btoi(k)))
+	})
+	if err != nil {
+		return err
+	}
+
+	// Attachment keys depend on the key too, so attachments are read out in
+	// full and stored again.
+	type stored struct {
+		attachment Attachment
+		data       []byte
+	}
+	var attachments []stored
+	ab, err := src.attachmentBuckets()
+	if err != nil {
+		return err
+	}
+	err = ab.nodes.ForEach(func(k, _ []byte) error {
+		list, err := src.GetAttachments(btoi(k))
+		if err != nil {
+			return err
+		}
+		for _, attachment := range list {
+			_, data, err := src.GetAttachmentData(attachment.NodeID, attachment.Name)
+			if err != nil {
+				return err
+			}
+			attachments = append(attachments, stored{attachment, data})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	root, err := src.bucket("attachments")
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"blobs", "refs", "nodes"} {
+		if err := root.DeleteBucket([]byte(name)); err != nil {
+			return err
+		}
+		if _, err := root.CreateBucket([]byte(name)); err != nil {
+			return err
+		}
+	}
+	for _, s := range attachments {
+		if err := dst.storeAttachment(s.attachment, s.data); err != nil {
+			return err
+		}
+	}
+
+	return dst.RebuildIndexes()
+}
+
+// migrateBindSealed seals every value of an encrypted database again with
+// its bucket path and key as additional data. Values sealed by earlier
+// releases carry none, so one could be moved to another record unnoticed.
+func migrateBindSealed(tx *Tx) error {
+	if !tx.encrypted {
+		return nil
+	}
+	meta, _, err := readEncryptionMeta(tx.tx)
+	if err != nil {
+		return err
+	}
+	if meta.Check, err = tx.key.seal(encryptionCheck, checkAD); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := tx.tx.Bucket([]byte("meta")).Put(encryptionKey, buf); err != nil {
+		return err
+	}
+
+	src := *tx
+	src.unbound = true
+	return resealAll(&src, tx)
+}
+
+// Encrypted reports whether the database is encrypted.
+func (d *Db) Encrypted() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.encrypted
+}
+
+// Locked reports whether the database is encrypted and has not been
+// unlocked.
+func (d *Db) Locked() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.encrypted && d.key == nil
+}
+
+// Unlock derives the key from passphrase and, the first time, finishes the
+// initialisation that Init had to leave until the data could be read.
+func (d *Db) Unlock(passphrase []byte) error {
+	var meta encryptionMeta
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		var found bool
+		var err error
+		meta, found, err = readEncryptionMeta(tx)
+		if err == nil && !found {
+			err = fmt.Errorf("database is not encrypted")
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	key, err := deriveKey(passphrase, meta.KDF)
+	if err != nil {
+		return err
+	}
+	ad := checkAD
+	if len(meta.Check) > 0 && meta.Check[0] == unboundVersion {
+		// Written before schema version 11; the migration rebinds it.
+		ad = nil
+	}
+	if _, err := key.open(meta.Check, ad); err != nil {
+		return ErrWrongPassphrase
+	}
+
+	d.mu.Lock()
+	d.key = key
+	ready := d.ready
+	d.mu.Unlock()
+	if !ready {
+		return d.finishInit()
+	}
+	return nil
+}
+
+// Lock forgets the key, so that nothing can be read until Unlock is called
+// again.
+func (d *Db) Lock() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.key = nil
+}
+
+// EnableEncryption encrypts everything in the database under a key derived
+// from passphrase, leaving it unlocked. The file is then rewritten and the
+// old one overwritten so that no plain text is left behind.
+func (d *Db) EnableEncryption(passphrase []byte) error {
+	if d.Encrypted() {
+		return fmt.Errorf("database is already encrypted")
+	}
+	return d.rekey(passphrase)
+}
+
+// ChangePassphrase re-encrypts everything in an unlocked database under a
+// new key derived from passphrase.
+func (d *Db) ChangePassphrase(passphrase []byte) error {
+	if !d.Encrypted() {
+		return fmt.Errorf("database is not encrypted")
+	}
+	if d.Locked() {
+		return ErrLocked
+	}
+	return d.rekey(passphrase)
+}
+
+// DisableEncryption decrypts everything in an unlocked database and
+// rebuilds its indexes.
+func (d *Db) DisableEncryption() error {
+	if !d.Encrypted() {
+		return fmt.Errorf("database is not encrypted")
+	}
+	if d.Locked() {
+		return ErrLocked
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := d.db.Update(func(tx *bbolt.Tx) error {
+		if err := recrypt(tx, d.opts, d.key, nil); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("meta")).Delete(encryptionKey)
+	})
+	if err != nil {
+		return err
+	}
+	d.encrypted, d.key = false, nil
+	return nil
+}
+
+// rekey encrypts the database under a new key derived from passphrase.
+func (d *Db) rekey(passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return fmt.Errorf("passphrase must not be empty")
+	}
+	meta, key, err := newEncryptionMeta(passphrase)
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err = d.db.Update(func(tx *bbolt.Tx) error {
+		if err := recrypt(tx, d.opts, d.key, key); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("meta")).Put(encryptionKey, buf)
+	})
+	if err != nil {
+		return err
+	}
+	d.encrypted, d.key = true, key
+	return d.rewriteFile(true)
+}
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestUnlock(t *testing.T) {
+	d := newTestDb(t, nil)
+	if err := d.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	mustNode(t, d, 1, "Secret", "hidden")
+	if err := d.EnableEncryption([]byte("pw")); err != nil {
+		t.Fatal(err)
+	}
+
+	d = reopen(t, d)
+	if !d.Locked() {
+		t.Fatal("reopened database is not locked")
+	}
+	if _, err := d.GetNodes(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("GetNodes while locked: %v", err)
+	}
+	if err := d.Unlock([]byte("wrong")); err != ErrWrongPassphrase {
+		t.Fatalf("Unlock with the wrong passphrase: %v", err)
+	}
+	if err := d.Unlock([]byte("pw")); err != nil {
+		t.Fatal(err)
+	}
+	node, err := d.GetNode(1)
+	if err != nil || node.Content != "hidden" {
+		t.Fatalf("GetNode = %+v, %v", node, err)
+	}
+}
+
+func TestRecrypt(t *testing.T) {
+	d := newTestDb(t, nil)
+	if err := d.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	node := mustNode(t, d, 1, "Note", "first")
+	node.Content = "second [[Other]] #tag"
+	if err := d.UpdateNode(node); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.AddAttachment(node.ID, "a.txt", []byte("attached")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.EnableEncryption([]byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ChangePassphrase([]byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	d = reopen(t, d)
+	if err := d.Unlock([]byte("one")); err != ErrWrongPassphrase {
+		t.Fatalf("Unlock with the old passphrase: %v", err)
+	}
+	if err := d.Unlock([]byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DisableEncryption(); err != nil {
+		t.Fatal(err)
+	}
+
+	d = reopen(t, d)
+	if d.Encrypted() {
+		t.Fatal("database is still encrypted")
+	}
+	revisions, err := d.GetRevisions(node.ID)
+	if err != nil || len(revisions) != 2 || revisions[1].Content != "first" {
+		t.Fatalf("GetRevisions = %+v, %v", revisions, err)
+	}
+	if _, data, err := d.GetAttachmentData(node.ID, "a.txt"); err != nil || string(data) != "attached" {
+		t.Fatalf("GetAttachmentData = %q, %v", data, err)
+	}
+	// The indexes are rebuilt once the data is readable again.
+	if tagged, err := d.GetNodesByTag("tag"); err != nil || len(tagged) != 1 {
+		t.Fatalf("GetNodesByTag = %+v, %v", tagged, err)
+	}
+}
+
+func TestSealedValuesAreBound(t *testing.T) {
+	d := newTestDb(t, nil)
+	if err := d.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	mustNode(t, d, 1, "A", "a")
+	mustNode(t, d, 1, "B", "b")
+	if err := d.EnableEncryption([]byte("pw")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Swap the two sealed nodes, as someone who can write the file could.
+	err := d.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("nodes"))
+		a := append([]byte(nil), b.Get(itob(1))...)
+		if err := b.Put(itob(1), b.Get(itob(2))); err != nil {
+			return err
+		}
+		return b.Put(itob(2), a)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node, err := d.GetNode(1); err == nil {
+		t.Fatalf("GetNode opened a value moved from another record: %+v", node)
+	}
+}
+
+func TestMigrateBindSealed(t *testing.T) {
+	d := newTestDb(t, nil)
+	if err := d.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	mustNode(t, d, 1, "A", "a")
+	if err := d.EnableEncryption([]byte("pw")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Seal everything the way releases before schema version 11 did.
+	d.mu.RLock()
+	key := d.key
+	d.mu.RUnlock()
+	unbound := func(plain []byte) []byte {
+		n := key.aead.NonceSize()
+		out := make([]byte, 1+n)
+		out[0] = unboundVersion
+		rand.Read(out[1:])
+		return key.aead.Seal(out, out[1:], plain, nil)
+	}
+	err := d.Update(func(tx *Tx) error {
+		for _, name := range []string{"nodes", "projects"} {
+			b := tx.tx.Bucket([]byte(name))
+			var keys, values [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				plain, err := tx.open(name, k, v)
+				keys = append(keys, append([]byte(nil), k...))
+				values = append(values, unbound(plain))
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			for i, k := range keys {
+				if err := b.Put(k, values[i]); err != nil {
+					return err
+				}
+			}
+		}
+		meta, _, err := readEncryptionMeta(tx.tx)
+		if err != nil {
+			return err
+		}
+		meta.Check = unbound(encryptionCheck)
+		buf, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		if err := tx.tx.Bucket([]byte("meta")).Put(encryptionKey, buf); err != nil {
+			return err
+		}
+		return writeSchemaVersion(tx.tx, 10)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d = reopen(t, d)
+	if err := d.Unlock([]byte("pw")); err != nil {
+		t.Fatal(err)
+	}
+	node, err := d.GetNode(1)
+	if err != nil || node.Content != "a" {
+		t.Fatalf("GetNode after migration = %+v, %v", node, err)
+	}
+	err = d.db.View(func(tx *bbolt.Tx) error {
+		meta, _, err := readEncryptionMeta(tx)
+		if err != nil {
+			return err
+		}
+		for _, v := range [][]byte{meta.Check, tx.Bucket([]byte("nodes")).Get(itob(1))} {
+			if v[0] != sealedVersion {
+				t.Errorf("value still sealed in format %d", v[0])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,31 +1,73 @@
 package db
 
 import (
+	"encoding/binary"
 	"fmt"
-	"strconv"
+	"os"
+	"sync"
+	"time"
 
 	"go.etcd.io/bbolt"
 )
 
 type Db struct {
-	db *bbolt.DB
+	db   *bbolt.DB
+	opts Options
+
+	// mu guards the fields below, and is held for writing while the file is
+	// re-encrypted so that no transaction sees it half done.
+	mu        sync.RWMutex
+	encrypted bool
+	key       *cipherKey // nil while locked
+	ready     bool       // migrations and trash purge have run
+
+	// readOnly is set for a copy opened by NewDbCopy, which refuses changes
+	// once it is initialised. The copy at copyPath is deleted on Close.
+	readOnly bool
+	copyPath string
+}
+
+// Options controls policies applied by the db layer. The zero value keeps
+// everything forever.
+type Options struct {
+	// RevisionLimit is the number of revisions kept per node; 0 keeps all.
+	RevisionLimit int
+	// RevisionMaxAge discards revisions older than this, always keeping the
+	// newest one; 0 keeps revisions regardless of age.
+	RevisionMaxAge time.Duration
+	// TrashMaxAge purges trashed items this long after deletion when the
+	// database is initialised; 0 keeps them until purged by hand.
+	TrashMaxAge time.Duration
 }
 
-func NewDb(path string) (*Db, error) {
-	options := &bbolt.Options{}
-	db, err := bbolt.Open(path, 0600, options)
+// NewDb opens the database at path. A nil opts uses the zero Options. It
+// fails with ErrInUse if another process has the file open.
+func NewDb(path string, opts *Options) (*Db, error) {
+	db, err := openBolt(path, false)
 	if err != nil {
 		return nil, err
 	}
-	return &Db{db: db}, nil
+	d := &Db{db: db}
+	if opts != nil {
+		d.opts = *opts
+	}
+	return d, nil
 }
 
 func (d *Db) Close() error {
-	return d.db.Close()
+	err := d.db.Close()
+	if d.copyPath != "" {
+		os.Remove(d.copyPath)
+	}
+	return err
 }
 
+// Init creates the base buckets, applies any pending schema migrations and
+// purges expired trash. It fails with a *SchemaError if the file was written
+// by a newer release. An encrypted database is left locked, and the rest of
+// the work happens when it is first unlocked.
 func (d *Db) Init() error {
-	return d.db.Update(func(tx *bbolt.Tx) error {
+	err := d.db.Update(func(tx *bbolt.Tx) error {
 		if _, err := tx.CreateBucketIfNotExists([]byte("nodes")); err != nil {
 			return err
 		}
@@ -35,29 +77,123 @@ func (d *Db) Init() error {
 		if _, err := tx.CreateBucketIfNotExists([]byte("project_nodes")); err != nil {
 			return err
 		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("backlinks")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("qualified_backlinks")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("search")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("tags")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("properties")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("revisions")); err != nil {
+			return err
+		}
+		attachments, err := tx.CreateBucketIfNotExists([]byte("attachments"))
+		if err != nil {
+			return err
+		}
+		for _, name := range []string{"blobs", "refs", "nodes"} {
+			if _, err := attachments.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("trash")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte("meta")); err != nil {
+			return err
+		}
 		return nil
 	})
-}
+	if err != nil {
+		return err
+	}
 
-func (d *Db) GetNextID(bucketName string) (int, error) {
-	var id int
-	err := d.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return fmt.Errorf("bucket %s not found", bucketName)
+	var current int
+	err = d.db.View(func(tx *bbolt.Tx) error {
+		current = readSchemaVersion(tx)
+		_, encrypted, err := readEncryptionMeta(tx)
+		d.mu.Lock()
+		d.encrypted = encrypted
+		d.mu.Unlock()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if d.Locked() {
+		if latest := SchemaVersion(); current > latest {
+			return &SchemaError{Found: current, Supported: latest}
 		}
+		return nil
+	}
+	return d.finishInit()
+}
 
-		key, _ := b.Cursor().Last()
-		if key == nil {
-			id = 1
-		} else {
-			lastID, err := strconv.Atoi(string(key))
-			if err != nil {
-				return err
-			}
-			id = lastID + 1
+// finishInit is the part of Init that needs to read records.
+func (d *Db) finishInit() error {
+	if err := d.migrate(); err != nil {
+		return err
+	}
+
+	if d.opts.TrashMaxAge > 0 {
+		if _, err := d.PurgeExpiredTrash(d.opts.TrashMaxAge); err != nil {
+			return fmt.Errorf("purging trash: %w", err)
 		}
+	}
+	d.mu.Lock()
+	d.ready = true
+	d.mu.Unlock()
+	return nil
+}
+
+// itob encodes an ID as a fixed-width big-endian key so that keys sort in
+// numeric order.
+func itob(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// btoi decodes a key produced by itob.
+func btoi(key []byte) int {
+	return int(binary.BigEndian.Uint64(key))
+}
+
+// nextID allocates a new ID from the bucket's sequence. It must be called in
+// the same write transaction as the Put that uses the ID.
+func nextID(b *bbolt.Bucket) (int, error) {
+	seq, err := b.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	return int(seq), nil
+}
+
+// claimID moves b's sequence up to id, which a record is being stored under
+// without having been given it by nextID, so that nextID never hands it out
+// again.
+func claimID(b *bbolt.Bucket, id int) error {
+	if uint64(id) <= b.Sequence() {
 		return nil
-	})
-	return id, err
+	}
+	return b.SetSequence(uint64(id))
+}
+
+// countKeys counts the keys in b. Unlike Bucket.Stats it sees writes made
+// earlier in the same transaction.
+func countKeys(b *bbolt.Bucket) int {
+	n := 0
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		n++
+	}
+	return n
 }
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newTestDb opens an initialised database in a temporary directory.
+func newTestDb(t *testing.T, opts *Options) *Db {
+	t.Helper()
+	return openTestDb(t, filepath.Join(t.TempDir(), "test.db"), opts)
+}
+
+// openTestDb opens and initialises the database at path.
+func openTestDb(t *testing.T, path string, opts *Options) *Db {
+	t.Helper()
+	d, err := NewDb(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// reopen closes d and opens and initialises its file again.
+func reopen(t *testing.T, d *Db) *Db {
+	t.Helper()
+	path, opts := d.db.Path(), d.opts
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDb(path, &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// mustNode adds a node to projectID and returns it as stored.
+func mustNode(t *testing.T, s Store, projectID int, title, content string) Node {
+	t.Helper()
+	if err := s.AddNode(Node{ProjectID: projectID, Title: title, Content: content}); err != nil {
+		t.Fatal(err)
+	}
+	node, err := s.GetNodeByTitle(title, projectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
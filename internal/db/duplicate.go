@@ -0,0 +1,203 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pixambi/gbrain/internal/markup"
+)
+
+// Titles are unique within a project so that a link has only one place to
+// go. Stores from before this was enforced, and markdown directories edited
+// by hand, may still hold duplicates. They can be saved under the title
+// they already share, and renamed, merged or told apart with a number.
+
+// titleChanged reports whether node, which is stored as old or is new if
+// old is nil, is being given a title or project that it did not have.
+func titleChanged(old *Node, node Node) bool {
+	return old == nil || old.ProjectID != node.ProjectID || normalizeTitle(old.Title) != normalizeTitle(node.Title)
+}
+
+// titleOwner returns the oldest of nodes, other than the node with ID id,
+// whose title matches title.
+func titleOwner(nodes []Node, title string, id int) (Node, bool) {
+	key := normalizeTitle(title)
+	for _, node := range nodes {
+		if node.ID != id && normalizeTitle(node.Title) == key {
+			return node, true
+		}
+	}
+	return Node{}, false
+}
+
+// numberedTitle returns title with " (n)" added for the lowest n from 2 up
+// for which taken reports false, or title itself if it is free.
+func numberedTitle(title string, taken func(string) (bool, error)) (string, error) {
+	candidate := title
+	for n := 2; ; n++ {
+		used, err := taken(candidate)
+		if err != nil || !used {
+			return candidate, err
+		}
+		candidate = fmt.Sprintf("%s (%d)", title, n)
+	}
+}
+
+// titleTaken returns the oldest node in a project, other than the node with
+// ID id, whose title matches title.
+func (t *Tx) titleTaken(title string, projectID, id int) (Node, bool, error) {
+	var nodes []Node
+	if t.encrypted {
+		var err error
+		if nodes, err = t.GetNodesByProjectID(projectID); err != nil {
+			return Node{}, false, err
+		}
+	} else {
+		ids, err := t.nodeIDsByTitle(title, projectID)
+		if err != nil {
+			return Node{}, false, err
+		}
+		for _, candidate := range ids {
+			node, err := t.GetNode(candidate)
+			if err != nil {
+				return Node{}, false, err
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	node, taken := titleOwner(nodes, title, id)
+	return node, taken, nil
+}
+
+// freeTitle returns title, numbered if another node in the project has it.
+func (t *Tx) freeTitle(title string, projectID, id int) (string, error) {
+	return numberedTitle(title, func(candidate string) (bool, error) {
+		_, taken, err := t.titleTaken(candidate, projectID, id)
+		return taken, err
+	})
+}
+
+// mergedNode returns into with the content, tags and titles of from added:
+// the content follows into's, and from's title and aliases become aliases
+// so that links to from still arrive.
+func mergedNode(into, from Node) Node {
+	merged := into
+	switch {
+	case strings.TrimSpace(from.Content) == "":
+	case strings.TrimSpace(into.Content) == "":
+		merged.Content = from.Content
+	default:
+		merged.Content = strings.TrimRight(into.Content, "\n") + "\n\n" + from.Content
+	}
+	merged.Tags = markup.MergeTags(into.Tags, from.Tags)
+
+	keys := titleKeys(into)
+	merged.Aliases = slices.Clone(into.Aliases)
+	for _, alias := range append([]string{from.Title}, from.Aliases...) {
+		if key := normalizeTitle(alias); !slices.Contains(keys, key) {
+			keys = append(keys, key)
+			merged.Aliases = append(merged.Aliases, alias)
+		}
+	}
+	return merged
+}
+
+// MergeNodes folds the node from into the node into and moves from to the
+// trash. into gains from's content after its own, its tags, its title and
+// aliases as aliases, and copies of any attachments whose names it does
+// not already use. It returns the merged node.
+func (d *Db) MergeNodes(intoID, fromID int) (Node, error) {
+	var node Node
+	err := d.Update(func(tx *Tx) error {
+		var err error
+		node, err = tx.MergeNodes(intoID, fromID)
+		return err
+	})
+	return node, err
+}
+
+func (t *Tx) MergeNodes(intoID, fromID int) (Node, error) {
+	if intoID == fromID {
+		return Node{}, fmt.Errorf("cannot merge a node into itself")
+	}
+	into, err := t.GetNode(intoID)
+	if err != nil {
+		return Node{}, err
+	}
+	from, err := t.GetNode(fromID)
+	if err != nil {
+		return Node{}, err
+	}
+
+	if err := t.copyAttachments(fromID, intoID); err != nil {
+		return Node{}, err
+	}
+
+	// from goes first so that its title is free to become an alias.
+	if err := t.DeleteNode(fromID); err != nil {
+		return Node{}, err
+	}
+	merged := mergedNode(into, from)
+	if err := t.putNode(&merged, true); err != nil {
+		return Node{}, err
+	}
+	return merged, nil
+}
+
+// SameTitle reports whether two titles match the way links match them,
+// ignoring case and spacing.
+func SameTitle(a, b string) bool {
+	return normalizeTitle(a) == normalizeTitle(b)
+}
+
+// DuplicateTitles groups nodes that share a title within a project, each
+// group oldest first. Nodes with a title of their own are left out.
+func DuplicateTitles(nodes []Node) [][]Node {
+	type titleKey struct {
+		projectID int
+		title     string
+	}
+	sorted := slices.Clone(nodes)
+	slices.SortFunc(sorted, func(a, b Node) int { return a.ID - b.ID })
+	groups := make(map[titleKey][]Node)
+	var keys []titleKey
+	for _, node := range sorted {
+		key := titleKey{node.ProjectID, normalizeTitle(node.Title)}
+		if len(groups[key]) == 1 {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], node)
+	}
+	var duplicates [][]Node
+	for _, key := range keys {
+		duplicates = append(duplicates, groups[key])
+	}
+	return duplicates
+}
+
+// copyAttachments gives the node to copies of the attachments of the node
+// from, except for any whose names it already uses.
+func (t *Tx) copyAttachments(from, to int) error {
+	attachments, err := t.GetAttachments(from)
+	if err != nil {
+		return err
+	}
+	existing, err := t.GetAttachments(to)
+	if err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		if slices.ContainsFunc(existing, func(a Attachment) bool { return a.Name == attachment.Name }) {
+			continue
+		}
+		_, data, err := t.GetAttachmentData(from, attachment.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := t.AddAttachment(to, attachment.Name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
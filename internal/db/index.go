@@ -0,0 +1,200 @@
+package db
+
+import (
+	"slices"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// The project_nodes bucket holds one nested bucket per project, keyed by
+// project ID, laid out as:
+//
+//	project_nodes/<projectID>/ids/<nodeID>                  -> ""
+//	project_nodes/<projectID>/titles/<normalized>/<nodeID>  -> ""
+//
+// Titles map to a bucket of IDs rather than a single ID so that databases
+// that already contain duplicate titles can still be indexed. A node is
+// listed under its aliases as well as its title.
+
+// indexBuckets lists the top-level buckets that are derived entirely from
+// node records and can be dropped and rebuilt at any time.
+var indexBuckets = []string{"project_nodes", "backlinks", "qualified_backlinks", "search", "tags", "properties"}
+
+// normalizeTitle folds case and collapses whitespace so that lookups are not
+// sensitive to how a link was typed.
+func normalizeTitle(title string) string {
+	return strings.Join(strings.Fields(strings.ToLower(title)), " ")
+}
+
+// titleKeys returns the distinct normalized titles node answers to: its
+// title, then its aliases.
+func titleKeys(node Node) []string {
+	keys := []string{normalizeTitle(node.Title)}
+	for _, alias := range node.Aliases {
+		if key := normalizeTitle(alias); key != "" && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// projectIndex returns the index bucket for a project, creating it if
+// create is set. It returns nil if the bucket does not exist and create is
+// not set.
+func (t *Tx) projectIndex(projectID int, create bool) (*bbolt.Bucket, error) {
+	root, err := t.bucket("project_nodes")
+	if err != nil {
+		return nil, err
+	}
+	if !create {
+		return root.Bucket(itob(projectID)), nil
+	}
+	return root.CreateBucketIfNotExists(itob(projectID))
+}
+
+// indexNode adds node to every index. Encrypted databases have none.
+func (t *Tx) indexNode(node Node) error {
+	if t.encrypted {
+		return nil
+	}
+	pb, err := t.projectIndex(node.ProjectID, true)
+	if err != nil {
+		return err
+	}
+	ids, err := pb.CreateBucketIfNotExists([]byte("ids"))
+	if err != nil {
+		return err
+	}
+	if err := ids.Put(itob(node.ID), nil); err != nil {
+		return err
+	}
+	titles, err := pb.CreateBucketIfNotExists([]byte("titles"))
+	if err != nil {
+		return err
+	}
+	for _, key := range titleKeys(node) {
+		tb, err := titles.CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		if err := tb.Put(itob(node.ID), nil); err != nil {
+			return err
+		}
+	}
+	if err := t.indexBacklinks(node); err != nil {
+		return err
+	}
+	if err := t.indexSearch(node); err != nil {
+		return err
+	}
+	if err := t.indexTags(node); err != nil {
+		return err
+	}
+	return t.indexProperties(node)
+}
+
+// unindexNode removes node from every index. node must be the stored
+// version, not an edited copy, so that the right entries are found.
+func (t *Tx) unindexNode(node Node) error {
+	if t.encrypted {
+		return nil
+	}
+	if err := t.unindexBacklinks(node); err != nil {
+		return err
+	}
+	if err := t.unindexSearch(node); err != nil {
+		return err
+	}
+	if err := t.unindexTags(node); err != nil {
+		return err
+	}
+	if err := t.unindexProperties(node); err != nil {
+		return err
+	}
+
+	pb, err := t.projectIndex(node.ProjectID, false)
+	if err != nil || pb == nil {
+		return err
+	}
+	if ids := pb.Bucket([]byte("ids")); ids != nil {
+		if err := ids.Delete(itob(node.ID)); err != nil {
+			return err
+		}
+	}
+	titles := pb.Bucket([]byte("titles"))
+	if titles == nil {
+		return nil
+	}
+	for _, key := range titleKeys(node) {
+		tb := titles.Bucket([]byte(key))
+		if tb == nil {
+			continue
+		}
+		if err := tb.Delete(itob(node.ID)); err != nil {
+			return err
+		}
+		if k, _ := tb.Cursor().First(); k == nil {
+			if err := titles.DeleteBucket([]byte(key)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nodeIDsByTitle returns the IDs of nodes in a project whose title or an
+// alias normalizes to the same value as title, in ascending order.
+func (t *Tx) nodeIDsByTitle(title string, projectID int) ([]int, error) {
+	pb, err := t.projectIndex(projectID, false)
+	if err != nil || pb == nil {
+		return nil, err
+	}
+	titles := pb.Bucket([]byte("titles"))
+	if titles == nil {
+		return nil, nil
+	}
+	tb := titles.Bucket([]byte(normalizeTitle(title)))
+	if tb == nil {
+		return nil, nil
+	}
+	var ids []int
+	err = tb.ForEach(func(k, _ []byte) error {
+		ids = append(ids, btoi(k))
+		return nil
+	})
+	return ids, err
+}
+
+// RebuildIndexes discards every derived index and rebuilds it from the
+// stored nodes. In an encrypted database they are left empty.
+func (d *Db) RebuildIndexes() error {
+	return d.Update(func(tx *Tx) error {
+		return tx.RebuildIndexes()
+	})
+}
+
+func (t *Tx) RebuildIndexes() error {
+	t.projects = nil
+	for _, name := range indexBuckets {
+		if t.tx.Bucket([]byte(name)) != nil {
+			if err := t.tx.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		if _, err := t.tx.CreateBucket([]byte(name)); err != nil {
+			return err
+		}
+	}
+
+	nodes, err := t.GetNodes()
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := t.indexNode(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
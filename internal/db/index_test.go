@@ -0,0 +1,117 @@
+package db
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestIndexesFollowChanges(t *testing.T) {
+	d := newTestDb(t, nil)
+	projectFixture(t, d)
+	checkIndexes(t, d, "after adding")
+
+	steps := []struct {
+		name   string
+		change func() error
+	}{
+		{"update", func() error {
+			node, err := d.GetNode(2)
+			if err != nil {
+				return err
+			}
+			node.Content = "see [[Q/C]] #rust\nstatus:: open"
+			node.Tags = []string{"extra"}
+			return d.UpdateNode(node)
+		}},
+		{"rename", func() error {
+			_, err := d.RenameNode(1, "A2")
+			return err
+		}},
+		{"move", func() error {
+			_, err := d.MoveNodes([]int{2}, 2, MoveOptions{})
+			return err
+		}},
+		{"copy", func() error {
+			_, err := d.MoveNodes([]int{1}, 2, MoveOptions{Copy: true, Linked: true})
+			return err
+		}},
+		{"merge", func() error {
+			_, err := d.MergeNodes(3, 2)
+			return err
+		}},
+		{"delete", func() error { return d.DeleteNode(1) }},
+		{"restore", func() error {
+			trash, err := d.GetTrash()
+			if err != nil {
+				return err
+			}
+			return d.RestoreTrash(trash[0].ID)
+		}},
+		{"rename project", func() error { return d.UpdateProject(Project{ID: 1, Name: "Renamed"}) }},
+		{"revert", func() error {
+			revisions, err := d.GetRevisions(3)
+			if err != nil {
+				return err
+			}
+			_, err = d.RestoreRevision(3, revisions[len(revisions)-1].ID)
+			return err
+		}},
+		{"delete project", func() error {
+			_, err := d.DeleteProject(2)
+			return err
+		}},
+	}
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		checkIndexes(t, d, "after "+step.name)
+	}
+}
+
+func TestProjectNodesFollowMoves(t *testing.T) {
+	for name, s := range map[string]Store{"db": newTestDb(t, nil), "mem": NewMemStore(nil)} {
+		t.Run(name, func(t *testing.T) {
+			projectFixture(t, s)
+			titles := func(projectID int) []string {
+				t.Helper()
+				nodes, err := s.GetNodesByProjectID(projectID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var titles []string
+				for _, node := range nodes {
+					titles = append(titles, node.Title)
+				}
+				return titles
+			}
+
+			if _, err := s.MoveNodes([]int{2}, 2, MoveOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := titles(1); !slices.Equal(got, []string{"A"}) {
+				t.Errorf("project 1 after moving = %q", got)
+			}
+			if got := titles(2); !slices.Equal(got, []string{"B", "C"}) {
+				t.Errorf("project 2 after moving = %q", got)
+			}
+			if node, err := s.GetNodeByTitle("b", 2); err != nil || node.ID != 2 {
+				t.Errorf("GetNodeByTitle in the new project = %+v, %v", node, err)
+			}
+			if _, err := s.GetNodeByTitle("b", 1); err == nil {
+				t.Error("GetNodeByTitle still finds the node in its old project")
+			}
+
+			if _, err := s.RenameNode(2, "Bee"); err != nil {
+				t.Fatal(err)
+			}
+			if got := titles(2); !slices.Equal(got, []string{"Bee", "C"}) {
+				t.Errorf("project 2 after renaming = %q", got)
+			}
+			// The old title still leads to the node as an alias.
+			if node, err := s.GetNodeByTitle("B", 2); err != nil || node.ID != 2 {
+				t.Errorf("GetNodeByTitle by the old title = %+v, %v", node, err)
+			}
+		})
+	}
+}
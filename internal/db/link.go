@@ -0,0 +1,134 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+)
+
+// A link is resolved in the project of the note it is written in. A link
+// that names no note there can reach into another project as
+// [[Project/Title]]; since both names may hold slashes, every split is
+// tried from the left until one names a project with a matching note.
+
+// projectSplit is a reading of a link as [[Project/Title]].
+type projectSplit struct {
+	project Project
+	title   string
+}
+
+// projectSplits returns every way of reading title as a title in one of
+// projects, leftmost split first.
+func projectSplits(title string, projects []Project) []projectSplit {
+	var splits []projectSplit
+	for i := range len(title) {
+		if title[i] != '/' {
+			continue
+		}
+		name := normalizeTitle(title[:i])
+		if name == "" {
+			continue
+		}
+		for _, project := range projects {
+			if normalizeTitle(project.Name) == name {
+				splits = append(splits, projectSplit{project: project, title: title[i+1:]})
+			}
+		}
+	}
+	return splits
+}
+
+// resolveLink returns the node a link to title written in project projectID
+// leads to, finding titles within a project with lookup.
+func resolveLink(title string, projectID int, projects []Project, lookup func(title string, projectID int) (Node, error)) (Node, error) {
+	if node, err := lookup(title, projectID); err == nil {
+		return node, nil
+	}
+	for _, split := range projectSplits(title, projects) {
+		if node, err := lookup(split.title, split.project.ID); err == nil {
+			return node, nil
+		}
+	}
+	return Node{}, fmt.Errorf("node not found")
+}
+
+// linkReaches reports whether a link written in project from names a node
+// in project to by one of the normalized titles in keys. Unlike
+// resolveLink it does not check that nothing nearer has the title.
+func linkReaches(link string, from int, to Project, keys []string) bool {
+	if from == to.ID {
+		return slices.Contains(keys, normalizeTitle(link))
+	}
+	for _, split := range projectSplits(link, []Project{to}) {
+		if slices.Contains(keys, normalizeTitle(split.title)) {
+			return true
+		}
+	}
+	return false
+}
+
+// linkResolver resolves link titles against a snapshot of the store.
+type linkResolver struct {
+	projects []Project
+	nodes    map[int][]Node // By project ID, in ID order
+}
+
+func newLinkResolver(projects []Project, nodes []Node) *linkResolver {
+	r := &linkResolver{projects: projects, nodes: make(map[int][]Node)}
+	for _, node := range nodes {
+		r.nodes[node.ProjectID] = append(r.nodes[node.ProjectID], node)
+	}
+	return r
+}
+
+// resolve returns the node a link to title written in project projectID
+// leads to.
+func (r *linkResolver) resolve(title string, projectID int) (Node, bool) {
+	node, err := resolveLink(title, projectID, r.projects, func(title string, projectID int) (Node, error) {
+		return scanNodeByTitle(r.nodes[projectID], title, projectID)
+	})
+	return node, err == nil
+}
+
+// linkText returns how a note in project projectID links to target: by
+// title alone in the same project, or qualified with the project's name.
+func (r *linkResolver) linkText(target Node, projectID int) string {
+	if target.ProjectID == projectID {
+		return target.Title
+	}
+	i := slices.IndexFunc(r.projects, func(p Project) bool { return p.ID == target.ProjectID })
+	if i < 0 {
+		return target.Title
+	}
+	return r.projects[i].Name + "/" + target.Title
+}
+
+// ResolveLink returns the node that a [[link]] to title, written in a note
+// in project projectID, leads to: a node of that project with the title or
+// an alias, or else a node of another project named as Project/Title.
+func (d *Db) ResolveLink(title string, projectID int) (Node, error) {
+	var node Node
+	err := d.View(func(tx *Tx) error {
+		var err error
+		node, err = tx.ResolveLink(title, projectID)
+		return err
+	})
+	return node, err
+}
+
+func (t *Tx) ResolveLink(title string, projectID int) (Node, error) {
+	projects, err := t.GetProjects()
+	if err != nil {
+		return Node{}, err
+	}
+	if t.encrypted {
+		nodes, err := t.GetNodes()
+		if err != nil {
+			return Node{}, err
+		}
+		if node, ok := newLinkResolver(projects, nodes).resolve(title, projectID); ok {
+			return node, nil
+		}
+		return Node{}, fmt.Errorf("node not found")
+	}
+	return resolveLink(title, projectID, projects, t.GetNodeByTitle)
+}
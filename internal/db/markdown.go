@@ -0,0 +1,888 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pixambi/gbrain/internal/markup"
+)
+
+// MarkdownStore keeps notes as ordinary markdown files that can be read,
+// grepped and versioned with other tools:
+//
+//	<dir>/<project>/.project.json              -> project ID and timestamps
+//	<dir>/<project>/<title>.md                 -> node metadata, then content
+//	<dir>/.gbrain/state.json                   -> ID sequences and preferences
+//	<dir>/.gbrain/revisions/<nodeID>.json      -> a node's revisions
+//	<dir>/.gbrain/trash/<trashID>.json         -> a trashed node or project
+//	<dir>/.gbrain/attachments/<nodeID>.json    -> a node's attachments
+//	<dir>/.gbrain/blobs/<hash>                 -> attachment contents
+//	<dir>/.gbrain/orphans/<projectID>/<title>.md -> nodes whose project is gone
+//
+// Directory and file names are the escaped project name and node title; see
+// escapeName. Init reads everything into a MemStore, which answers every
+// query, and each change is then written back by rewriting only the files
+// whose contents differ. Notes added or edited by other programs are picked
+// up the next time the store is opened.
+type MarkdownStore struct {
+	*MemStore
+	dir string
+
+	mu      sync.Mutex        // Serialises each change with writing it out
+	written map[string][]byte // Slash-separated path -> contents on disk
+}
+
+const (
+	markdownFormat = 1
+	markdownMeta   = ".gbrain"
+	projectFile    = ".project.json"
+)
+
+type markdownState struct {
+	Format     int
+	NodeSeq    int
+	ProjectSeq int
+	TrashSeq   int
+	Prefs      map[string]string
+}
+
+type markdownProject struct {
+	ID       int
+	Created  time.Time
+	Modified time.Time
+}
+
+type markdownRevisions struct {
+	Seq       int
+	Revisions []json.RawMessage
+}
+
+// NewMarkdownStore uses dir, creating it if necessary, to hold notes as
+// markdown files. A nil opts uses the zero Options.
+func NewMarkdownStore(dir string, opts *Options) (*MarkdownStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &MarkdownStore{
+		MemStore: NewMemStore(opts),
+		dir:      dir,
+		written:  make(map[string][]byte),
+	}, nil
+}
+
+// Init reads the directory, adopting markdown files that gbrain has not
+// seen before, and purges expired trash. Notes whose files were changed
+// by another program get a new revision.
+func (s *MarkdownStore) Init() error {
+	s.mu.Lock()
+	err := s.load()
+	if err == nil {
+		err = s.flush()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if s.opts.TrashMaxAge > 0 {
+		if _, err := s.PurgeExpiredTrash(s.opts.TrashMaxAge); err != nil {
+			return fmt.Errorf("purging trash: %w", err)
+		}
+	}
+	return nil
+}
+
+// readFile reads a file below the store's directory and remembers its
+// contents so that flush does not write it back unchanged.
+func (s *MarkdownStore) readFile(name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+	s.written[name] = data
+	return data, nil
+}
+
+func (s *MarkdownStore) readJSON(name string, v any) error {
+	data, err := s.readFile(name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", name, err)
+	}
+	return nil
+}
+
+// readDir lists the entries of a directory below the store's directory,
+// treating a missing directory as empty.
+func (s *MarkdownStore) readDir(name string) ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, filepath.FromSlash(name)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// loadedNode is a node read from disk before IDs have been settled.
+type loadedNode struct {
+	node    Node
+	project *Project
+	known   bool // The file had a metadata block
+	modTime time.Time
+}
+
+func (s *MarkdownStore) load() error {
+	m := s.MemStore
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var state markdownState
+	err := s.readJSON(path.Join(markdownMeta, "state.json"), &state)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if state.Format > markdownFormat {
+		return &SchemaError{Found: state.Format, Supported: markdownFormat}
+	}
+	m.nodeSeq, m.projectSeq, m.trashSeq = state.NodeSeq, state.ProjectSeq, state.TrashSeq
+	maps.Copy(m.prefs, state.Prefs)
+
+	var projects []*Project
+	var nodes []loadedNode
+	entries, err := s.readDir(".")
+	if err != nil {
+		return err
+	}
+	dirs := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs[strings.ToLower(entry.Name())] = true
+		}
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		project, found, err := s.loadProject(entry.Name(), dirs)
+		if err != nil {
+			return err
+		}
+		dirNodes, err := s.loadNodes(entry.Name(), project)
+		if err != nil {
+			return err
+		}
+		if found || len(dirNodes) > 0 {
+			projects = append(projects, project)
+			nodes = append(nodes, dirNodes...)
+		}
+	}
+
+	orphans, err := s.readDir(path.Join(markdownMeta, "orphans"))
+	if err != nil {
+		return err
+	}
+	for _, entry := range orphans {
+		projectID, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		dirNodes, err := s.loadNodes(path.Join(markdownMeta, "orphans", entry.Name()), &Project{ID: projectID})
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, dirNodes...)
+	}
+
+	// Projects and nodes without an ID, or whose file was copied from
+	// another one, are given new IDs once every existing ID is known.
+	for _, project := range projects {
+		m.projectSeq = max(m.projectSeq, project.ID)
+	}
+	for _, project := range projects {
+		if _, ok := m.projects[project.ID]; ok || project.ID == 0 {
+			m.projectSeq++
+			project.ID = m.projectSeq
+		}
+		buf, err := json.Marshal(project)
+		if err != nil {
+			return err
+		}
+		m.projects[project.ID] = buf
+	}
+
+	for _, n := range nodes {
+		m.nodeSeq = max(m.nodeSeq, n.node.ID)
+	}
+	for i := range nodes {
+		n := &nodes[i]
+		n.node.ProjectID = n.project.ID
+		if _, ok := m.nodes[n.node.ID]; ok || !n.known || n.node.ID == 0 {
+			m.nodeSeq++
+			n.node.ID = m.nodeSeq
+		}
+		n.node.Properties = markup.ParseProperties(n.node.Content)
+		buf, err := json.Marshal(n.node)
+		if err != nil {
+			return err
+		}
+		m.nodes[n.node.ID] = buf
+	}
+
+	if err := s.loadHistory(); err != nil {
+		return err
+	}
+
+	// Record edits made outside gbrain as revisions, so that they show up in
+	// the history and can be undone.
+	for _, n := range nodes {
+		history, err := decodeAll[Revision](m.revisions[n.node.ID])
+		if err != nil {
+			return err
+		}
+		if len(history) > 0 {
+			last := history[len(history)-1]
+			if last.Title == n.node.Title && last.Content == n.node.Content {
+				continue
+			}
+		}
+		node := n.node
+		if n.known {
+			node.Modified = n.modTime
+		}
+		if err := m.putNode(&node, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadProject reads a project directory's metadata. found is false if the
+// directory has none, in which case the project is new. dirs holds the
+// lower-cased names of the directories next to it.
+func (s *MarkdownStore) loadProject(dir string, dirs map[string]bool) (project *Project, found bool, err error) {
+	project = &Project{Name: parseName(dir, 0, dirs)}
+
+	var meta markdownProject
+	err = s.readJSON(path.Join(dir, projectFile), &meta)
+	if errors.Is(err, fs.ErrNotExist) {
+		info, err := os.Stat(filepath.Join(s.dir, dir))
+		if err != nil {
+			return nil, false, err
+		}
+		project.Created, project.Modified = info.ModTime(), info.ModTime()
+		return project, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	project.ID, project.Created, project.Modified = meta.ID, meta.Created, meta.Modified
+	project.Name = parseName(dir, meta.ID, dirs)
+	return project, true, nil
+}
+
+// loadNodes reads every markdown file directly inside dir as a node of
+// project.
+func (s *MarkdownStore) loadNodes(dir string, project *Project) ([]loadedNode, error) {
+	entries, err := s.readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]bool)
+	for _, entry := range entries {
+		if name := entry.Name(); !entry.IsDir() && strings.HasSuffix(name, ".md") {
+			files[strings.ToLower(strings.TrimSuffix(name, ".md"))] = true
+		}
+	}
+
+	var nodes []loadedNode
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".md") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		data, err := s.readFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		node, known, err := decodeNodeFile(data)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path.Join(dir, name), err)
+		}
+		node.Title = parseName(strings.TrimSuffix(name, ".md"), node.ID, files)
+		if !known {
+			node.Created, node.Modified = info.ModTime(), info.ModTime()
+		}
+		nodes = append(nodes, loadedNode{node: node, project: project, known: known, modTime: info.ModTime()})
+	}
+	return nodes, nil
+}
+
+// loadHistory reads revisions, the trash and attachments.
+func (s *MarkdownStore) loadHistory() error {
+	m := s.MemStore
+
+	entries, err := s.readDir(path.Join(markdownMeta, "revisions"))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		nodeID, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		var revisions markdownRevisions
+		if err := s.readJSON(path.Join(markdownMeta, "revisions", entry.Name()), &revisions); err != nil {
+			return err
+		}
+		m.revisions[nodeID] = make(map[int][]byte)
+		m.revisionSeq[nodeID] = revisions.Seq
+		for _, raw := range revisions.Revisions {
+			var rev Revision
+			if err := json.Unmarshal(raw, &rev); err != nil {
+				return err
+			}
+			m.revisions[nodeID][rev.ID] = raw
+		}
+	}
+
+	entries, err = s.readDir(path.Join(markdownMeta, "trash"))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		raw, err := s.readFile(path.Join(markdownMeta, "trash", entry.Name()))
+		if err != nil {
+			return err
+		}
+		var item TrashItem
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return fmt.Errorf("parsing trash item %s: %w", entry.Name(), err)
+		}
+		m.trash[item.ID] = raw
+		m.trashSeq = max(m.trashSeq, item.ID)
+	}
+
+	entries, err = s.readDir(path.Join(markdownMeta, "attachments"))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		var raws []json.RawMessage
+		if err := s.readJSON(path.Join(markdownMeta, "attachments", entry.Name()), &raws); err != nil {
+			return err
+		}
+		for _, raw := range raws {
+			var attachment Attachment
+			if err := json.Unmarshal(raw, &attachment); err != nil {
+				return err
+			}
+			if _, ok := m.blobs[attachment.Hash]; !ok {
+				data, err := s.readFile(path.Join(markdownMeta, "blobs", attachment.Hash))
+				if err != nil {
+					return fmt.Errorf("attachment %s: %w", attachment.Name, err)
+				}
+				m.blobs[attachment.Hash] = data
+				m.blobRefs[attachment.Hash] = make(map[string]bool)
+			}
+			m.blobRefs[attachment.Hash][string(attachmentRefKey(attachment.NodeID, attachment.Name))] = true
+			if m.attachments[attachment.NodeID] == nil {
+				m.attachments[attachment.NodeID] = make(map[string][]byte)
+			}
+			m.attachments[attachment.NodeID][attachment.Name] = raw
+		}
+	}
+	return nil
+}
+
+// render returns the contents every file should have, keyed by
+// slash-separated path.
+func (s *MarkdownStore) render() (map[string][]byte, error) {
+	m := s.MemStore
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	files := make(map[string][]byte)
+	put := func(name string, v any) error {
+		buf, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		files[name] = append(buf, '\n')
+		return nil
+	}
+
+	projects, err := decodeAll[Project](m.projects)
+	if err != nil {
+		return nil, err
+	}
+	dirNames := make(map[string]bool)
+	for _, project := range projects {
+		dirNames[strings.ToLower(escapeName(project.Name, 0, nil))] = true
+	}
+	dirs := make(map[int]string)
+	takenDirs := make(map[string]bool)
+	for _, project := range projects {
+		dir := uniqueName(project.Name, project.ID, takenDirs, dirNames)
+		dirs[project.ID] = dir
+		if err := put(path.Join(dir, projectFile), markdownProject{project.ID, project.Created, project.Modified}); err != nil {
+			return nil, err
+		}
+	}
+
+	nodes, err := decodeAll[Node](m.nodes)
+	if err != nil {
+		return nil, err
+	}
+	nodeDir := func(node Node) string {
+		if dir, ok := dirs[node.ProjectID]; ok {
+			return dir
+		}
+		return path.Join(markdownMeta, "orphans", strconv.Itoa(node.ProjectID))
+	}
+	fileNames := make(map[string]map[string]bool)
+	for _, node := range nodes {
+		dir := nodeDir(node)
+		if fileNames[dir] == nil {
+			fileNames[dir] = make(map[string]bool)
+		}
+		fileNames[dir][strings.ToLower(escapeName(node.Title, 0, nil))] = true
+	}
+	takenFiles := make(map[string]map[string]bool)
+	for _, node := range nodes {
+		dir := nodeDir(node)
+		if takenFiles[dir] == nil {
+			takenFiles[dir] = make(map[string]bool)
+		}
+		data, err := encodeNodeFile(node)
+		if err != nil {
+			return nil, err
+		}
+		files[path.Join(dir, uniqueName(node.Title, node.ID, takenFiles[dir], fileNames[dir])+".md")] = data
+	}
+
+	for nodeID, records := range m.revisions {
+		revisions := markdownRevisions{Seq: m.revisionSeq[nodeID]}
+		for _, id := range slices.Sorted(maps.Keys(records)) {
+			revisions.Revisions = append(revisions.Revisions, records[id])
+		}
+		if err := put(path.Join(markdownMeta, "revisions", strconv.Itoa(nodeID)+".json"), revisions); err != nil {
+			return nil, err
+		}
+	}
+	for id, record := range m.trash {
+		if err := put(path.Join(markdownMeta, "trash", strconv.Itoa(id)+".json"), json.RawMessage(record)); err != nil {
+			return nil, err
+		}
+	}
+	for nodeID, records := range m.attachments {
+		var raws []json.RawMessage
+		for _, name := range slices.Sorted(maps.Keys(records)) {
+			raws = append(raws, records[name])
+		}
+		if err := put(path.Join(markdownMeta, "attachments", strconv.Itoa(nodeID)+".json"), raws); err != nil {
+			return nil, err
+		}
+	}
+	for hash, data := range m.blobs {
+		files[path.Join(markdownMeta, "blobs", hash)] = data
+	}
+
+	state := markdownState{
+		Format:     markdownFormat,
+		NodeSeq:    m.nodeSeq,
+		ProjectSeq: m.projectSeq,
+		TrashSeq:   m.trashSeq,
+		Prefs:      m.prefs,
+	}
+	if err := put(path.Join(markdownMeta, "state.json"), state); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// flush brings the directory in line with the in-memory state, writing
+// files whose contents changed and removing those no longer needed.
+func (s *MarkdownStore) flush() error {
+	files, err := s.render()
+	if err != nil {
+		return err
+	}
+	return s.writeFiles(files)
+}
+
+// writeFiles brings the directory in line with files, as returned by
+// render.
+func (s *MarkdownStore) writeFiles(files map[string][]byte) error {
+	folded := make(map[string]bool, len(files))
+	for name := range files {
+		folded[strings.ToLower(name)] = true
+	}
+	var stale []string
+	for name := range s.written {
+		if _, ok := files[name]; !ok {
+			stale = append(stale, name)
+		}
+	}
+	slices.Sort(stale)
+
+	// A file renamed only in case must be removed before the new name is
+	// written, or on a case-insensitive file system removing it afterwards
+	// would delete the new file. Anything else is removed last so that a
+	// failed write never loses the old copy.
+	for _, name := range stale {
+		if folded[strings.ToLower(name)] {
+			if err := s.remove(name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		if old, ok := s.written[name]; ok && bytes.Equal(old, files[name]) {
+			continue
+		}
+		if err := s.write(name, files[name]); err != nil {
+			return err
+		}
+	}
+	for _, name := range stale {
+		if _, ok := s.written[name]; ok {
+			if err := s.remove(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// write replaces a file atomically.
+func (s *MarkdownStore) write(name string, data []byte) error {
+	full := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(full), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), full); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	s.written[name] = data
+	return nil
+}
+
+// remove deletes a file and any directories left empty by it.
+func (s *MarkdownStore) remove(name string) error {
+	err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(name)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	delete(s.written, name)
+	for dir := path.Dir(name); dir != "." && dir != markdownMeta; dir = path.Dir(dir) {
+		if os.Remove(filepath.Join(s.dir, filepath.FromSlash(dir))) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// change applies fn to the in-memory state and writes the result out. If
+// it would overwrite or remove notes that another program has edited since
+// they were read, those are read again and fn is applied afresh, so that
+// their edits are built on rather than lost. If writing fails the
+// in-memory state is put back as it was, so that the failed change is not
+// written out later by another one.
+func (s *MarkdownStore) change(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for reloaded := false; ; reloaded = true {
+		saved := s.save()
+		if err := fn(); err != nil {
+			return err
+		}
+		files, err := s.render()
+		if err == nil && !reloaded {
+			var edited []string
+			if edited, err = s.editedOutside(files); err == nil && len(edited) > 0 {
+				s.undo(saved)
+				if err := s.reload(edited); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if err == nil {
+			err = s.writeFiles(files)
+		}
+		if err != nil {
+			s.undo(saved)
+		}
+		return err
+	}
+}
+
+// save copies the in-memory state for undo.
+func (s *MarkdownStore) save() memState {
+	s.MemStore.mu.RLock()
+	defer s.MemStore.mu.RUnlock()
+	return s.MemStore.snapshot()
+}
+
+// undo puts back the in-memory state copied by save.
+func (s *MarkdownStore) undo(saved memState) {
+	s.MemStore.mu.Lock()
+	defer s.MemStore.mu.Unlock()
+	s.MemStore.restore(saved)
+}
+
+// editedOutside returns the notes that writing files would overwrite or
+// remove although they no longer hold what was last read or written there.
+func (s *MarkdownStore) editedOutside(files map[string][]byte) ([]string, error) {
+	var edited []string
+	for _, name := range slices.Sorted(maps.Keys(s.written)) {
+		if !strings.HasSuffix(name, ".md") {
+			continue
+		}
+		if data, ok := files[name]; ok && bytes.Equal(data, s.written[name]) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(name)))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(data, s.written[name]) {
+			edited = append(edited, name)
+		}
+	}
+	return edited, nil
+}
+
+// reload takes in the notes edited by other programs, as returned by
+// editedOutside.
+func (s *MarkdownStore) reload(names []string) error {
+	m := s.MemStore
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range names {
+		if err := s.reloadFile(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MarkdownStore) AddProject(project Project) error {
+	return s.change(func() error { return s.MemStore.AddProject(project) })
+}
+
+func (s *MarkdownStore) UpdateProject(project Project) error {
+	return s.change(func() error { return s.MemStore.UpdateProject(project) })
+}
+
+func (s *MarkdownStore) DeleteProject(id int) (DeleteReport, error) {
+	var report DeleteReport
+	err := s.change(func() error {
+		var err error
+		report, err = s.MemStore.DeleteProject(id)
+		return err
+	})
+	return report, err
+}
+
+func (s *MarkdownStore) AddNode(node Node) error {
+	return s.change(func() error { return s.MemStore.AddNode(node) })
+}
+
+// UpdateNode first takes in any change another program has made to the
+// node's file since it was read, so that overwriting it is reported as a
+// conflict.
+func (s *MarkdownStore) UpdateNode(node Node) error {
+	return s.change(func() error {
+		if err := s.reloadNode(node.ID); err != nil {
+			return err
+		}
+		return s.MemStore.UpdateNode(node)
+	})
+}
+
+// reloadNode reads the file of node id again, as reloadFile does.
+func (s *MarkdownStore) reloadNode(id int) error {
+	m := s.MemStore
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node, err := m.getNode(id)
+	if err != nil {
+		return nil
+	}
+
+	// The file name starts with the title, up to any " (n)" ending, which
+	// may be escaped or not.
+	base, _ := suffixBase(node.Title, 0, nil)
+	prefix := escapeName(base, 0, nil)
+	for name, written := range s.written {
+		if !strings.HasSuffix(name, ".md") || !strings.HasPrefix(path.Base(name), prefix) {
+			continue
+		}
+		if stored, known, err := decodeNodeFile(written); err == nil && known && stored.ID == id {
+			return s.reloadFile(name)
+		}
+	}
+	return nil
+}
+
+// reloadFile reads the note in file name again and, if it no longer holds
+// what was last written there, records its content and tags as a new
+// revision of the node, as Init would. A file that was removed is left for
+// the next Init to sort out; one that no longer holds the same node, such
+// as one stripped of its metadata, is an error rather than something to
+// write over. It must be called with s.MemStore.mu held.
+func (s *MarkdownStore) reloadFile(name string) error {
+	m := s.MemStore
+	written := s.written[name]
+	stored, known, err := decodeNodeFile(written)
+	if err != nil || !known {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(name)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, written) {
+		return nil
+	}
+	outside, known, err := decodeNodeFile(data)
+	if err != nil || !known || outside.ID != stored.ID {
+		return fmt.Errorf("%s was changed by another program; reopen the notes to take it in", name)
+	}
+	node, err := m.getNode(stored.ID)
+	if err != nil {
+		return nil
+	}
+	s.written[name] = data
+	node.Content, node.Tags = outside.Content, outside.Tags
+	return m.putNode(&node, true)
+}
+
+func (s *MarkdownStore) RenameNode(id int, title string) (RenamePlan, error) {
+	var plan RenamePlan
+	err := s.change(func() error {
+		var err error
+		plan, err = s.MemStore.RenameNode(id, title)
+		return err
+	})
+	return plan, err
+}
+
+func (s *MarkdownStore) MoveNodes(ids []int, projectID int, opts MoveOptions) (MovePlan, error) {
+	var plan MovePlan
+	err := s.change(func() error {
+		var err error
+		plan, err = s.MemStore.MoveNodes(ids, projectID, opts)
+		return err
+	})
+	return plan, err
+}
+
+func (s *MarkdownStore) MergeNodes(intoID, fromID int) (Node, error) {
+	var node Node
+	err := s.change(func() error {
+		var err error
+		node, err = s.MemStore.MergeNodes(intoID, fromID)
+		return err
+	})
+	return node, err
+}
+
+func (s *MarkdownStore) DeleteNode(id int) error {
+	return s.change(func() error { return s.MemStore.DeleteNode(id) })
+}
+
+func (s *MarkdownStore) RestoreRevision(nodeID, revisionID int) (Node, error) {
+	var node Node
+	err := s.change(func() error {
+		var err error
+		node, err = s.MemStore.RestoreRevision(nodeID, revisionID)
+		return err
+	})
+	return node, err
+}
+
+func (s *MarkdownStore) RestoreTrash(id int) error {
+	return s.change(func() error { return s.MemStore.RestoreTrash(id) })
+}
+
+func (s *MarkdownStore) PurgeTrash(id int) (DeleteReport, error) {
+	var report DeleteReport
+	err := s.change(func() error {
+		var err error
+		report, err = s.MemStore.PurgeTrash(id)
+		return err
+	})
+	return report, err
+}
+
+func (s *MarkdownStore) PurgeExpiredTrash(maxAge time.Duration) (DeleteReport, error) {
+	var report DeleteReport
+	err := s.change(func() error {
+		var err error
+		report, err = s.MemStore.PurgeExpiredTrash(maxAge)
+		return err
+	})
+	return report, err
+}
+
+func (s *MarkdownStore) AddAttachment(nodeID int, name string, data []byte) (Attachment, error) {
+	var attachment Attachment
+	err := s.change(func() error {
+		var err error
+		attachment, err = s.MemStore.AddAttachment(nodeID, name, data)
+		return err
+	})
+	return attachment, err
+}
+
+func (s *MarkdownStore) RemoveAttachment(nodeID int, name string) error {
+	return s.change(func() error { return s.MemStore.RemoveAttachment(nodeID, name) })
+}
+
+func (s *MarkdownStore) SetPreference(key, value string) error {
+	return s.change(func() error { return s.MemStore.SetPreference(key, value) })
+}
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pixambi/gbrain/internal/markup"
+)
+
+// A node file starts with a frontmatter block whose first lines are the
+// node's metadata, always in this order:
+//
+//	---
+//	id: 12
+//	created: 2024-01-05T10:00:00Z
+//	modified: 2024-01-06T09:30:00Z
+//	tags: ["go","db"]
+//	aliases: ["Old title"]
+//	---
+//	content
+//
+// The aliases line is left out when the node has none, unless the content's
+// own frontmatter starts with an aliases line, which would otherwise be
+// read back as the node's.
+// If the content has frontmatter of its own the metadata lines are inserted
+// at the top of it instead, so that other tools see a single block. Reading
+// the file removes exactly those lines again, giving back the content as it
+// was saved.
+var nodeFileKeys = []string{"id", "created", "modified", "tags"}
+
+const aliasesKey = "aliases"
+
+// encodeNodeFile renders node as the contents of its markdown file.
+func encodeNodeFile(node Node) ([]byte, error) {
+	tags := node.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return nil, err
+	}
+	block := fmt.Sprintf("id: %d\ncreated: %s\nmodified: %s\ntags: %s\n",
+		node.ID,
+		node.Created.Format(time.RFC3339Nano),
+		node.Modified.Format(time.RFC3339Nano),
+		tagsJSON)
+	frontmatter, _, _ := markup.SplitFrontmatter(node.Content)
+	start := strings.Index(node.Content, "\n") + 1
+	if len(node.Aliases) > 0 || frontmatter != "" && strings.HasPrefix(node.Content[start:], aliasesKey+": ") {
+		aliases := node.Aliases
+		if aliases == nil {
+			aliases = []string{}
+		}
+		aliasesJSON, err := json.Marshal(aliases)
+		if err != nil {
+			return nil, err
+		}
+		block += fmt.Sprintf("%s: %s\n", aliasesKey, aliasesJSON)
+	}
+
+	if frontmatter != "" {
+		return []byte(node.Content[:start] + block + node.Content[start:]), nil
+	}
+	return []byte("---\n" + block + "---\n" + node.Content), nil
+}
+
+// decodeNodeFile reads a node file. ok is false if the file has no metadata
+// block, such as a note written by another program, in which case the whole
+// file is the content.
+func decodeNodeFile(data []byte) (node Node, ok bool, err error) {
+	text := string(data)
+	node.Content = text
+	if !strings.HasPrefix(text, "---\n") && !strings.HasPrefix(text, "---\r\n") {
+		return node, false, nil
+	}
+
+	start := strings.Index(text, "\n") + 1
+	pos := start
+	values := make([]string, len(nodeFileKeys))
+	for i, key := range nodeFileKeys {
+		end := strings.Index(text[pos:], "\n")
+		if end < 0 || !strings.HasPrefix(text[pos:], key+": ") {
+			return node, false, nil
+		}
+		values[i] = text[pos+len(key)+2 : pos+end]
+		pos += end + 1
+	}
+
+	if node.ID, err = strconv.Atoi(values[0]); err != nil {
+		return node, false, fmt.Errorf("invalid id: %w", err)
+	}
+	if node.Created, err = time.Parse(time.RFC3339Nano, values[1]); err != nil {
+		return node, false, fmt.Errorf("invalid created time: %w", err)
+	}
+	if node.Modified, err = time.Parse(time.RFC3339Nano, values[2]); err != nil {
+		return node, false, fmt.Errorf("invalid modified time: %w", err)
+	}
+	if err := json.Unmarshal([]byte(values[3]), &node.Tags); err != nil {
+		return node, false, fmt.Errorf("invalid tags: %w", err)
+	}
+	if len(node.Tags) == 0 {
+		node.Tags = nil
+	}
+	// An aliases line that is not JSON belongs to the content's own
+	// frontmatter.
+	if value, ok := strings.CutPrefix(text[pos:], aliasesKey+": "); ok {
+		var aliases []string
+		if end := strings.Index(value, "\n"); end >= 0 && json.Unmarshal([]byte(value[:end]), &aliases) == nil {
+			node.Aliases = aliases
+			pos += len(aliasesKey) + 2 + end + 1
+		}
+	}
+	if len(node.Aliases) == 0 {
+		node.Aliases = nil
+	}
+
+	// A closing fence straight after the metadata means the block was added
+	// in front of content that had no frontmatter.
+	rest := text[pos:]
+	if line, after, found := strings.Cut(rest, "\n"); found && strings.TrimRight(line, "\r") == "---" {
+		node.Content = after
+	} else {
+		node.Content = text[:start] + rest
+	}
+	return node, true, nil
+}
+
+// nameSuffix matches the " (id)" that tells apart files and directories
+// whose names would otherwise collide.
+var nameSuffix = regexp.MustCompile(` \((\d+)\)$`)
+
+// escapeName turns a title or project name into a portable file name.
+// Characters that are not allowed in file names on common systems, a
+// leading dot, trailing dots and spaces, and % itself are percent-encoded,
+// as is the parenthesis of an ending that parseName would take for a
+// disambiguation suffix; see suffixBase for id and siblings.
+func escapeName(name string, id int, siblings map[string]bool) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		last := i == len(name)-1
+		if c < 0x20 || c == 0x7f || strings.IndexByte(`/\:*?"<>|%`, c) >= 0 ||
+			(i == 0 && c == '.') || (last && (c == '.' || c == ' ')) {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	escaped := b.String()
+	if base, ok := suffixBase(escaped, id, siblings); ok {
+		escaped = base + " %28" + escaped[len(base)+2:]
+	}
+	return escaped
+}
+
+// suffixBase returns escaped without a " (n)" ending if the ending reads as
+// one uniqueName added: n is id, the ID of the record the name belongs to
+// or 0 if it has none yet, or siblings, the lower-cased names in the same
+// directory, hold the name without it. A name written by hand, such as
+// "Chapter (1)", is otherwise kept whole.
+func suffixBase(escaped string, id int, siblings map[string]bool) (string, bool) {
+	loc := nameSuffix.FindStringSubmatchIndex(escaped)
+	if loc == nil {
+		return escaped, false
+	}
+	n, _ := strconv.Atoi(escaped[loc[2]:loc[3]])
+	base := escaped[:loc[0]]
+	return base, (id != 0 && n == id) || siblings[strings.ToLower(base)]
+}
+
+// uniqueName returns escapeName(name, id, siblings), with a " (id)" suffix
+// if a name that differs only in case has already been taken, and records
+// it in taken.
+func uniqueName(name string, id int, taken, siblings map[string]bool) string {
+	escaped := escapeName(name, id, siblings)
+	if taken[strings.ToLower(escaped)] {
+		escaped = fmt.Sprintf("%s (%d)", escaped, id)
+	}
+	taken[strings.ToLower(escaped)] = true
+	return escaped
+}
+
+// parseName reverses uniqueName for the file or directory of the record
+// with ID id, or 0 if it has none yet, among siblings, the lower-cased
+// names next to it.
+func parseName(escaped string, id int, siblings map[string]bool) string {
+	if base, ok := suffixBase(escaped, id, siblings); ok {
+		escaped = base
+	}
+	name, err := url.PathUnescape(escaped)
+	if err != nil {
+		// Written by hand with a stray %; take it literally.
+		return escaped
+	}
+	return name
+}
@@ -0,0 +1,307 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestMarkdownStore opens and initialises a markdown store in dir.
+func newTestMarkdownStore(t *testing.T, dir string) *MarkdownStore {
+	t.Helper()
+	s, err := NewMarkdownStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestNodeFileRoundTrip(t *testing.T) {
+	created := time.Date(2024, 1, 5, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		content string
+		tags    []string
+		aliases []string
+	}{
+		{"plain", "plain text", nil, nil},
+		{"empty", "", nil, nil},
+		{"tags and aliases", "text", []string{"go", "db"}, []string{"Old title"}},
+		{"own frontmatter", "---\nstatus: done\n---\nbody", nil, nil},
+		{"empty frontmatter", "---\n---\nbody", nil, nil},
+		{"crlf frontmatter", "---\r\nx: 1\r\n---\r\nbody", nil, nil},
+		{"unterminated frontmatter", "---\nno end", nil, nil},
+		{"own aliases line", "---\naliases: not json\n---\nbody", nil, nil},
+		{"own aliases line with aliases", "---\naliases: [\"a\"]\n---\nbody", nil, []string{"b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := Node{ID: 12, Created: created, Modified: created.Add(time.Hour), Tags: tt.tags, Aliases: tt.aliases, Content: tt.content}
+			data, err := encodeNodeFile(node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok, err := decodeNodeFile(data)
+			if err != nil || !ok {
+				t.Fatalf("decodeNodeFile(%q) = %v, %v", data, ok, err)
+			}
+			if !reflect.DeepEqual(got, node) {
+				t.Errorf("round trip of %q:\n got %+v\nwant %+v", data, got, node)
+			}
+		})
+	}
+}
+
+func TestDecodeForeignNodeFile(t *testing.T) {
+	for _, text := range []string{"just text", "---\ntitle: mine\n---\nbody"} {
+		node, ok, err := decodeNodeFile([]byte(text))
+		if err != nil || ok || node.Content != text {
+			t.Errorf("decodeNodeFile(%q) = %+v, %v, %v", text, node, ok, err)
+		}
+	}
+}
+
+func TestNameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      int
+		escaped string
+	}{
+		{"Plain", 1, "Plain"},
+		{"Work/Stuff", 2, "Work%2FStuff"},
+		{".hidden?", 3, "%2Ehidden%3F"},
+		{"100%", 4, "100%25"},
+		{"Trailing. ", 5, "Trailing.%20"},
+		// Kept whole: the number is not the record's ID.
+		{"Chapter (1)", 6, "Chapter (1)"},
+		// Escaped, since it would read as the suffix for record 7.
+		{"Chapter (7)", 7, "Chapter %287)"},
+	}
+	for _, tt := range tests {
+		escaped := uniqueName(tt.name, tt.id, map[string]bool{}, nil)
+		if escaped != tt.escaped {
+			t.Errorf("uniqueName(%q) = %q, want %q", tt.name, escaped, tt.escaped)
+		}
+		if got := parseName(escaped, tt.id, nil); got != tt.name {
+			t.Errorf("parseName(%q) = %q, want %q", escaped, got, tt.name)
+		}
+	}
+}
+
+func TestNameSuffix(t *testing.T) {
+	taken := map[string]bool{}
+	first := uniqueName("Notes", 1, taken, nil)
+	second := uniqueName("notes", 2, taken, nil)
+	if first != "Notes" || second != "notes (2)" {
+		t.Fatalf("uniqueName = %q, %q", first, second)
+	}
+	siblings := map[string]bool{"notes": true, "notes (2)": true}
+	if got := parseName(second, 2, siblings); got != "notes" {
+		t.Errorf("parseName(%q) = %q", second, got)
+	}
+	// Without the record's ID, the sibling without the suffix gives it away.
+	if got := parseName(second, 0, siblings); got != "notes" {
+		t.Errorf("parseName(%q) without an ID = %q", second, got)
+	}
+	// A hand-written ending with no such sibling is part of the name.
+	if got := parseName("Chapter (1)", 0, map[string]bool{"chapter (1)": true}); got != "Chapter (1)" {
+		t.Errorf("parseName of a hand-written ending = %q", got)
+	}
+}
+
+func TestMarkdownStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestMarkdownStore(t, dir)
+	for _, name := range []string{"Work/Stuff", "work/stuff"} {
+		if err := s.AddProject(Project{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, node := range []Node{
+		{ProjectID: 1, Title: "Note", Content: "---\nstatus: done\n---\nbody", Tags: []string{"a"}},
+		{ProjectID: 1, Title: "X (3)", Content: "looks like a suffix"},
+		{ProjectID: 1, Title: ".hidden?", Content: "escaped"},
+		{ProjectID: 2, Title: "Note", Content: "same title, other project"},
+	} {
+		if err := s.AddNode(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, err := s.GetNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	projectsBefore, err := s.GetProjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = newTestMarkdownStore(t, dir)
+	after, err := s.GetNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	projectsAfter, err := s.GetProjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projectsAfter) != len(projectsBefore) {
+		t.Fatalf("projects = %+v, want %+v", projectsAfter, projectsBefore)
+	}
+	for i := range projectsBefore {
+		if projectsAfter[i].ID != projectsBefore[i].ID || projectsAfter[i].Name != projectsBefore[i].Name {
+			t.Errorf("project %d = %+v, want %+v", i, projectsAfter[i], projectsBefore[i])
+		}
+	}
+	if len(after) != len(before) {
+		t.Fatalf("nodes = %+v, want %+v", after, before)
+	}
+	for i := range before {
+		b, a := before[i], after[i]
+		if a.ID != b.ID || a.ProjectID != b.ProjectID || a.Title != b.Title || a.Content != b.Content || !reflect.DeepEqual(a.Tags, b.Tags) {
+			t.Errorf("node %d = %+v, want %+v", i, a, b)
+		}
+	}
+}
+
+func TestMarkdownStoreKeepsHandWrittenNames(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "Book"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Book", "Chapter (7).md"), []byte("by hand"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestMarkdownStore(t, dir)
+	nodes, err := s.GetNodes()
+	if err != nil || len(nodes) != 1 || nodes[0].Title != "Chapter (7)" {
+		t.Fatalf("GetNodes = %+v, %v", nodes, err)
+	}
+	// A flush must not rename the file.
+	if err := s.AddNode(Node{ProjectID: nodes[0].ProjectID, Title: "Other"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Book", "Chapter (7).md")); err != nil {
+		t.Error(err)
+	}
+	s = newTestMarkdownStore(t, dir)
+	if _, err := s.GetNodeByTitle("Chapter (7)", nodes[0].ProjectID); err != nil {
+		t.Error(err)
+	}
+}
+
+// editFile appends text to a file below dir, as another program would.
+func editFile(t *testing.T, dir, name, text string) {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(data, text...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMarkdownStoreKeepsOutsideEdits(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestMarkdownStore(t, dir)
+	if err := s.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	linking := mustNode(t, s, 1, "A", "see [[B]]")
+	target := mustNode(t, s, 1, "B", "b")
+	gone := mustNode(t, s, 1, "Gone", "gone")
+
+	editFile(t, dir, "P/A.md", "\nadded outside")
+	if _, err := s.RenameNode(target.ID, "C"); err != nil {
+		t.Fatal(err)
+	}
+	want := "see [[C]]\nadded outside"
+	if node, err := s.GetNode(linking.ID); err != nil || node.Content != want {
+		t.Errorf("linking node = %+v, %v", node, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "P", "A.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node, _, err := decodeNodeFile(data); err != nil || node.Content != want {
+		t.Errorf("linking file holds %q, %v", node.Content, err)
+	}
+
+	editFile(t, dir, "P/Gone.md", " and edited")
+	if err := s.DeleteNode(gone.ID); err != nil {
+		t.Fatal(err)
+	}
+	trash, err := s.GetTrash()
+	if err != nil || len(trash) != 1 || trash[0].Nodes[0].Content != "gone and edited" {
+		t.Errorf("trash = %+v, %v", trash, err)
+	}
+}
+
+func TestMarkdownStoreRefusesToOverwriteForeignEdits(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestMarkdownStore(t, dir)
+	if err := s.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	mustNode(t, s, 1, "A", "see [[B]]")
+	target := mustNode(t, s, 1, "B", "b")
+
+	// Without its metadata the file no longer says which node it holds.
+	path := filepath.Join(dir, "P", "A.md")
+	if err := os.WriteFile(path, []byte("rewritten by hand [[B]]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RenameNode(target.ID, "C"); err == nil {
+		t.Fatal("RenameNode wrote over a foreign edit")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "rewritten by hand [[B]]" {
+		t.Errorf("A.md = %q, %v", data, err)
+	}
+	if node, err := s.GetNode(target.ID); err != nil || node.Title != "B" {
+		t.Errorf("renamed node = %+v, %v", node, err)
+	}
+}
+
+func TestMarkdownStoreRollsBackFailedWrites(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestMarkdownStore(t, dir)
+	if err := s.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	// A file where the project's directory should go makes writing fail.
+	if err := os.WriteFile(filepath.Join(dir, "Q"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddProject(Project{Name: "Q"}); err == nil {
+		t.Fatal("AddProject succeeded")
+	}
+	if projects, err := s.GetProjects(); err != nil || len(projects) != 1 {
+		t.Errorf("projects after a failed write = %+v, %v", projects, err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "Q")); err != nil {
+		t.Fatal(err)
+	}
+	mustNode(t, s, 1, "Note", "")
+	if _, err := os.Stat(filepath.Join(dir, "Q")); err == nil {
+		t.Error("the failed change was written out by the next one")
+	}
+	if projects, err := s.GetProjects(); err != nil || len(projects) != 1 {
+		t.Errorf("projects = %+v, %v", projects, err)
+	}
+}
@@ -0,0 +1,918 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pixambi/gbrain/internal/markup"
+)
+
+// MemStore is a Store that keeps everything in memory. Records are kept
+// encoded exactly as Db stores them and every query scans them, so it
+// behaves the same as a freshly opened Db without touching the disk. It is
+// meant for tests and throwaway sessions.
+type MemStore struct {
+	mu   sync.RWMutex
+	opts Options
+
+	projects   map[int][]byte
+	projectSeq int
+	nodes      map[int][]byte
+	nodeSeq    int
+	trash      map[int][]byte
+	trashSeq   int
+
+	revisions   map[int]map[int][]byte // nodeID -> revisionID -> Revision
+	revisionSeq map[int]int
+
+	blobs       map[string][]byte          // hash -> contents
+	blobRefs    map[string]map[string]bool // hash -> attachmentRefKey
+	attachments map[int]map[string][]byte  // nodeID -> name -> Attachment
+
+	prefs map[string]string
+}
+
+// NewMemStore returns an empty store. A nil opts uses the zero Options.
+func NewMemStore(opts *Options) *MemStore {
+	m := &MemStore{
+		projects:    make(map[int][]byte),
+		nodes:       make(map[int][]byte),
+		trash:       make(map[int][]byte),
+		revisions:   make(map[int]map[int][]byte),
+		revisionSeq: make(map[int]int),
+		blobs:       make(map[string][]byte),
+		blobRefs:    make(map[string]map[string]bool),
+		attachments: make(map[int]map[string][]byte),
+		prefs:       make(map[string]string),
+	}
+	if opts != nil {
+		m.opts = *opts
+	}
+	return m
+}
+
+// memState is a copy of everything a MemStore holds.
+type memState struct {
+	projects, nodes, trash        map[int][]byte
+	projectSeq, nodeSeq, trashSeq int
+	revisions                     map[int]map[int][]byte
+	revisionSeq                   map[int]int
+	blobs                         map[string][]byte
+	blobRefs                      map[string]map[string]bool
+	attachments                   map[int]map[string][]byte
+	prefs                         map[string]string
+}
+
+// snapshot copies the store's state. Records are replaced rather than
+// changed in place, so only the maps need copying.
+func (m *MemStore) snapshot() memState {
+	return memState{
+		projects: maps.Clone(m.projects), nodes: maps.Clone(m.nodes), trash: maps.Clone(m.trash),
+		projectSeq: m.projectSeq, nodeSeq: m.nodeSeq, trashSeq: m.trashSeq,
+		revisions:   cloneNested(m.revisions),
+		revisionSeq: maps.Clone(m.revisionSeq),
+		blobs:       maps.Clone(m.blobs),
+		blobRefs:    cloneNested(m.blobRefs),
+		attachments: cloneNested(m.attachments),
+		prefs:       maps.Clone(m.prefs),
+	}
+}
+
+// rollback puts back the state saved by snapshot if *err is set. Every
+// change that takes more than one step defers it, so that a failure partway
+// through leaves the store as it was, as a Db transaction would.
+func (m *MemStore) rollback(saved memState, err *error) {
+	if *err != nil {
+		m.restore(saved)
+	}
+}
+
+// restore puts back the state saved by snapshot.
+func (m *MemStore) restore(saved memState) {
+	m.projects, m.nodes, m.trash = saved.projects, saved.nodes, saved.trash
+	m.projectSeq, m.nodeSeq, m.trashSeq = saved.projectSeq, saved.nodeSeq, saved.trashSeq
+	m.revisions, m.revisionSeq = saved.revisions, saved.revisionSeq
+	m.blobs, m.blobRefs, m.attachments = saved.blobs, saved.blobRefs, saved.attachments
+	m.prefs = saved.prefs
+}
+
+func cloneNested[K, K2 comparable, V any](m map[K]map[K2]V) map[K]map[K2]V {
+	c := make(map[K]map[K2]V, len(m))
+	for k, inner := range m {
+		c[k] = maps.Clone(inner)
+	}
+	return c
+}
+
+func (m *MemStore) Close() error {
+	return nil
+}
+
+// decodeAll decodes records in ID order.
+func decodeAll[T any](records map[int][]byte) ([]T, error) {
+	var values []T
+	for _, id := range slices.Sorted(maps.Keys(records)) {
+		var v T
+		if err := json.Unmarshal(records[id], &v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (m *MemStore) GetProjects() ([]Project, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return decodeAll[Project](m.projects)
+}
+
+func (m *MemStore) GetProject(id int) (Project, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.getProject(id)
+}
+
+func (m *MemStore) getProject(id int) (Project, error) {
+	var project Project
+	v, ok := m.projects[id]
+	if !ok {
+		return project, fmt.Errorf("project not found")
+	}
+	err := json.Unmarshal(v, &project)
+	return project, err
+}
+
+func (m *MemStore) AddProject(project Project) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if strings.TrimSpace(project.Name) == "" {
+		return fmt.Errorf("project name is required")
+	}
+	return m.putProject(&project, true)
+}
+
+func (m *MemStore) UpdateProject(project Project) error {
+	return m.AddProject(project)
+}
+
+func (m *MemStore) putProject(project *Project, touch bool) error {
+	if project.ID == 0 {
+		m.projectSeq++
+		project.ID = m.projectSeq
+	} else if _, ok := m.projects[project.ID]; !ok {
+		m.projectSeq = max(m.projectSeq, project.ID)
+	} else if project.Created.IsZero() {
+		old, err := m.getProject(project.ID)
+		if err != nil {
+			return err
+		}
+		project.Created = old.Created
+	}
+
+	now := time.Now()
+	if project.Created.IsZero() {
+		project.Created = now
+	}
+	if touch || project.Modified.IsZero() {
+		project.Modified = now
+	}
+
+	buf, err := json.Marshal(project)
+	if err != nil {
+		return err
+	}
+	m.projects[project.ID] = buf
+	return nil
+}
+
+func (m *MemStore) touchProject(id int, now time.Time) error {
+	if _, ok := m.projects[id]; !ok {
+		return nil
+	}
+	project, err := m.getProject(id)
+	if err != nil {
+		return err
+	}
+	project.Modified = now
+	return m.putProject(&project, false)
+}
+
+func (m *MemStore) DeleteProject(id int) (_ DeleteReport, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.rollback(m.snapshot(), &err)
+
+	report := DeleteReport{ProjectID: id}
+	project, err := m.getProject(id)
+	if err != nil {
+		return report, err
+	}
+	all, err := decodeAll[Node](m.nodes)
+	if err != nil {
+		return report, err
+	}
+	nodes := scanProjectNodes(all, id)
+	for _, node := range nodes {
+		delete(m.nodes, node.ID)
+		report.NodeIDs = append(report.NodeIDs, node.ID)
+	}
+	delete(m.projects, id)
+
+	report.TrashID, err = m.addTrash(TrashItem{Kind: TrashProject, Project: project, Nodes: nodes})
+	return report, err
+}
+
+func (m *MemStore) GetNodes() ([]Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return decodeAll[Node](m.nodes)
+}
+
+func (m *MemStore) GetNodesByProjectID(projectID int) ([]Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nodes, err := decodeAll[Node](m.nodes)
+	return scanProjectNodes(nodes, projectID), err
+}
+
+func (m *MemStore) GetNode(id int) (Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.getNode(id)
+}
+
+func (m *MemStore) getNode(id int) (Node, error) {
+	var node Node
+	v, ok := m.nodes[id]
+	if !ok {
+		return node, fmt.Errorf("node not found")
+	}
+	err := json.Unmarshal(v, &node)
+	return node, err
+}
+
+func (m *MemStore) GetNodeByTitle(title string, projectID int) (Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nodes, err := decodeAll[Node](m.nodes)
+	if err != nil {
+		return Node{}, err
+	}
+	return scanNodeByTitle(nodes, title, projectID)
+}
+
+func (m *MemStore) ResolveLink(title string, projectID int) (Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	projects, err := decodeAll[Project](m.projects)
+	if err != nil {
+		return Node{}, err
+	}
+	nodes, err := decodeAll[Node](m.nodes)
+	if err != nil {
+		return Node{}, err
+	}
+	if node, ok := newLinkResolver(projects, nodes).resolve(title, projectID); ok {
+		return node, nil
+	}
+	return Node{}, fmt.Errorf("node not found")
+}
+
+func (m *MemStore) AddNode(node Node) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.rollback(m.snapshot(), &err)
+	return m.putNode(&node, true)
+}
+
+func (m *MemStore) UpdateNode(node Node) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.rollback(m.snapshot(), &err)
+	var old *Node
+	if _, ok := m.nodes[node.ID]; ok {
+		stored, err := m.getNode(node.ID)
+		if err != nil {
+			return err
+		}
+		old = &stored
+	}
+	if err := checkVersion(node, old); err != nil {
+		return err
+	}
+	return m.putNode(&node, true)
+}
+
+func (m *MemStore) putNode(node *Node, touch bool) error {
+	if normalizeTitle(node.Title) == "" {
+		return fmt.Errorf("node title is required")
+	}
+	var old *Node
+	if _, ok := m.nodes[node.ID]; node.ID != 0 && ok {
+		stored, err := m.getNode(node.ID)
+		if err != nil {
+			return err
+		}
+		old = &stored
+	}
+	if titleChanged(old, *node) {
+		if existing, taken, err := m.titleTaken(node.Title, node.ProjectID, node.ID); err != nil {
+			return err
+		} else if taken {
+			return &DuplicateTitleError{Title: node.Title, Existing: existing}
+		}
+	}
+
+	if node.ID == 0 {
+		m.nodeSeq++
+		node.ID = m.nodeSeq
+	} else if old != nil {
+		if node.Created.IsZero() {
+			node.Created = old.Created
+		}
+		node.Version = old.Version
+	} else {
+		m.nodeSeq = max(m.nodeSeq, node.ID)
+	}
+	node.Version++
+
+	node.Properties = markup.ParseProperties(node.Content)
+
+	now := time.Now()
+	if node.Created.IsZero() {
+		node.Created = now
+	}
+	if touch || node.Modified.IsZero() {
+		node.Modified = now
+		if err := m.touchProject(node.ProjectID, now); err != nil {
+			return err
+		}
+	}
+
+	buf, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	m.nodes[node.ID] = buf
+	return m.appendRevision(*node)
+}
+
+func (m *MemStore) titleTaken(title string, projectID, id int) (Node, bool, error) {
+	nodes, err := decodeAll[Node](m.nodes)
+	if err != nil {
+		return Node{}, false, err
+	}
+	node, taken := titleOwner(scanProjectNodes(nodes, projectID), title, id)
+	return node, taken, nil
+}
+
+func (m *MemStore) freeTitle(title string, projectID, id int) (string, error) {
+	return numberedTitle(title, func(candidate string) (bool, error) {
+		_, taken, err := m.titleTaken(candidate, projectID, id)
+		return taken, err
+	})
+}
+
+func (m *MemStore) MergeNodes(intoID, fromID int) (_ Node, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.rollback(m.snapshot(), &err)
+
+	if intoID == fromID {
+		return Node{}, fmt.Errorf("cannot merge a node into itself")
+	}
+	into, err := m.getNode(intoID)
+	if err != nil {
+		return Node{}, err
+	}
+	from, err := m.getNode(fromID)
+	if err != nil {
+		return Node{}, err
+	}
+
+	if err := m.copyAttachments(fromID, intoID); err != nil {
+		return Node{}, err
+	}
+
+	if err := m.deleteNode(fromID); err != nil {
+		return Node{}, err
+	}
+	merged := mergedNode(into, from)
+	if err := m.putNode(&merged, true); err != nil {
+		return Node{}, err
+	}
+	return merged, nil
+}
+
+// copyAttachments is Tx.copyAttachments.
+func (m *MemStore) copyAttachments(from, to int) error {
+	for _, name := range slices.Sorted(maps.Keys(m.attachments[from])) {
+		if _, ok := m.attachments[to][name]; ok {
+			continue
+		}
+		_, data, err := m.getAttachmentData(from, name)
+		if err != nil {
+			return err
+		}
+		if _, err := m.addAttachment(to, name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemStore) PreviewMove(ids []int, projectID int, opts MoveOptions) (MovePlan, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	plan, _, err := m.planMove(ids, projectID, opts)
+	return plan, err
+}
+
+func (m *MemStore) MoveNodes(ids []int, projectID int, opts MoveOptions) (_ MovePlan, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.rollback(m.snapshot(), &err)
+	plan, steps, err := m.planMove(ids, projectID, opts)
+	if err != nil {
+		return plan, err
+	}
+	for _, step := range steps {
+		if err := m.putNode(&step.node, true); err != nil {
+			return plan, err
+		}
+		for _, from := range step.from {
+			if err := m.copyAttachments(from, step.node.ID); err != nil {
+				return plan, err
+			}
+			if step.merged && !opts.Copy {
+				if err := m.deleteNode(from); err != nil {
+					return plan, err
+				}
+			}
+		}
+	}
+	return plan, nil
+}
+
+func (m *MemStore) planMove(ids []int, projectID int, opts MoveOptions) (MovePlan, []moveStep, error) {
+	projects, err := decodeAll[Project](m.projects)
+	if err != nil {
+		return MovePlan{}, nil, err
+	}
+	nodes, err := decodeAll[Node](m.nodes)
+	if err != nil {
+		return MovePlan{}, nil, err
+	}
+	return planMove(projects, nodes, ids, projectID, opts)
+}
+
+func (m *MemStore) PreviewRename(id int, title string) (RenamePlan, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	plan, _, err := m.planRename(id, title)
+	return plan, err
+}
+
+func (m *MemStore) RenameNode(id int, title string) (_ RenamePlan, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.rollback(m.snapshot(), &err)
+	plan, nodes, err := m.planRename(id, title)
+	if err != nil {
+		return plan, err
+	}
+	for i := range nodes {
+		if err := m.putNode(&nodes[i], true); err != nil {
+			return plan, err
+		}
+	}
+	plan.Node = nodes[0]
+	return plan, nil
+}
+
+func (m *MemStore) planRename(id int, title string) (RenamePlan, []Node, error) {
+	node, err := m.getNode(id)
+	if err != nil {
+		return RenamePlan{}, nil, err
+	}
+	projects, err := decodeAll[Project](m.projects)
+	if err != nil {
+		return RenamePlan{}, nil, err
+	}
+	nodes, err := decodeAll[Node](m.nodes)
+	if err != nil {
+		return RenamePlan{}, nil, err
+	}
+	return planRename(projects, nodes, node, title)
+}
+
+func (m *MemStore) DeleteNode(id int) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.rollback(m.snapshot(), &err)
+	return m.deleteNode(id)
+}
+
+func (m *MemStore) deleteNode(id int) error {
+	node, err := m.getNode(id)
+	if err != nil {
+		return err
+	}
+	project, err := m.getProject(node.ProjectID)
+	if err != nil {
+		project = Project{ID: node.ProjectID}
+	}
+	delete(m.nodes, id)
+	_, err = m.addTrash(TrashItem{Kind: TrashNode, Project: project, Nodes: []Node{node}})
+	return err
+}
+
+func (m *MemStore) purgeNode(id int, report *DeleteReport) error {
+	report.NodeIDs = append(report.NodeIDs, id)
+	report.Revisions += len(m.revisions[id])
+	delete(m.revisions, id)
+	delete(m.revisionSeq, id)
+	report.Attachments += len(m.attachments[id])
+	for name := range m.attachments[id] {
+		if err := m.removeAttachment(id, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemStore) GetBacklinks(nodeID int) ([]Backlink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	node, err := m.getNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	project, err := m.getProject(node.ProjectID)
+	if err != nil {
+		project = Project{ID: node.ProjectID}
+	}
+	nodes, err := decodeAll[Node](m.nodes)
+	return scanBacklinks(nodes, node, project), err
+}
+
+func (m *MemStore) Search(query string, opts SearchOptions) ([]SearchResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nodes, err := decodeAll[Node](m.nodes)
+	if err != nil {
+		return nil, err
+	}
+	return scanSearch(nodes, query, opts)
+}
+
+func (m *MemStore) GetTags() ([]TagCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nodes, err := decodeAll[Node](m.nodes)
+	return scanTags(nodes), err
+}
+
+func (m *MemStore) GetNodesByTag(tag string) ([]Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nodes, err := decodeAll[Node](m.nodes)
+	return scanNodesByTag(nodes, tag), err
+}
+
+func (m *MemStore) GetNodesByProperty(key, value string) ([]Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nodes, err := decodeAll[Node](m.nodes)
+	return scanNodesByProperty(nodes, key, value), err
+}
+
+func (m *MemStore) GetPropertyKeys() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	nodes, err := decodeAll[Node](m.nodes)
+	return scanPropertyKeys(nodes), err
+}
+
+func (m *MemStore) appendRevision(node Node) error {
+	revisions := m.revisions[node.ID]
+	if revisions == nil {
+		revisions = make(map[int][]byte)
+		m.revisions[node.ID] = revisions
+	}
+
+	history, err := decodeAll[Revision](revisions)
+	if err != nil {
+		return err
+	}
+	if n := len(history); n > 0 && history[n-1].Title == node.Title && history[n-1].Content == node.Content {
+		return nil
+	}
+
+	m.revisionSeq[node.ID]++
+	rev := Revision{
+		ID:      m.revisionSeq[node.ID],
+		NodeID:  node.ID,
+		Time:    time.Now(),
+		Title:   node.Title,
+		Content: node.Content,
+	}
+	buf, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+	revisions[rev.ID] = buf
+
+	history = append(history, rev)
+	for _, expired := range history[:expiredRevisions(history, m.opts, time.Now())] {
+		delete(revisions, expired.ID)
+	}
+	return nil
+}
+
+func (m *MemStore) GetRevisions(nodeID int) ([]Revision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	revisions, err := decodeAll[Revision](m.revisions[nodeID])
+	slices.Reverse(revisions)
+	return revisions, err
+}
+
+func (m *MemStore) GetRevision(nodeID, revisionID int) (Revision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.getRevision(nodeID, revisionID)
+}
+
+func (m *MemStore) getRevision(nodeID, revisionID int) (Revision, error) {
+	var rev Revision
+	v, ok := m.revisions[nodeID][revisionID]
+	if !ok {
+		return rev, fmt.Errorf("revision not found")
+	}
+	err := json.Unmarshal(v, &rev)
+	return rev, err
+}
+
+func (m *MemStore) RestoreRevision(nodeID, revisionID int) (_ Node, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.rollback(m.snapshot(), &err)
+
+	rev, err := m.getRevision(nodeID, revisionID)
+	if err != nil {
+		return Node{}, err
+	}
+	node, err := m.getNode(nodeID)
+	if err != nil {
+		return Node{}, err
+	}
+	node.Title = rev.Title
+	node.Content = rev.Content
+	if err := m.putNode(&node, true); err != nil {
+		return Node{}, err
+	}
+	return node, nil
+}
+
+func (m *MemStore) addTrash(item TrashItem) (int, error) {
+	m.trashSeq++
+	item.ID = m.trashSeq
+	item.DeletedAt = time.Now()
+
+	buf, err := json.Marshal(item)
+	if err != nil {
+		return 0, err
+	}
+	m.trash[item.ID] = buf
+	return item.ID, nil
+}
+
+func (m *MemStore) GetTrash() ([]TrashItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	items, err := decodeAll[TrashItem](m.trash)
+	slices.Reverse(items)
+	return items, err
+}
+
+func (m *MemStore) getTrashItem(id int) (TrashItem, error) {
+	var item TrashItem
+	v, ok := m.trash[id]
+	if !ok {
+		return item, fmt.Errorf("trash item not found")
+	}
+	err := json.Unmarshal(v, &item)
+	return item, err
+}
+
+func (m *MemStore) RestoreTrash(id int) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.rollback(m.snapshot(), &err)
+
+	item, err := m.getTrashItem(id)
+	if err != nil {
+		return err
+	}
+
+	switch item.Kind {
+	case TrashProject:
+		if err := m.putProject(&item.Project, false); err != nil {
+			return err
+		}
+	case TrashNode:
+		if _, err := m.getProject(item.Project.ID); err != nil {
+			return fmt.Errorf("project '%s' no longer exists; restore it from the trash first", item.Project.Name)
+		}
+	}
+
+	for _, node := range item.Nodes {
+		if node.Title, err = m.freeTitle(node.Title, node.ProjectID, node.ID); err != nil {
+			return err
+		}
+		if err := m.putNode(&node, false); err != nil {
+			return err
+		}
+	}
+	delete(m.trash, id)
+	return nil
+}
+
+func (m *MemStore) PurgeTrash(id int) (_ DeleteReport, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.rollback(m.snapshot(), &err)
+	return m.purgeTrash(id)
+}
+
+func (m *MemStore) purgeTrash(id int) (DeleteReport, error) {
+	item, err := m.getTrashItem(id)
+	if err != nil {
+		return DeleteReport{}, err
+	}
+
+	report := DeleteReport{TrashID: id}
+	if item.Kind == TrashProject {
+		report.ProjectID = item.Project.ID
+	}
+	for _, node := range item.Nodes {
+		if err := m.purgeNode(node.ID, &report); err != nil {
+			return report, err
+		}
+	}
+	delete(m.trash, id)
+	return report, nil
+}
+
+func (m *MemStore) PurgeExpiredTrash(maxAge time.Duration) (_ DeleteReport, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.rollback(m.snapshot(), &err)
+
+	var report DeleteReport
+	items, err := decodeAll[TrashItem](m.trash)
+	if err != nil {
+		return report, err
+	}
+	slices.Reverse(items)
+	cutoff := time.Now().Add(-maxAge)
+	for _, item := range items {
+		if !item.DeletedAt.Before(cutoff) {
+			continue
+		}
+		purged, err := m.purgeTrash(item.ID)
+		if err != nil {
+			return report, err
+		}
+		report.NodeIDs = append(report.NodeIDs, purged.NodeIDs...)
+		report.Revisions += purged.Revisions
+		report.Attachments += purged.Attachments
+	}
+	return report, nil
+}
+
+func (m *MemStore) AddAttachment(nodeID int, name string, data []byte) (_ Attachment, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.rollback(m.snapshot(), &err)
+	return m.addAttachment(nodeID, name, data)
+}
+
+func (m *MemStore) addAttachment(nodeID int, name string, data []byte) (Attachment, error) {
+	name, err := attachmentName(name)
+	if err != nil {
+		return Attachment{}, err
+	}
+	if _, err := m.getNode(nodeID); err != nil {
+		return Attachment{}, err
+	}
+	if err := m.removeAttachment(nodeID, name); err != nil {
+		return Attachment{}, err
+	}
+
+	attachment := newAttachment(nodeID, name, data)
+	buf, err := json.Marshal(attachment)
+	if err != nil {
+		return Attachment{}, err
+	}
+	if _, ok := m.blobs[attachment.Hash]; !ok {
+		m.blobs[attachment.Hash] = append([]byte(nil), data...)
+		m.blobRefs[attachment.Hash] = make(map[string]bool)
+	}
+	m.blobRefs[attachment.Hash][string(attachmentRefKey(nodeID, name))] = true
+	if m.attachments[nodeID] == nil {
+		m.attachments[nodeID] = make(map[string][]byte)
+	}
+	m.attachments[nodeID][name] = buf
+	return attachment, nil
+}
+
+func (m *MemStore) GetAttachments(nodeID int) ([]Attachment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var attachments []Attachment
+	for _, name := range slices.Sorted(maps.Keys(m.attachments[nodeID])) {
+		var attachment Attachment
+		if err := json.Unmarshal(m.attachments[nodeID][name], &attachment); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+func (m *MemStore) GetAttachmentData(nodeID int, name string) (Attachment, []byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.getAttachmentData(nodeID, name)
+}
+
+func (m *MemStore) getAttachmentData(nodeID int, name string) (Attachment, []byte, error) {
+	var attachment Attachment
+	v, ok := m.attachments[nodeID][name]
+	if !ok {
+		return Attachment{}, nil, fmt.Errorf("attachment not found")
+	}
+	if err := json.Unmarshal(v, &attachment); err != nil {
+		return Attachment{}, nil, err
+	}
+	data, ok := m.blobs[attachment.Hash]
+	if !ok {
+		return Attachment{}, nil, fmt.Errorf("attachment %s is missing its contents", name)
+	}
+	return attachment, append([]byte(nil), data...), nil
+}
+
+func (m *MemStore) RemoveAttachment(nodeID int, name string) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.rollback(m.snapshot(), &err)
+	return m.removeAttachment(nodeID, name)
+}
+
+// removeAttachment deletes an attachment, and its contents if no other
+// attachment shares them.
+func (m *MemStore) removeAttachment(nodeID int, name string) error {
+	v, ok := m.attachments[nodeID][name]
+	if !ok {
+		return nil
+	}
+	var attachment Attachment
+	if err := json.Unmarshal(v, &attachment); err != nil {
+		return err
+	}
+	refs := m.blobRefs[attachment.Hash]
+	delete(refs, string(attachmentRefKey(nodeID, name)))
+	if len(refs) == 0 {
+		delete(m.blobRefs, attachment.Hash)
+		delete(m.blobs, attachment.Hash)
+	}
+	delete(m.attachments[nodeID], name)
+	if len(m.attachments[nodeID]) == 0 {
+		delete(m.attachments, nodeID)
+	}
+	return nil
+}
+
+func (m *MemStore) GetPreference(key string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.prefs[key], nil
+}
+
+func (m *MemStore) SetPreference(key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prefs[key] = value
+	return nil
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// brokenMemStore returns a store whose changes fail partway through: the
+// second attachment of "From" has lost its contents, and a node without a
+// title links to "Target".
+func brokenMemStore(t *testing.T) *MemStore {
+	t.Helper()
+	m := NewMemStore(nil)
+	for _, name := range []string{"P", "Q"} {
+		if err := m.AddProject(Project{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	into := mustNode(t, m, 1, "Into", "into")
+	from := mustNode(t, m, 1, "From", "from")
+	mustNode(t, m, 1, "Target", "target")
+	if _, err := m.AddAttachment(into.ID, "into.txt", []byte("into")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, err := m.AddAttachment(from.ID, name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	attachments, err := m.GetAttachments(from.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(m.blobs, attachments[1].Hash)
+
+	m.nodeSeq++
+	v, err := json.Marshal(Node{ID: m.nodeSeq, ProjectID: 1, Content: "see [[Target]]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.nodes[m.nodeSeq] = v
+	return m
+}
+
+func TestMemStoreRollback(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(m *MemStore) error
+	}{
+		{"merge", func(m *MemStore) error {
+			_, err := m.MergeNodes(1, 2)
+			return err
+		}},
+		{"copy", func(m *MemStore) error {
+			_, err := m.MoveNodes([]int{2}, 2, MoveOptions{Copy: true})
+			return err
+		}},
+		{"rename", func(m *MemStore) error {
+			_, err := m.RenameNode(3, "Renamed")
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := brokenMemStore(t)
+			before := m.snapshot()
+			if err := tt.change(m); err == nil {
+				t.Fatal("change succeeded")
+			}
+			if after := m.snapshot(); !reflect.DeepEqual(before, after) {
+				t.Errorf("state changed:\nbefore %+v\nafter  %+v", before, after)
+			}
+		})
+	}
+}
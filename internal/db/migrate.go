@@ -0,0 +1,224 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// migration upgrades the data layout from version-1 to version. Each
+// migration runs in its own write transaction together with the version bump,
+// so a failure leaves the file at the last version that completed.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *Tx) error
+}
+
+// migrations lists every layout change in the order it must be applied.
+// Append new entries with the next version number; never reorder or edit
+// entries that have shipped. Migrations that introduce or change a derived
+// index simply rebuild every index, which keeps them trivially correct.
+var migrations = []migration{
+	{1, "fixed-width record keys", migrateFixedWidthKeys},
+	{2, "per-project node index", (*Tx).RebuildIndexes},
+	{3, "backlinks index", (*Tx).RebuildIndexes},
+	{4, "full-text search index", (*Tx).RebuildIndexes},
+	{5, "node revision history", migrateSeedRevisions},
+	{6, "created and modified timestamps", migrateTimestamps},
+	{7, "tag index", (*Tx).RebuildIndexes},
+	{8, "node properties", migrateProperties},
+	// Nothing changes in a plain database; the bump only stops older
+	// releases from opening encrypted ones and misreading sealed values.
+	{9, "optional encryption", func(*Tx) error { return nil }},
+	{10, "link headings and display text", (*Tx).RebuildIndexes},
+	{11, "sealed values bound to their records", migrateBindSealed},
+	{12, "qualified backlinks index", (*Tx).RebuildIndexes},
+}
+
+// SchemaVersion is the newest data layout this binary understands.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaError is returned when a database was written by a newer version of
+// gbrain than the one opening it.
+type SchemaError struct {
+	Found     int
+	Supported int
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than supported version %d; upgrade gbrain to open it", e.Found, e.Supported)
+}
+
+var schemaVersionKey = []byte("schema_version")
+
+func readSchemaVersion(tx *bbolt.Tx) int {
+	b := tx.Bucket([]byte("meta"))
+	if b == nil {
+		return 0
+	}
+	v := b.Get(schemaVersionKey)
+	if len(v) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(v))
+}
+
+func writeSchemaVersion(tx *bbolt.Tx, version int) error {
+	b, err := tx.CreateBucketIfNotExists([]byte("meta"))
+	if err != nil {
+		return err
+	}
+	return b.Put(schemaVersionKey, itob(version))
+}
+
+// migrate brings the database up to SchemaVersion, taking a backup first if
+// there is existing data that a migration would touch.
+func (d *Db) migrate() error {
+	var current int
+	var hasData bool
+	err := d.db.View(func(tx *bbolt.Tx) error {
+		current = readSchemaVersion(tx)
+		for _, name := range []string{"nodes", "projects"} {
+			if b := tx.Bucket([]byte(name)); b != nil && b.Stats().KeyN > 0 {
+				hasData = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	latest := SchemaVersion()
+	if current > latest {
+		return &SchemaError{Found: current, Supported: latest}
+	}
+	if current == latest {
+		return nil
+	}
+
+	// A read-only copy is migrated in place; the original is untouched.
+	if hasData && !d.readOnly {
+		if _, err := d.backup(fmt.Sprintf("pre-v%d", latest)); err != nil {
+			return fmt.Errorf("backing up before migration: %w", err)
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		err := d.Update(func(tx *Tx) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return writeSchemaVersion(tx.tx, m.version)
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// backup writes a consistent copy of the database next to the original and
+// returns its path.
+func (d *Db) backup(label string) (string, error) {
+	path := fmt.Sprintf("%s.%s-%s.bak", d.db.Path(), label, time.Now().Format(backupTimeFormat))
+	return path, d.Snapshot(path)
+}
+
+// migrateFixedWidthKeys rewrites the decimal-string keys used by early
+// releases, which do not sort numerically, as fixed-width keys.
+func migrateFixedWidthKeys(tx *Tx) error {
+	for _, name := range []string{"nodes", "projects"} {
+		if err := migrateKeys(tx.tx.Bucket([]byte(name))); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// migrateKeys rewrites legacy decimal-string keys in b as fixed-width keys
+// and advances the bucket sequence past the highest ID in use.
+func migrateKeys(b *bbolt.Bucket) error {
+	type legacy struct {
+		key   []byte
+		id    int
+		value []byte
+	}
+
+	var old []legacy
+	maxID := 0
+
+	err := b.ForEach(func(k, v []byte) error {
+		// A decimal key of eight digits is as long as a fixed-width one, so
+		// the digits decide which it is. A fixed-width key made only of
+		// digits would need an ID above 3.4e18.
+		if !isDecimalKey(k) {
+			if len(k) != 8 {
+				return fmt.Errorf("unrecognised key %q", k)
+			}
+			if id := btoi(k); id > maxID {
+				maxID = id
+			}
+			return nil
+		}
+
+		id, err := strconv.Atoi(string(k))
+		if err != nil {
+			return fmt.Errorf("unrecognised key %q", k)
+		}
+		// Keys and values returned by ForEach are only valid for the life of
+		// the transaction and must not be held across modifications.
+		old = append(old, legacy{
+			key:   append([]byte(nil), k...),
+			id:    id,
+			value: append([]byte(nil), v...),
+		})
+		if id > maxID {
+			maxID = id
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range old {
+		if existing := b.Get(itob(rec.id)); existing != nil {
+			return fmt.Errorf("key %q collides with existing id %d", rec.key, rec.id)
+		}
+		if err := b.Put(itob(rec.id), rec.value); err != nil {
+			return err
+		}
+		if err := b.Delete(rec.key); err != nil {
+			return err
+		}
+	}
+
+	if uint64(maxID) > b.Sequence() {
+		return b.SetSequence(uint64(maxID))
+	}
+	return nil
+}
+
+// isDecimalKey reports whether k is a decimal-string key of an early
+// release.
+func isDecimalKey(k []byte) bool {
+	if len(k) == 0 {
+		return false
+	}
+	for _, c := range k {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,358 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestMigrateKeys(t *testing.T) {
+	bdb, err := bbolt.Open(filepath.Join(t.TempDir(), "keys.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bdb.Close()
+
+	err = bdb.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("nodes"))
+		if err != nil {
+			return err
+		}
+		for k, v := range map[string]string{
+			"7":             "seven",
+			"12345678":      "eight digits",
+			string(itob(3)): "fixed width",
+		} {
+			if err := b.Put([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+		return migrateKeys(b)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = bdb.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("nodes"))
+		want := map[int]string{3: "fixed width", 7: "seven", 12345678: "eight digits"}
+		got := map[int]string{}
+		b.ForEach(func(k, v []byte) error {
+			if len(k) != 8 {
+				t.Errorf("key %q was not rewritten", k)
+				return nil
+			}
+			got[btoi(k)] = string(v)
+			return nil
+		})
+		if len(got) != len(want) {
+			t.Errorf("keys = %v, want %v", got, want)
+		}
+		for id, v := range want {
+			if got[id] != v {
+				t.Errorf("key %d = %q, want %q", id, got[id], v)
+			}
+		}
+		if seq := b.Sequence(); seq != 12345678 {
+			t.Errorf("sequence = %d, want 12345678", seq)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateKeysRejectsUnknownKeys(t *testing.T) {
+	bdb, err := bbolt.Open(filepath.Join(t.TempDir(), "keys.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bdb.Close()
+
+	err = bdb.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("nodes"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("note-1"), []byte("x")); err != nil {
+			return err
+		}
+		return migrateKeys(b)
+	})
+	if err == nil {
+		t.Fatal("migrateKeys accepted a key that is neither decimal nor fixed-width")
+	}
+}
+
+// writeLegacyDb writes a database at path as the first releases did: JSON
+// records under decimal keys, with no indexes and, unless version is
+// above 0, no schema version.
+func writeLegacyDb(t *testing.T, path string, version int, records map[string]map[int]any) {
+	t.Helper()
+	bdb, err := bbolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bdb.Close()
+	err = bdb.Update(func(tx *bbolt.Tx) error {
+		for _, name := range []string{"nodes", "projects"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for id, record := range records[name] {
+				buf, err := json.Marshal(record)
+				if err != nil {
+					return err
+				}
+				if err := b.Put([]byte(strconv.Itoa(id)), buf); err != nil {
+					return err
+				}
+			}
+		}
+		if version > 0 {
+			return writeSchemaVersion(tx, version)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var legacyRecords = map[string]map[int]any{
+	"projects": {1: map[string]any{"ID": 1, "Name": "P"}},
+	"nodes": {
+		1: map[string]any{"ID": 1, "Title": "A", "Content": "see [[B]] #go\nstatus:: done", "ProjectID": 1},
+		2: map[string]any{"ID": 2, "Title": "B", "Content": "bolt", "ProjectID": 1},
+	},
+}
+
+// schemaVersion reads the schema version of d.
+func schemaVersion(t *testing.T, d *Db) int {
+	t.Helper()
+	var version int
+	if err := d.db.View(func(tx *bbolt.Tx) error {
+		version = readSchemaVersion(tx)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+// backups lists the .bak files next to path.
+func backups(t *testing.T, path string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(path + "*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	writeLegacyDb(t, path, 0, legacyRecords)
+	d := openTestDb(t, path, nil)
+
+	if got := schemaVersion(t, d); got != SchemaVersion() {
+		t.Errorf("schema version = %d, want %d", got, SchemaVersion())
+	}
+	if got := backups(t, path); len(got) != 1 || !strings.Contains(got[0], fmt.Sprintf(".pre-v%d-", SchemaVersion())) {
+		t.Errorf("backups = %v", got)
+	}
+
+	node, err := d.GetNode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Created.IsZero() || node.Modified.IsZero() || node.Version == 0 {
+		t.Errorf("migrated node = %+v", node)
+	}
+	if node.Properties["status"].Text != "done" {
+		t.Errorf("properties = %+v", node.Properties)
+	}
+	if ids := backlinkIDs(t, d, 2); !slices.Equal(ids, []int{1}) {
+		t.Errorf("backlinks = %v", ids)
+	}
+	if titles := searchTitles(t, d, "bolt", SearchOptions{}); !slices.Equal(titles, []string{"B"}) {
+		t.Errorf("search = %q", titles)
+	}
+	if tagged, err := d.GetNodesByTag("go"); err != nil || len(tagged) != 1 || tagged[0].ID != 1 {
+		t.Errorf("GetNodesByTag = %+v, %v", tagged, err)
+	}
+	if revisions, err := d.GetRevisions(2); err != nil || len(revisions) == 0 {
+		t.Errorf("GetRevisions = %+v, %v", revisions, err)
+	}
+
+	// New IDs follow the migrated ones.
+	added := mustNode(t, d, 1, "C", "")
+	if added.ID != 3 {
+		t.Errorf("new node got ID %d", added.ID)
+	}
+
+	// Opening it again runs nothing and takes no further backup.
+	d = reopen(t, d)
+	if got := backups(t, path); len(got) != 1 {
+		t.Errorf("backups after reopening = %v", got)
+	}
+}
+
+// withMigrations swaps in migrations for the rest of the test.
+func withMigrations(t *testing.T, list []migration) {
+	t.Helper()
+	saved := migrations
+	migrations = list
+	t.Cleanup(func() { migrations = saved })
+}
+
+func TestMigrateRunsInOrder(t *testing.T) {
+	var ran []int
+	step := func(version int) migration {
+		return migration{version, fmt.Sprint("step ", version), func(*Tx) error {
+			ran = append(ran, version)
+			return nil
+		}}
+	}
+	withMigrations(t, []migration{step(1), step(2), step(3), step(4)})
+
+	path := filepath.Join(t.TempDir(), "partial.db")
+	writeLegacyDb(t, path, 2, nil)
+	d := openTestDb(t, path, nil)
+	if !slices.Equal(ran, []int{3, 4}) {
+		t.Errorf("ran %v, want [3 4]", ran)
+	}
+	if got := schemaVersion(t, d); got != 4 {
+		t.Errorf("schema version = %d, want 4", got)
+	}
+}
+
+func TestMigrateFailurePartway(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failing.db")
+	writeLegacyDb(t, path, 0, nil)
+
+	var ran []int
+	fail := true
+	withMigrations(t, []migration{
+		{1, "first", func(*Tx) error { ran = append(ran, 1); return nil }},
+		{2, "second", func(tx *Tx) error {
+			ran = append(ran, 2)
+			b, err := tx.bucket("meta")
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte("half"), []byte("applied")); err != nil {
+				return err
+			}
+			if fail {
+				return errors.New("broken")
+			}
+			return nil
+		}},
+		{3, "third", func(*Tx) error { ran = append(ran, 3); return nil }},
+	})
+
+	d, err := NewDb(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.Init(); err == nil || !strings.Contains(err.Error(), "migration 2 (second)") {
+		t.Fatalf("Init = %v", err)
+	}
+	if !slices.Equal(ran, []int{1, 2}) {
+		t.Errorf("ran %v, want [1 2]", ran)
+	}
+	if got := schemaVersion(t, d); got != 1 {
+		t.Errorf("schema version after the failure = %d, want 1", got)
+	}
+	err = d.db.View(func(tx *bbolt.Tx) error {
+		if v := tx.Bucket([]byte("meta")).Get([]byte("half")); v != nil {
+			t.Error("the failed migration left its changes behind")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Once it is fixed the migrations carry on from where they stopped.
+	fail, ran = false, nil
+	d = reopen(t, d)
+	if !slices.Equal(ran, []int{2, 3}) {
+		t.Errorf("ran %v after fixing, want [2 3]", ran)
+	}
+	if got := schemaVersion(t, d); got != 3 {
+		t.Errorf("schema version = %d, want 3", got)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "newer.db")
+	writeLegacyDb(t, path, SchemaVersion()+1, legacyRecords)
+	d, err := NewDb(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	var schemaErr *SchemaError
+	if err := d.Init(); !errors.As(err, &schemaErr) || schemaErr.Found != SchemaVersion()+1 || schemaErr.Supported != SchemaVersion() {
+		t.Fatalf("Init = %v, want a *SchemaError", err)
+	}
+	if got := schemaVersion(t, d); got != SchemaVersion()+1 {
+		t.Errorf("schema version = %d", got)
+	}
+	if got := backups(t, path); len(got) != 0 {
+		t.Errorf("backups = %v", got)
+	}
+}
+
+func TestMigrateBackupSkipped(t *testing.T) {
+	// An empty database has nothing to lose.
+	path := filepath.Join(t.TempDir(), "empty.db")
+	writeLegacyDb(t, path, 0, nil)
+	d := openTestDb(t, path, nil)
+	if got := backups(t, path); len(got) != 0 {
+		t.Errorf("backups of an empty database = %v", got)
+	}
+	if got := schemaVersion(t, d); got != SchemaVersion() {
+		t.Errorf("schema version = %d", got)
+	}
+
+	// A read-only copy is migrated on its own, leaving the file as it was.
+	dir := t.TempDir()
+	path = filepath.Join(dir, "old.db")
+	writeLegacyDb(t, path, 0, legacyRecords)
+	copied, err := NewDbCopy(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	if err := copied.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if titles := nodeTitles(t, copied); !slices.Equal(titles, []string{"A", "B"}) {
+		t.Errorf("nodes in the copy = %q", titles)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.bak")); len(matches) != 0 {
+		t.Errorf("backups of a read-only copy = %v", matches)
+	}
+	original, err := NewDbReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer original.Close()
+	if got := schemaVersion(t, original); got != 0 {
+		t.Errorf("schema version of the original = %d, want 0", got)
+	}
+}
@@ -0,0 +1,338 @@
+package db
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/pixambi/gbrain/internal/markup"
+)
+
+// ConflictPolicy is what MoveNodes does with a node whose title the
+// destination project already has.
+type ConflictPolicy int
+
+const (
+	// ConflictNumber keeps both, adding a number to the moved node's title.
+	ConflictNumber ConflictPolicy = iota
+	// ConflictSkip leaves the node where it is.
+	ConflictSkip
+	// ConflictMerge merges the node into the one already there, as
+	// MergeNodes does.
+	ConflictMerge
+)
+
+func (p ConflictPolicy) String() string {
+	switch p {
+	case ConflictNumber:
+		return "add a number"
+	case ConflictSkip:
+		return "skip"
+	case ConflictMerge:
+		return "merge"
+	}
+	return fmt.Sprintf("policy %d", int(p))
+}
+
+// MoveOptions controls MoveNodes.
+type MoveOptions struct {
+	// Copy leaves the nodes where they are and adds copies of them to the
+	// destination instead.
+	Copy bool
+	// Linked takes along the nodes in the same project that the chosen
+	// ones link to.
+	Linked   bool
+	Conflict ConflictPolicy
+}
+
+// MovePlan describes moving or copying nodes from one project to another.
+type MovePlan struct {
+	From, To int // Project IDs
+	Nodes    []MovedNode
+	// Changes lists the nodes staying where they are whose links are
+	// rewritten to keep arriving.
+	Changes []LinkChange
+}
+
+// MovedNode is a node that MoveNodes moves, copies, merges or skips.
+type MovedNode struct {
+	NodeID int
+	Title  string // Title in the destination, numbered if it was taken
+	Linked bool   // Taken along because a chosen node links to it
+	// Conflict is the destination node that already has the title, or 0.
+	Conflict int
+	Skipped  bool
+	Links    int // Links in the node that are rewritten
+}
+
+// moveStep is a node that MoveNodes stores: a node moved or with its links
+// rewritten, a copy, whose ID is 0, or a destination node with others
+// merged into it. from lists the nodes whose attachments it gets; on a move
+// the nodes merged into it go to the trash.
+type moveStep struct {
+	node   Node
+	from   []int
+	merged bool
+}
+
+// planMove works out moving the nodes ids, which must all be in one
+// project, to the project projectID. It is given every project and node in
+// the store.
+//
+// A link keeps arriving where it did before: links that would break or
+// reach another node are rewritten, to the title alone within a project
+// and to [[Project/Title]] across projects. Links in copies reach the
+// other copies.
+func planMove(projects []Project, nodes []Node, ids []int, projectID int, opts MoveOptions) (MovePlan, []moveStep, error) {
+	if len(ids) == 0 {
+		return MovePlan{}, nil, fmt.Errorf("no nodes to move")
+	}
+	if !slices.ContainsFunc(projects, func(p Project) bool { return p.ID == projectID }) {
+		return MovePlan{}, nil, fmt.Errorf("project not found")
+	}
+	byID := make(map[int]Node, len(nodes))
+	for _, node := range nodes {
+		byID[node.ID] = node
+	}
+	for _, id := range ids {
+		node, ok := byID[id]
+		if !ok {
+			return MovePlan{}, nil, fmt.Errorf("node not found")
+		}
+		if node.ProjectID != byID[ids[0]].ProjectID {
+			return MovePlan{}, nil, fmt.Errorf("nodes can only be moved from one project at a time")
+		}
+	}
+	plan := MovePlan{From: byID[ids[0]].ProjectID, To: projectID}
+	if plan.From == plan.To {
+		return MovePlan{}, nil, fmt.Errorf("the nodes are already in that project")
+	}
+	before := newLinkResolver(projects, nodes)
+
+	chosen := make(map[int]bool)
+	add := func(id int, linked bool) {
+		if !chosen[id] {
+			chosen[id] = true
+			plan.Nodes = append(plan.Nodes, MovedNode{NodeID: id, Title: byID[id].Title, Linked: linked})
+		}
+	}
+	for _, id := range ids {
+		add(id, false)
+	}
+	if opts.Linked {
+		for _, id := range ids {
+			for _, link := range markup.ParseLinks(byID[id].Content) {
+				target, ok := before.resolve(link.Title, plan.From)
+				if !link.Embed && ok && target.ProjectID == plan.From {
+					add(target.ID, true)
+				}
+			}
+		}
+	}
+
+	// Settle each node's title in the destination.
+	destination := scanProjectNodes(nodes, projectID)
+	claimed := make(map[string]bool)
+	taken := func(title string) (bool, error) {
+		_, taken := titleOwner(destination, title, 0)
+		return taken || claimed[normalizeTitle(title)], nil
+	}
+	for i := range plan.Nodes {
+		moved := &plan.Nodes[i]
+		owner, conflict := titleOwner(destination, moved.Title, 0)
+		if conflict {
+			moved.Conflict = owner.ID
+		}
+		switch {
+		case conflict && opts.Conflict == ConflictSkip:
+			moved.Skipped = true
+			continue
+		case conflict && opts.Conflict == ConflictMerge:
+			moved.Title = owner.Title
+			continue
+		}
+		moved.Title, _ = numberedTitle(moved.Title, taken)
+		claimed[normalizeTitle(moved.Title)] = true
+	}
+
+	// The store as it will be, with copies standing in under negative IDs
+	// until they have IDs of their own.
+	afterByID := make(map[int]Node, len(nodes))
+	for _, node := range nodes {
+		afterByID[node.ID] = node
+	}
+	moves := make(map[int]MovedNode)
+	for _, moved := range plan.Nodes {
+		if moved.Skipped {
+			continue
+		}
+		moves[moved.NodeID] = moved
+		node := byID[moved.NodeID]
+		switch {
+		case merges(moved, opts):
+			owner := afterByID[moved.Conflict]
+			owner.Aliases = mergedNode(owner, node).Aliases
+			afterByID[owner.ID] = owner
+			if !opts.Copy {
+				delete(afterByID, node.ID)
+			}
+		case opts.Copy:
+			node.ID = -node.ID
+			fallthrough
+		default:
+			node.ProjectID, node.Title = projectID, moved.Title
+			afterByID[node.ID] = node
+		}
+	}
+	after := newLinkResolver(projects, slices.SortedFunc(maps.Values(afterByID), func(a, b Node) int {
+		// Copies go last, after every node that already exists.
+		if (a.ID < 0) != (b.ID < 0) {
+			return b.ID - a.ID
+		}
+		return a.ID - b.ID
+	}))
+
+	// arrival returns the node that a link to x should reach afterwards,
+	// from a copy or from a node that stays what it is.
+	arrival := func(x Node, inCopy bool) Node {
+		moved, ok := moves[x.ID]
+		switch {
+		case !ok:
+		case merges(moved, opts) && (inCopy || !opts.Copy):
+			return afterByID[moved.Conflict]
+		case opts.Copy && inCopy:
+			return afterByID[-x.ID]
+		}
+		return afterByID[x.ID]
+	}
+	rewrite := func(content string, from, to int, inCopy bool) (string, int) {
+		return markup.RewriteLinks(content, func(title string) (string, bool) {
+			x, ok := before.resolve(title, from)
+			if !ok {
+				// Links to nothing yet are left for whatever takes the title.
+				return "", false
+			}
+			want := arrival(x, inCopy)
+			if got, ok := after.resolve(title, to); ok && got.ID == want.ID {
+				return "", false
+			}
+			return after.linkText(want, to), true
+		})
+	}
+
+	var steps []moveStep
+	mergedInto := make(map[int][]Node)
+	for i, moved := range plan.Nodes {
+		if moved.Skipped {
+			continue
+		}
+		node := byID[moved.NodeID]
+		node.Content, plan.Nodes[i].Links = rewrite(node.Content, plan.From, projectID, opts.Copy)
+		switch {
+		case merges(moved, opts):
+			mergedInto[moved.Conflict] = append(mergedInto[moved.Conflict], node)
+		case opts.Copy:
+			copied := Node{Title: moved.Title, Content: node.Content, ProjectID: projectID,
+				Tags: node.Tags, Aliases: node.Aliases}
+			steps = append(steps, moveStep{node: copied, from: []int{node.ID}})
+		default:
+			node.ProjectID, node.Title = projectID, moved.Title
+			steps = append(steps, moveStep{node: node})
+		}
+	}
+
+	for _, node := range nodes {
+		if _, ok := moves[node.ID]; ok && !opts.Copy {
+			// Placed above, or merged and going to the trash.
+			continue
+		}
+		content, links := rewrite(node.Content, node.ProjectID, node.ProjectID, false)
+		if links > 0 {
+			plan.Changes = append(plan.Changes, LinkChange{NodeID: node.ID, Title: node.Title, Links: links})
+		}
+		froms := mergedInto[node.ID]
+		if links == 0 && len(froms) == 0 {
+			continue
+		}
+		node.Content = content
+		step := moveStep{node: node, merged: len(froms) > 0}
+		for _, from := range froms {
+			step.node = mergedNode(step.node, from)
+			step.from = append(step.from, from.ID)
+		}
+		steps = append(steps, step)
+	}
+	return plan, steps, nil
+}
+
+// merges reports whether moved goes into the destination node that has its
+// title.
+func merges(moved MovedNode, opts MoveOptions) bool {
+	return moved.Conflict != 0 && !moved.Skipped && opts.Conflict == ConflictMerge
+}
+
+// PreviewMove returns what MoveNodes would do, without doing it.
+func (d *Db) PreviewMove(ids []int, projectID int, opts MoveOptions) (MovePlan, error) {
+	var plan MovePlan
+	err := d.View(func(tx *Tx) error {
+		var err error
+		plan, err = tx.PreviewMove(ids, projectID, opts)
+		return err
+	})
+	return plan, err
+}
+
+// MoveNodes moves the nodes ids, which must be in one project, into the
+// project projectID, or copies them there if opts.Copy is set, and rewrites
+// links so that they still arrive, all in one transaction. A moved node
+// keeps its ID, history and attachments; a copy starts a history of its
+// own.
+func (d *Db) MoveNodes(ids []int, projectID int, opts MoveOptions) (MovePlan, error) {
+	var plan MovePlan
+	err := d.Update(func(tx *Tx) error {
+		var err error
+		plan, err = tx.MoveNodes(ids, projectID, opts)
+		return err
+	})
+	return plan, err
+}
+
+func (t *Tx) PreviewMove(ids []int, projectID int, opts MoveOptions) (MovePlan, error) {
+	plan, _, err := t.planMove(ids, projectID, opts)
+	return plan, err
+}
+
+func (t *Tx) MoveNodes(ids []int, projectID int, opts MoveOptions) (MovePlan, error) {
+	plan, steps, err := t.planMove(ids, projectID, opts)
+	if err != nil {
+		return plan, err
+	}
+	for _, step := range steps {
+		if err := t.putNode(&step.node, true); err != nil {
+			return plan, err
+		}
+		for _, from := range step.from {
+			if err := t.copyAttachments(from, step.node.ID); err != nil {
+				return plan, err
+			}
+			if step.merged && !opts.Copy {
+				if err := t.DeleteNode(from); err != nil {
+					return plan, err
+				}
+			}
+		}
+	}
+	return plan, nil
+}
+
+func (t *Tx) planMove(ids []int, projectID int, opts MoveOptions) (MovePlan, []moveStep, error) {
+	projects, err := t.GetProjects()
+	if err != nil {
+		return MovePlan{}, nil, err
+	}
+	nodes, err := t.GetNodes()
+	if err != nil {
+		return MovePlan{}, nil, err
+	}
+	return planMove(projects, nodes, ids, projectID, opts)
+}
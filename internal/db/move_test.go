@@ -0,0 +1,166 @@
+package db
+
+import (
+	"testing"
+)
+
+// moveFixture is two projects, Src and Dst, where Dst already has a C.
+func moveFixture() ([]Project, []Node) {
+	projects := []Project{{ID: 1, Name: "Src"}, {ID: 2, Name: "Dst"}}
+	nodes := []Node{
+		{ID: 1, ProjectID: 1, Title: "A", Content: "links [[B]] and [[C]]"},
+		{ID: 2, ProjectID: 1, Title: "B", Content: "back to [[A]]"},
+		{ID: 3, ProjectID: 1, Title: "C", Content: "c"},
+		{ID: 4, ProjectID: 2, Title: "O", Content: "see [[Src/A]]"},
+		{ID: 5, ProjectID: 2, Title: "C", Content: "another c"},
+	}
+	return projects, nodes
+}
+
+// stepContents returns the content of each node planMove would store, by
+// ID, with copies under the negated ID of their original.
+func stepContents(steps []moveStep) map[int]string {
+	contents := make(map[int]string)
+	for _, step := range steps {
+		id := step.node.ID
+		if id == 0 {
+			id = -step.from[0]
+		}
+		contents[id] = step.node.Content
+	}
+	return contents
+}
+
+func TestPlanMoveRewritesLinks(t *testing.T) {
+	projects, nodes := moveFixture()
+	plan, steps, err := planMove(projects, nodes, []int{1}, 2, MoveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Nodes) != 1 || plan.Nodes[0].Links != 2 {
+		t.Errorf("plan.Nodes = %+v", plan.Nodes)
+	}
+	want := map[int]string{
+		// B stays behind, and Dst has a C of its own.
+		1: "links [[Src/B]] and [[Src/C]]",
+		2: "back to [[Dst/A]]",
+		4: "see [[A]]",
+	}
+	got := stepContents(steps)
+	if len(got) != len(want) {
+		t.Errorf("stored %v, want %v", got, want)
+	}
+	for id, content := range want {
+		if got[id] != content {
+			t.Errorf("node %d = %q, want %q", id, got[id], content)
+		}
+	}
+	if len(plan.Changes) != 2 {
+		t.Errorf("plan.Changes = %+v", plan.Changes)
+	}
+}
+
+func TestPlanMoveLinked(t *testing.T) {
+	projects, nodes := moveFixture()
+	plan, steps, err := planMove(projects, nodes, []int{1}, 2, MoveOptions{Linked: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// B and C come along; C is numbered since Dst has one.
+	var titles []string
+	for _, moved := range plan.Nodes {
+		titles = append(titles, moved.Title)
+	}
+	if len(titles) != 3 || titles[0] != "A" || titles[1] != "B" || titles[2] != "C (2)" {
+		t.Fatalf("moved titles = %v", titles)
+	}
+	if plan.Nodes[2].Conflict != 5 {
+		t.Errorf("C conflict = %d, want 5", plan.Nodes[2].Conflict)
+	}
+	got := stepContents(steps)
+	if got[1] != "links [[B]] and [[C (2)]]" || got[2] != "back to [[A]]" {
+		t.Errorf("stored %v", got)
+	}
+}
+
+func TestPlanMoveCopy(t *testing.T) {
+	projects, nodes := moveFixture()
+	_, steps, err := planMove(projects, nodes, []int{1, 2}, 2, MoveOptions{Copy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{
+		// The copies link to each other; C is still the one in Src.
+		-1: "links [[B]] and [[Src/C]]",
+		-2: "back to [[A]]",
+	}
+	got := stepContents(steps)
+	if len(got) != len(want) {
+		t.Errorf("stored %v, want %v", got, want)
+	}
+	for id, content := range want {
+		if got[id] != content {
+			t.Errorf("node %d = %q, want %q", id, got[id], content)
+		}
+	}
+}
+
+func TestPlanMoveConflicts(t *testing.T) {
+	projects, nodes := moveFixture()
+
+	plan, steps, err := planMove(projects, nodes, []int{3}, 2, MoveOptions{Conflict: ConflictSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Nodes[0].Skipped || len(steps) != 0 {
+		t.Errorf("skip: plan %+v, steps %+v", plan, steps)
+	}
+
+	_, steps, err = planMove(projects, nodes, []int{3}, 2, MoveOptions{Conflict: ConflictMerge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A's link follows C into the node it was merged into.
+	got := stepContents(steps)
+	if len(steps) != 2 || got[1] != "links [[B]] and [[Dst/C]]" {
+		t.Fatalf("merge: steps %+v", steps)
+	}
+	if merge := steps[1]; merge.node.ID != 5 || !merge.merged || merge.from[0] != 3 {
+		t.Errorf("merge: step %+v", merge)
+	}
+
+	for _, bad := range []struct {
+		ids       []int
+		projectID int
+	}{{nil, 2}, {[]int{1}, 9}, {[]int{9}, 2}, {[]int{1, 4}, 2}, {[]int{4}, 2}} {
+		if _, _, err := planMove(projects, nodes, bad.ids, bad.projectID, MoveOptions{}); err == nil {
+			t.Errorf("planMove(%v, %d) succeeded", bad.ids, bad.projectID)
+		}
+	}
+}
+
+func TestMoveNodes(t *testing.T) {
+	for name, s := range map[string]Store{"db": newTestDb(t, nil), "mem": NewMemStore(nil)} {
+		t.Run(name, func(t *testing.T) {
+			for _, project := range []string{"Src", "Dst"} {
+				if err := s.AddProject(Project{Name: project}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			a := mustNode(t, s, 1, "A", "links [[B]]")
+			b := mustNode(t, s, 1, "B", "back to [[A]]")
+			if _, err := s.MoveNodes([]int{a.ID}, 2, MoveOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if a, err := s.GetNode(a.ID); err != nil || a.ProjectID != 2 || a.Content != "links [[Src/B]]" {
+				t.Errorf("moved node = %+v, %v", a, err)
+			}
+			if b, err := s.GetNode(b.ID); err != nil || b.Content != "back to [[Dst/A]]" {
+				t.Errorf("node left behind = %+v, %v", b, err)
+			}
+			if ids := backlinkIDs(t, s, a.ID); len(ids) != 1 || ids[0] != b.ID {
+				t.Errorf("backlinks of the moved node = %v", ids)
+			}
+		})
+	}
+}
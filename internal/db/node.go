@@ -1,11 +1,11 @@
 package db
 
 import (
-	"encoding/json"
 	"fmt"
-	"strconv"
+	"sort"
+	"time"
 
-	"go.etcd.io/bbolt"
+	"github.com/pixambi/gbrain/internal/markup"
 )
 
 type Node struct {
@@ -13,106 +13,319 @@ type Node struct {
 	Title     string
 	Content   string
 	ProjectID int
+	Tags      []string // Explicit tags; see AllTags for the full set
+	// Aliases are earlier titles left behind by RenameNode, which links
+	// still resolve to.
+	Aliases []string
+	// Properties are parsed from the content's frontmatter and key:: value
+	// fields each time the node is saved.
+	Properties map[string]markup.Value
+	Created    time.Time
+	Modified   time.Time
+	// Version counts the times the node has been stored. UpdateNode only
+	// succeeds if it matches the stored node.
+	Version int
+}
+
+// ConflictError is returned by UpdateNode when the node has been changed
+// since Mine was read from the store. Theirs is the node as now stored, or
+// the zero Node if it has been deleted.
+type ConflictError struct {
+	Mine   Node
+	Theirs Node
+}
+
+func (e *ConflictError) Error() string {
+	if e.Theirs.ID == 0 {
+		return fmt.Sprintf("node %q was deleted while it was being edited", e.Mine.Title)
+	}
+	return fmt.Sprintf("node %q was changed while it was being edited (version %d, now %d)", e.Mine.Title, e.Mine.Version, e.Theirs.Version)
+}
+
+// DuplicateTitleError is returned when a node would take a title that
+// another node in its project already has. Titles match ignoring case and
+// spacing, as links do.
+type DuplicateTitleError struct {
+	Title    string
+	Existing Node
+}
+
+func (e *DuplicateTitleError) Error() string {
+	return fmt.Sprintf("a node titled %q already exists in this project", e.Existing.Title)
+}
+
+// checkVersion returns a *ConflictError unless old, the stored node or nil
+// if it no longer exists, has the same version as node.
+func checkVersion(node Node, old *Node) error {
+	if old == nil {
+		return &ConflictError{Mine: node}
+	}
+	if old.Version != node.Version {
+		return &ConflictError{Mine: node, Theirs: *old}
+	}
+	return nil
+}
+
+// sortByTitle orders nodes by title, keeping nodes with the same title in
+// the order they were found.
+func sortByTitle(nodes []Node) {
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].Title < nodes[j].Title })
 }
 
 func (d *Db) GetNodes() ([]Node, error) {
 	var nodes []Node
-
-	err := d.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("nodes"))
-		if b == nil {
-			return fmt.Errorf("bucket not found")
-		}
-		return b.ForEach(func(k, v []byte) error {
-			var node Node
-			if err := json.Unmarshal(v, &node); err != nil {
-				return err
-			}
-			nodes = append(nodes, node)
-			return nil
-		})
+	err := d.View(func(tx *Tx) error {
+		var err error
+		nodes, err = tx.GetNodes()
+		return err
 	})
 	return nodes, err
 }
 
 func (d *Db) GetNodesByProjectID(projectID int) ([]Node, error) {
 	var nodes []Node
-
-	err := d.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("nodes"))
-		if b == nil {
-			return fmt.Errorf("bucket not found")
-		}
-
-		return b.ForEach(func(k, v []byte) error {
-			var node Node
-			if err := json.Unmarshal(v, &node); err != nil {
-				return err
-			}
-			if node.ProjectID == projectID {
-				nodes = append(nodes, node)
-			}
-			return nil
-		})
+	err := d.View(func(tx *Tx) error {
+		var err error
+		nodes, err = tx.GetNodesByProjectID(projectID)
+		return err
 	})
 	return nodes, err
 }
 
 func (d *Db) GetNode(id int) (Node, error) {
 	var node Node
+	err := d.View(func(tx *Tx) error {
+		var err error
+		node, err = tx.GetNode(id)
+		return err
+	})
+	return node, err
+}
 
-	err := d.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("nodes"))
-		if b == nil {
-			return fmt.Errorf("bucket not found")
-		}
+func (d *Db) AddNode(node Node) error {
+	return d.Update(func(tx *Tx) error {
+		return tx.AddNode(&node)
+	})
+}
 
-		v := b.Get([]byte(strconv.Itoa(id)))
-		if v == nil {
-			return fmt.Errorf("node not found")
+// UpdateNode stores node over an existing one, failing with a
+// *ConflictError if the stored node is not the version node was read at.
+func (d *Db) UpdateNode(node Node) error {
+	return d.Update(func(tx *Tx) error {
+		return tx.UpdateNode(&node)
+	})
+}
+
+func (d *Db) DeleteNode(id int) error {
+	return d.Update(func(tx *Tx) error {
+		return tx.DeleteNode(id)
+	})
+}
+
+func (t *Tx) GetNodes() ([]Node, error) {
+	var nodes []Node
+
+	b, err := t.bucket("nodes")
+	if err != nil {
+		return nil, err
+	}
+	err = b.ForEach(func(k, v []byte) error {
+		var node Node
+		if err := t.decodeRecord("nodes", k, v, &node); err != nil {
+			return err
 		}
+		nodes = append(nodes, node)
+		return nil
+	})
+	return nodes, err
+}
+
+func (t *Tx) GetNodesByProjectID(projectID int) ([]Node, error) {
+	if t.encrypted {
+		nodes, err := t.GetNodes()
+		return scanProjectNodes(nodes, projectID), err
+	}
 
-		return json.Unmarshal(v, &node)
+	var nodes []Node
+
+	pb, err := t.projectIndex(projectID, false)
+	if err != nil || pb == nil {
+		return nil, err
+	}
+	ids := pb.Bucket([]byte("ids"))
+	if ids == nil {
+		return nil, nil
+	}
+	err = ids.ForEach(func(k, _ []byte) error {
+		node, err := t.GetNode(btoi(k))
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+		return nil
 	})
+	return nodes, err
+}
+
+func (t *Tx) GetNode(id int) (Node, error) {
+	var node Node
+
+	b, err := t.bucket("nodes")
+	if err != nil {
+		return node, err
+	}
+	v := b.Get(itob(id))
+	if v == nil {
+		return node, fmt.Errorf("node not found")
+	}
+	err = t.decodeRecord("nodes", itob(id), v, &node)
 	return node, err
 }
 
-func (d *Db) AddNode(node Node) error {
+// AddNode stores node, allocating an ID first if it has none and stamping
+// its timestamps. The assigned ID and timestamps are written back to node.
+func (t *Tx) AddNode(node *Node) error {
+	return t.putNode(node, true)
+}
+
+// putNode stores node and updates every index. If touch is set the node's
+// modified time, and its project's, are set to now; restoring from the trash
+// leaves them as they were. It fails with a *DuplicateTitleError if node
+// takes a title in use in its project, but a node that already shares its
+// title can still be saved under it.
+func (t *Tx) putNode(node *Node, touch bool) error {
+	if normalizeTitle(node.Title) == "" {
+		return fmt.Errorf("node title is required")
+	}
+	b, err := t.bucket("nodes")
+	if err != nil {
+		return err
+	}
+
+	var old *Node
+	if v := b.Get(itob(node.ID)); node.ID != 0 && v != nil {
+		old = &Node{}
+		if err := t.decodeRecord("nodes", itob(node.ID), v, old); err != nil {
+			return err
+		}
+	}
+	if titleChanged(old, *node) {
+		if existing, taken, err := t.titleTaken(node.Title, node.ProjectID, node.ID); err != nil {
+			return err
+		} else if taken {
+			return &DuplicateTitleError{Title: node.Title, Existing: existing}
+		}
+	}
+
 	if node.ID == 0 {
-		id, err := d.GetNextID("nodes")
+		id, err := nextID(b)
 		if err != nil {
 			return err
 		}
 		node.ID = id
+	} else if old != nil {
+		if err := t.unindexNode(*old); err != nil {
+			return err
+		}
+		if node.Created.IsZero() {
+			node.Created = old.Created
+		}
+		node.Version = old.Version
+	} else if err := claimID(b, node.ID); err != nil {
+		return err
 	}
+	node.Version++
 
-	return d.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("nodes"))
-		if b == nil {
-			return fmt.Errorf("bucket not found")
-		}
+	node.Properties = markup.ParseProperties(node.Content)
 
-		buf, err := json.Marshal(node)
-		if err != nil {
+	now := time.Now()
+	if node.Created.IsZero() {
+		node.Created = now
+	}
+	if touch || node.Modified.IsZero() {
+		node.Modified = now
+		if err := t.touchProject(node.ProjectID, now); err != nil {
 			return err
 		}
+	}
 
-		key := []byte(strconv.Itoa(node.ID))
-		return b.Put(key, buf)
-	})
+	if err := t.putRecord(b, "nodes", itob(node.ID), node); err != nil {
+		return err
+	}
+	if err := t.indexNode(*node); err != nil {
+		return err
+	}
+	return t.appendRevision(*node)
 }
 
-func (d *Db) UpdateNode(node Node) error {
-	return d.AddNode(node)
+// UpdateNode is AddNode for a node that must already exist at the version
+// node carries; see Db.UpdateNode.
+func (t *Tx) UpdateNode(node *Node) error {
+	b, err := t.bucket("nodes")
+	if err != nil {
+		return err
+	}
+	// Only a missing record means the node was deleted; failing to read
+	// one that is there is an error of its own.
+	var old *Node
+	if v := b.Get(itob(node.ID)); v != nil {
+		old = &Node{}
+		if err := t.decodeRecord("nodes", itob(node.ID), v, old); err != nil {
+			return err
+		}
+	}
+	if err := checkVersion(*node, old); err != nil {
+		return err
+	}
+	return t.AddNode(node)
 }
 
-func (d *Db) DeleteNode(id int) error {
-	return d.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("nodes"))
-		if b == nil {
-			return fmt.Errorf("bucket not found")
-		}
+// DeleteNode moves a node to the trash. Its revisions are kept so that
+// restoring it brings its history back too.
+func (t *Tx) DeleteNode(id int) error {
+	node, err := t.GetNode(id)
+	if err != nil {
+		return err
+	}
+	// An orphaned node can still be trashed; it just cannot be restored
+	// until its project exists again.
+	project, err := t.GetProject(node.ProjectID)
+	if err != nil {
+		project = Project{ID: node.ProjectID}
+	}
+	if err := t.removeNode(node); err != nil {
+		return err
+	}
+	_, err = t.addTrash(TrashItem{Kind: TrashNode, Project: project, Nodes: []Node{node}})
+	return err
+}
 
-		return b.Delete([]byte(strconv.Itoa(id)))
-	})
+// removeNode deletes a node's record and index entries, leaving anything
+// stored against it for the trash to restore or purge.
+func (t *Tx) removeNode(node Node) error {
+	b, err := t.bucket("nodes")
+	if err != nil {
+		return err
+	}
+	if err := t.unindexNode(node); err != nil {
+		return err
+	}
+	return b.Delete(itob(node.ID))
+}
+
+// purgeNode removes everything stored against a node that has already been
+// removed, adding what it discarded to report.
+func (t *Tx) purgeNode(id int, report *DeleteReport) error {
+	revisions, err := t.deleteRevisions(id)
+	if err != nil {
+		return err
+	}
+	attachments, err := t.deleteAttachments(id)
+	if err != nil {
+		return err
+	}
+	report.NodeIDs = append(report.NodeIDs, id)
+	report.Revisions += revisions
+	report.Attachments += attachments
+	return nil
 }
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUpdateNodeConflict(t *testing.T) {
+	for name, s := range map[string]Store{"db": newTestDb(t, nil), "mem": NewMemStore(nil)} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.AddProject(Project{Name: "P"}); err != nil {
+				t.Fatal(err)
+			}
+			mine := mustNode(t, s, 1, "Note", "base")
+			theirs := mine
+			theirs.Content = "theirs"
+			if err := s.UpdateNode(theirs); err != nil {
+				t.Fatal(err)
+			}
+
+			mine.Content = "mine"
+			var conflict *ConflictError
+			if err := s.UpdateNode(mine); !errors.As(err, &conflict) {
+				t.Fatalf("UpdateNode of a stale copy = %v, want a conflict", err)
+			}
+			if conflict.Theirs.Content != "theirs" || conflict.Mine.Content != "mine" {
+				t.Errorf("conflict = %+v", conflict)
+			}
+
+			if err := s.DeleteNode(mine.ID); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.UpdateNode(conflict.Theirs); !errors.As(err, &conflict) || conflict.Theirs.ID != 0 {
+				t.Errorf("UpdateNode of a deleted node = %v", err)
+			}
+		})
+	}
+}
+
+func TestUpdateNodeUnreadable(t *testing.T) {
+	d := newTestDb(t, nil)
+	m := NewMemStore(nil)
+	corrupt := map[string]func(id int) error{
+		"db": func(id int) error {
+			return d.Update(func(tx *Tx) error {
+				b, err := tx.bucket("nodes")
+				if err != nil {
+					return err
+				}
+				return b.Put(itob(id), []byte("not json"))
+			})
+		},
+		"mem": func(id int) error {
+			m.nodes[id] = []byte("not json")
+			return nil
+		},
+	}
+	for name, s := range map[string]Store{"db": d, "mem": m} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.AddProject(Project{Name: "P"}); err != nil {
+				t.Fatal(err)
+			}
+			node := mustNode(t, s, 1, "Note", "")
+			if err := corrupt[name](node.ID); err != nil {
+				t.Fatal(err)
+			}
+			// A record that cannot be read is not a deleted node.
+			var conflict *ConflictError
+			if err := s.UpdateNode(node); err == nil || errors.As(err, &conflict) {
+				t.Errorf("UpdateNode of an unreadable node = %v", err)
+			}
+		})
+	}
+}
+
+func TestAddNodeDuplicateTitle(t *testing.T) {
+	for name, s := range map[string]Store{"db": newTestDb(t, nil), "mem": NewMemStore(nil)} {
+		t.Run(name, func(t *testing.T) {
+			for _, project := range []string{"P", "Q"} {
+				if err := s.AddProject(Project{Name: project}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			first := mustNode(t, s, 1, "Title", "")
+
+			var dup *DuplicateTitleError
+			if err := s.AddNode(Node{ProjectID: 1, Title: " title "}); !errors.As(err, &dup) || dup.Existing.ID != first.ID {
+				t.Errorf("AddNode with a taken title = %v", err)
+			}
+			if err := s.AddNode(Node{ProjectID: 2, Title: "Title"}); err != nil {
+				t.Errorf("AddNode with a title taken in another project = %v", err)
+			}
+			// A node can still be saved under its own title.
+			first.Content = "edited"
+			if err := s.UpdateNode(first); err != nil {
+				t.Errorf("UpdateNode = %v", err)
+			}
+		})
+	}
+}
+
+func TestAddWithExplicitID(t *testing.T) {
+	for name, s := range map[string]Store{"db": newTestDb(t, nil), "mem": NewMemStore(nil)} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.AddProject(Project{ID: 5, Name: "Five"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.AddProject(Project{Name: "Next"}); err != nil {
+				t.Fatal(err)
+			}
+			projects, err := s.GetProjects()
+			if err != nil || len(projects) != 2 {
+				t.Fatalf("GetProjects = %+v, %v", projects, err)
+			}
+			for _, project := range projects {
+				if project.Name == "Next" && project.ID != 6 {
+					t.Errorf("project added after ID 5 got ID %d", project.ID)
+				}
+			}
+
+			if err := s.AddNode(Node{ID: 10, ProjectID: 5, Title: "Ten", Content: "ten"}); err != nil {
+				t.Fatal(err)
+			}
+			next := mustNode(t, s, 5, "Next", "next")
+			if next.ID != 11 {
+				t.Errorf("node added after ID 10 got ID %d", next.ID)
+			}
+			if node, err := s.GetNode(10); err != nil || node.Content != "ten" {
+				t.Errorf("node 10 = %+v, %v", node, err)
+			}
+		})
+	}
+}
+
+func TestNodeTimestamps(t *testing.T) {
+	for name, s := range map[string]Store{
+		"db":       newTestDb(t, nil),
+		"mem":      NewMemStore(nil),
+		"markdown": newTestMarkdownStore(t, t.TempDir()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.AddProject(Project{Name: "P"}); err != nil {
+				t.Fatal(err)
+			}
+			node := mustNode(t, s, 1, "Note", "first")
+			if node.Created.IsZero() || !node.Modified.Equal(node.Created) {
+				t.Fatalf("new node: created %v, modified %v", node.Created, node.Modified)
+			}
+
+			time.Sleep(time.Millisecond)
+			node.Content = "second"
+			if err := s.UpdateNode(node); err != nil {
+				t.Fatal(err)
+			}
+			updated, err := s.GetNode(node.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !updated.Created.Equal(node.Created) {
+				t.Errorf("created moved from %v to %v", node.Created, updated.Created)
+			}
+			if !updated.Modified.After(node.Modified) {
+				t.Errorf("modified did not advance: %v, was %v", updated.Modified, node.Modified)
+			}
+			project, err := s.GetProject(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !project.Modified.Equal(updated.Modified) {
+				t.Errorf("project modified %v, node modified %v", project.Modified, updated.Modified)
+			}
+
+			// Restoring from the trash is not an edit.
+			if err := s.DeleteNode(node.ID); err != nil {
+				t.Fatal(err)
+			}
+			trash, err := s.GetTrash()
+			if err != nil || len(trash) != 1 {
+				t.Fatalf("GetTrash = %+v, %v", trash, err)
+			}
+			time.Sleep(time.Millisecond)
+			if err := s.RestoreTrash(trash[0].ID); err != nil {
+				t.Fatal(err)
+			}
+			restored, err := s.GetNode(node.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !restored.Created.Equal(updated.Created) || !restored.Modified.Equal(updated.Modified) {
+				t.Errorf("restored node: created %v, modified %v; want %v, %v",
+					restored.Created, restored.Modified, updated.Created, updated.Modified)
+			}
+		})
+	}
+}
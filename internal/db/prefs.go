@@ -0,0 +1,36 @@
+package db
+
+// Preferences are small pieces of UI state, such as sort orders, that
+// should survive a restart. They live alongside the schema version:
+//
+//	meta/prefs/<key> -> value
+
+// GetPreference returns the stored value for key, or "" if it is unset.
+func (d *Db) GetPreference(key string) (string, error) {
+	var value string
+	err := d.View(func(tx *Tx) error {
+		meta, err := tx.bucket("meta")
+		if err != nil {
+			return err
+		}
+		if prefs := meta.Bucket([]byte("prefs")); prefs != nil {
+			value = string(prefs.Get([]byte(key)))
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (d *Db) SetPreference(key, value string) error {
+	return d.Update(func(tx *Tx) error {
+		meta, err := tx.bucket("meta")
+		if err != nil {
+			return err
+		}
+		prefs, err := meta.CreateBucketIfNotExists([]byte("prefs"))
+		if err != nil {
+			return err
+		}
+		return prefs.Put([]byte(key), []byte(value))
+	})
+}
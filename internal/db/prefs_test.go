@@ -0,0 +1,55 @@
+package db
+
+import "testing"
+
+func TestPreferences(t *testing.T) {
+	for name, s := range map[string]Store{
+		"db":       newTestDb(t, nil),
+		"mem":      NewMemStore(nil),
+		"markdown": newTestMarkdownStore(t, t.TempDir()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if value, err := s.GetPreference("sort.projects"); err != nil || value != "" {
+				t.Errorf("unset preference = %q, %v", value, err)
+			}
+			for _, value := range []string{"modified", "links", ""} {
+				if err := s.SetPreference("sort.projects", value); err != nil {
+					t.Fatal(err)
+				}
+				if got, err := s.GetPreference("sort.projects"); err != nil || got != value {
+					t.Errorf("after setting %q got %q, %v", value, got, err)
+				}
+			}
+			if err := s.SetPreference("sort.project.1", "created"); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := s.GetPreference("sort.project.2"); got != "" {
+				t.Errorf("unrelated key = %q", got)
+			}
+		})
+	}
+}
+
+func TestPreferencesPersist(t *testing.T) {
+	d := newTestDb(t, nil)
+	if err := d.SetPreference("sort.projects", "created"); err != nil {
+		t.Fatal(err)
+	}
+	d = reopen(t, d)
+	if got, err := d.GetPreference("sort.projects"); err != nil || got != "created" {
+		t.Errorf("db after reopening: %q, %v", got, err)
+	}
+
+	dir := t.TempDir()
+	s := newTestMarkdownStore(t, dir)
+	if err := s.SetPreference("sort.projects", "links"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = newTestMarkdownStore(t, dir)
+	if got, err := s.GetPreference("sort.projects"); err != nil || got != "links" {
+		t.Errorf("markdown after reopening: %q, %v", got, err)
+	}
+}
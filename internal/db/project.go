@@ -1,103 +1,211 @@
 package db
 
 import (
-	"encoding/json"
 	"fmt"
-	"strconv"
-
-	"go.etcd.io/bbolt"
+	"strings"
+	"time"
 )
 
 type Project struct {
-	ID   int
-	Name string
+	ID       int
+	Name     string
+	Created  time.Time
+	Modified time.Time // Last change to the project or any of its nodes
 }
 
 func (d *Db) GetProjects() ([]Project, error) {
 	var projects []Project
-
-	err := d.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("projects"))
-		if b == nil {
-			return fmt.Errorf("bucket not found")
-		}
-
-		return b.ForEach(func(k, v []byte) error {
-			var project Project
-			if err := json.Unmarshal(v, &project); err != nil {
-				return err
-			}
-			projects = append(projects, project)
-			return nil
-		})
+	err := d.View(func(tx *Tx) error {
+		var err error
+		projects, err = tx.GetProjects()
+		return err
 	})
 	return projects, err
 }
 
 func (d *Db) GetProject(id int) (Project, error) {
 	var project Project
+	err := d.View(func(tx *Tx) error {
+		var err error
+		project, err = tx.GetProject(id)
+		return err
+	})
+	return project, err
+}
 
-	err := d.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("projects"))
-		if b == nil {
-			return fmt.Errorf("bucket not found")
-		}
+func (d *Db) AddProject(project Project) error {
+	return d.Update(func(tx *Tx) error {
+		return tx.AddProject(&project)
+	})
+}
 
-		v := b.Get([]byte(strconv.Itoa(id)))
-		if v == nil {
-			return fmt.Errorf("project not found")
-		}
+func (d *Db) UpdateProject(project Project) error {
+	return d.AddProject(project)
+}
 
-		return json.Unmarshal(v, &project)
+// DeleteProject moves a project and all of its nodes to the trash in a
+// single transaction and reports what was removed.
+func (d *Db) DeleteProject(id int) (DeleteReport, error) {
+	var report DeleteReport
+	err := d.Update(func(tx *Tx) error {
+		var err error
+		report, err = tx.DeleteProject(id)
+		return err
 	})
-	return project, err
+	if err != nil {
+		return DeleteReport{}, err
+	}
+	return report, nil
 }
 
-func (d *Db) AddProject(project Project) error {
-	if project.ID == 0 {
-		id, err := d.GetNextID("projects")
-		if err != nil {
+func (t *Tx) GetProjects() ([]Project, error) {
+	var projects []Project
+
+	b, err := t.bucket("projects")
+	if err != nil {
+		return nil, err
+	}
+	err = b.ForEach(func(k, v []byte) error {
+		var project Project
+		if err := t.decodeRecord("projects", k, v, &project); err != nil {
 			return err
 		}
-		project.ID = id
+		projects = append(projects, project)
+		return nil
+	})
+	return projects, err
+}
+
+func (t *Tx) GetProject(id int) (Project, error) {
+	var project Project
+
+	b, err := t.bucket("projects")
+	if err != nil {
+		return project, err
+	}
+	v := b.Get(itob(id))
+	if v == nil {
+		return project, fmt.Errorf("project not found")
 	}
+	err = t.decodeRecord("projects", itob(id), v, &project)
+	return project, err
+}
 
-	return d.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("projects"))
-		if b == nil {
-			return fmt.Errorf("bucket not found")
-		}
+// AddProject stores project, allocating an ID first if it has none and
+// stamping its timestamps. The assigned ID and timestamps are written back
+// to project.
+func (t *Tx) AddProject(project *Project) error {
+	if strings.TrimSpace(project.Name) == "" {
+		return fmt.Errorf("project name is required")
+	}
+	return t.putProject(project, true)
+}
 
-		buf, err := json.Marshal(project)
+func (t *Tx) putProject(project *Project, touch bool) error {
+	b, err := t.bucket("projects")
+	if err != nil {
+		return err
+	}
+
+	var old *Project
+	if v := b.Get(itob(project.ID)); project.ID != 0 && v != nil {
+		old = &Project{}
+		if err := t.decodeRecord("projects", itob(project.ID), v, old); err != nil {
+			return err
+		}
+	}
+	if project.ID == 0 {
+		id, err := nextID(b)
 		if err != nil {
 			return err
 		}
+		project.ID = id
+	} else if old == nil {
+		if err := claimID(b, project.ID); err != nil {
+			return err
+		}
+	} else if project.Created.IsZero() {
+		project.Created = old.Created
+	}
 
-		key := []byte(strconv.Itoa(project.ID))
-		return b.Put(key, buf)
-	})
+	now := time.Now()
+	if project.Created.IsZero() {
+		project.Created = now
+	}
+	if touch || project.Modified.IsZero() {
+		project.Modified = now
+	}
+
+	if err := t.putRecord(b, "projects", itob(project.ID), project); err != nil {
+		return err
+	}
+	if old == nil || normalizeTitle(old.Name) != normalizeTitle(project.Name) {
+		// Links may name the project differently now.
+		return t.reindexQualifiedBacklinks(project.ID, project.Name)
+	}
+	return nil
 }
 
-func (d *Db) UpdateProject(project Project) error {
-	return d.AddProject(project)
+func (t *Tx) UpdateProject(project *Project) error {
+	return t.AddProject(project)
 }
-func (d *Db) DeleteProject(id int) error {
-	nodes, err := d.GetNodesByProjectID(id)
+
+// touchProject sets a project's modified time without changing anything
+// else. Missing projects are ignored so that orphaned nodes can be saved.
+func (t *Tx) touchProject(id int, now time.Time) error {
+	b, err := t.bucket("projects")
+	if err != nil || b.Get(itob(id)) == nil {
+		return err
+	}
+	project, err := t.GetProject(id)
 	if err != nil {
 		return err
 	}
+	project.Modified = now
+	return t.putProject(&project, false)
+}
+
+// DeleteProject moves a project and every node in it to the trash as a
+// single item.
+func (t *Tx) DeleteProject(id int) (DeleteReport, error) {
+	report := DeleteReport{ProjectID: id}
+
+	project, err := t.GetProject(id)
+	if err != nil {
+		return report, err
+	}
+	nodes, err := t.GetNodesByProjectID(id)
+	if err != nil {
+		return report, err
+	}
 	for _, node := range nodes {
-		if err := d.DeleteNode(node.ID); err != nil {
-			return err
+		if err := t.removeNode(node); err != nil {
+			return report, err
 		}
+		report.NodeIDs = append(report.NodeIDs, node.ID)
 	}
 
-	return d.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("projects"))
-		if b == nil {
-			return fmt.Errorf("bucket not found")
+	root, err := t.bucket("project_nodes")
+	if err != nil {
+		return report, err
+	}
+	if root.Bucket(itob(id)) != nil {
+		if err := root.DeleteBucket(itob(id)); err != nil {
+			return report, err
 		}
+	}
 
-		return b.Delete([]byte(strconv.Itoa(id)))
-	})
+	b, err := t.bucket("projects")
+	if err != nil {
+		return report, err
+	}
+	if err := b.Delete(itob(id)); err != nil {
+		return report, err
+	}
+	if err := t.reindexQualifiedBacklinks(id, ""); err != nil {
+		return report, err
+	}
+
+	report.TrashID, err = t.addTrash(TrashItem{Kind: TrashProject, Project: project, Nodes: nodes})
+	return report, err
 }
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// dumpIndexes lists every key and value in the derived indexes as
+// bucket/.../key=value, leaving out empty buckets.
+func dumpIndexes(tx *Tx) []string {
+	var entries []string
+	var walk func(prefix string, b *bbolt.Bucket)
+	walk = func(prefix string, b *bbolt.Bucket) {
+		b.ForEach(func(k, v []byte) error {
+			if sub := b.Bucket(k); sub != nil {
+				walk(fmt.Sprintf("%s/%x", prefix, k), sub)
+			} else {
+				entries = append(entries, fmt.Sprintf("%s/%x=%s", prefix, k, v))
+			}
+			return nil
+		})
+	}
+	for _, name := range indexBuckets {
+		if b := tx.tx.Bucket([]byte(name)); b != nil {
+			walk(name, b)
+		}
+	}
+	return entries
+}
+
+// checkIndexes fails the test unless the derived indexes of d hold exactly
+// what rebuilding them from the node records would.
+func checkIndexes(t *testing.T, d *Db, when string) {
+	t.Helper()
+	errRollback := errors.New("rollback")
+	err := d.Update(func(tx *Tx) error {
+		kept := dumpIndexes(tx)
+		if err := tx.RebuildIndexes(); err != nil {
+			return err
+		}
+		rebuilt := dumpIndexes(tx)
+		for _, entry := range kept {
+			if !slices.Contains(rebuilt, entry) {
+				t.Errorf("%s: stale index entry %s", when, entry)
+			}
+		}
+		for _, entry := range rebuilt {
+			if !slices.Contains(kept, entry) {
+				t.Errorf("%s: missing index entry %s", when, entry)
+			}
+		}
+		return errRollback
+	})
+	if err != errRollback {
+		t.Fatal(err)
+	}
+}
+
+// projectFixture fills s with two projects whose notes link, tag and give
+// properties to each other, with a revision and an attachment in the first.
+func projectFixture(t *testing.T, s Store) {
+	t.Helper()
+	for _, name := range []string{"P", "Q"} {
+		if err := s.AddProject(Project{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := mustNode(t, s, 1, "A", "see [[B]] #go\nstatus:: open")
+	a.Content += " and more"
+	if err := s.UpdateNode(a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddAttachment(a.ID, "a.txt", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	mustNode(t, s, 1, "B", "bolt #db")
+	mustNode(t, s, 2, "C", "see [[P/A]] and [[A]] #go\nstatus:: done")
+}
+
+func TestDeleteProject(t *testing.T) {
+	for name, s := range map[string]Store{"db": newTestDb(t, nil), "mem": NewMemStore(nil)} {
+		t.Run(name, func(t *testing.T) {
+			projectFixture(t, s)
+			report, err := s.DeleteProject(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.ProjectID != 1 || !slices.Equal(report.NodeIDs, []int{1, 2}) || report.TrashID == 0 {
+				t.Errorf("DeleteProject = %+v", report)
+			}
+			if _, err := s.GetProject(1); err == nil {
+				t.Error("deleted project is still there")
+			}
+			if nodes, err := s.GetNodesByProjectID(1); err != nil || len(nodes) != 0 {
+				t.Errorf("GetNodesByProjectID = %+v, %v", nodes, err)
+			}
+			if titles := nodeTitles(t, s); !slices.Equal(titles, []string{"C"}) {
+				t.Errorf("nodes left = %q", titles)
+			}
+			if found := searchTitles(t, s, "bolt", SearchOptions{}); len(found) != 0 {
+				t.Errorf("search finds %q", found)
+			}
+			if tagged, err := s.GetNodesByTag("db"); err != nil || len(tagged) != 0 {
+				t.Errorf("GetNodesByTag = %+v, %v", tagged, err)
+			}
+			if open, err := s.GetNodesByProperty("status", "open"); err != nil || len(open) != 0 {
+				t.Errorf("GetNodesByProperty = %+v, %v", open, err)
+			}
+			if d, ok := s.(*Db); ok {
+				checkIndexes(t, d, "after deleting")
+			}
+
+			if err := s.RestoreTrash(report.TrashID); err != nil {
+				t.Fatal(err)
+			}
+			if titles := nodeTitles(t, s); !slices.Equal(titles, []string{"A", "B", "C"}) {
+				t.Errorf("nodes after restoring = %q", titles)
+			}
+			if ids := backlinkIDs(t, s, 1); !slices.Equal(ids, []int{3}) {
+				t.Errorf("backlinks after restoring = %v", ids)
+			}
+			if revisions, err := s.GetRevisions(1); err != nil || len(revisions) != 2 {
+				t.Errorf("revisions after restoring = %+v, %v", revisions, err)
+			}
+			if attachments, err := s.GetAttachments(1); err != nil || len(attachments) != 1 {
+				t.Errorf("attachments after restoring = %+v, %v", attachments, err)
+			}
+			if d, ok := s.(*Db); ok {
+				checkIndexes(t, d, "after restoring")
+			}
+		})
+	}
+}
+
+func TestDeleteProjectIsAtomic(t *testing.T) {
+	d := newTestDb(t, nil)
+	projectFixture(t, d)
+	var before []string
+	err := d.Update(func(tx *Tx) error {
+		before = dumpIndexes(tx)
+		// Without a trash to move it to, the delete fails at the last step.
+		return tx.tx.DeleteBucket([]byte("trash"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.DeleteProject(1); err == nil {
+		t.Fatal("DeleteProject succeeded without a trash")
+	}
+	if _, err := d.GetProject(1); err != nil {
+		t.Errorf("GetProject after a failed delete: %v", err)
+	}
+	if titles := nodeTitles(t, d); !slices.Equal(titles, []string{"A", "B", "C"}) {
+		t.Errorf("nodes after a failed delete = %q", titles)
+	}
+	err = d.View(func(tx *Tx) error {
+		if after := dumpIndexes(tx); !slices.Equal(after, before) {
+			t.Errorf("indexes changed by a failed delete")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(t, d, "after a failed delete")
+}
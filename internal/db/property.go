@@ -0,0 +1,173 @@
+package db
+
+import "github.com/pixambi/gbrain/internal/markup"
+
+// Properties are parsed from a node's frontmatter and key:: value fields
+// whenever it is saved, and indexed across every project:
+//
+//	properties/<key>/<normalized value>/<nodeID> -> ""
+
+// maxPropertyKeyLength bounds index keys built from property values.
+const maxPropertyKeyLength = 256
+
+func propertyIndexKey(value string) []byte {
+	if len(value) > maxPropertyKeyLength {
+		value = value[:maxPropertyKeyLength]
+	}
+	return []byte(value)
+}
+
+func (t *Tx) indexProperties(node Node) error {
+	root, err := t.bucket("properties")
+	if err != nil {
+		return err
+	}
+	for key, value := range node.Properties {
+		kb, err := root.CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		for _, v := range value.IndexKeys() {
+			vb, err := kb.CreateBucketIfNotExists(propertyIndexKey(v))
+			if err != nil {
+				return err
+			}
+			if err := vb.Put(itob(node.ID), nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Tx) unindexProperties(node Node) error {
+	root, err := t.bucket("properties")
+	if err != nil {
+		return err
+	}
+	for key, value := range node.Properties {
+		kb := root.Bucket([]byte(key))
+		if kb == nil {
+			continue
+		}
+		for _, v := range value.IndexKeys() {
+			vb := kb.Bucket(propertyIndexKey(v))
+			if vb == nil {
+				continue
+			}
+			if err := vb.Delete(itob(node.ID)); err != nil {
+				return err
+			}
+			if k, _ := vb.Cursor().First(); k == nil {
+				if err := kb.DeleteBucket(propertyIndexKey(v)); err != nil {
+					return err
+				}
+			}
+		}
+		if k, _ := kb.Cursor().First(); k == nil {
+			if err := root.DeleteBucket([]byte(key)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetNodesByProperty returns nodes whose property key has the given value,
+// across every project. The value is parsed the same way as stored values,
+// so "2024-01-05", "yes" and "3.0" match dates, booleans and numbers, and a
+// single item matches any list containing it.
+func (d *Db) GetNodesByProperty(key, value string) ([]Node, error) {
+	var nodes []Node
+	err := d.View(func(tx *Tx) error {
+		var err error
+		nodes, err = tx.GetNodesByProperty(key, value)
+		return err
+	})
+	return nodes, err
+}
+
+// GetPropertyKeys returns every property key in use, in order.
+func (d *Db) GetPropertyKeys() ([]string, error) {
+	var keys []string
+	err := d.View(func(tx *Tx) error {
+		var err error
+		keys, err = tx.GetPropertyKeys()
+		return err
+	})
+	return keys, err
+}
+
+func (t *Tx) GetPropertyKeys() ([]string, error) {
+	if t.encrypted {
+		nodes, err := t.GetNodes()
+		return scanPropertyKeys(nodes), err
+	}
+	root, err := t.bucket("properties")
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	err = root.ForEach(func(k, _ []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	return keys, err
+}
+
+func (t *Tx) GetNodesByProperty(key, value string) ([]Node, error) {
+	if t.encrypted {
+		nodes, err := t.GetNodes()
+		return scanNodesByProperty(nodes, key, value), err
+	}
+	root, err := t.bucket("properties")
+	if err != nil {
+		return nil, err
+	}
+	kb := root.Bucket([]byte(markup.NormalizeKey(key)))
+	if kb == nil {
+		return nil, nil
+	}
+
+	seen := make(map[int]bool)
+	var nodes []Node
+	for _, v := range markup.ParseValue(value).IndexKeys() {
+		vb := kb.Bucket(propertyIndexKey(v))
+		if vb == nil {
+			continue
+		}
+		err := vb.ForEach(func(k, _ []byte) error {
+			id := btoi(k)
+			if seen[id] {
+				return nil
+			}
+			seen[id] = true
+			node, err := t.GetNode(id)
+			if err != nil {
+				return err
+			}
+			nodes = append(nodes, node)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sortByTitle(nodes)
+	return nodes, nil
+}
+
+// migrateProperties parses properties for nodes saved before they existed.
+// Saving through putNode also rebuilds the property index entries.
+func migrateProperties(tx *Tx) error {
+	nodes, err := tx.GetNodes()
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := tx.putNode(&node, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
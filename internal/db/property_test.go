@@ -0,0 +1,78 @@
+package db
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/pixambi/gbrain/internal/markup"
+)
+
+func TestProperties(t *testing.T) {
+	for name, s := range searchStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, project := range []string{"P", "Q"} {
+				if err := s.AddProject(Project{Name: project}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			a := mustNode(t, s, 1, "A", "---\nstatus: open\ntags:\n  - go\n  - bolt\n---\nbody")
+			b := mustNode(t, s, 2, "B", "status:: Open\npriority:: 2\ndue:: 2024-01-05")
+			mustNode(t, s, 2, "C", "- priority:: 2.0")
+
+			if got := a.Properties["tags"]; got.Kind != markup.List || !slices.Equal(got.List, []string{"go", "bolt"}) {
+				t.Errorf("A's tags = %+v", got)
+			}
+			if got := b.Properties["priority"]; got.Kind != markup.Number || got.Number != 2 {
+				t.Errorf("B's priority = %+v", got)
+			}
+
+			wantKeys := func(want ...string) {
+				t.Helper()
+				keys, err := s.GetPropertyKeys()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(keys, want) {
+					t.Errorf("GetPropertyKeys = %q, want %q", keys, want)
+				}
+			}
+			wantNodes := func(key, value string, want ...string) {
+				t.Helper()
+				nodes, err := s.GetNodesByProperty(key, value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, node := range nodes {
+					got = append(got, node.Title)
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("GetNodesByProperty(%q, %q) = %q, want %q", key, value, got, want)
+				}
+			}
+
+			wantKeys("due", "priority", "status", "tags")
+			wantNodes(" Status ", "OPEN", "A", "B")
+			wantNodes("priority", "2", "B", "C")
+			wantNodes("tags", "go", "A")
+			wantNodes("tags", "[go, bolt]", "A")
+			wantNodes("due", "2024-01-05", "B")
+			wantNodes("status", "closed")
+			wantNodes("missing", "x")
+
+			// Editing and deleting nodes drops what they no longer carry.
+			b.Content = "status:: closed"
+			if err := s.UpdateNode(b); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.DeleteNode(a.ID); err != nil {
+				t.Fatal(err)
+			}
+			wantKeys("priority", "status")
+			wantNodes("status", "open")
+			wantNodes("status", "closed", "B")
+			wantNodes("priority", "2", "C")
+			wantNodes("due", "2024-01-05")
+		})
+	}
+}
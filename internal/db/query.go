@@ -1,42 +1,62 @@
 package db
 
-import (
-	"encoding/json"
-	"fmt"
-
-	"go.etcd.io/bbolt"
-)
+import "fmt"
 
 func (d *Db) GetNodeByTitle(title string, projectID int) (Node, error) {
 	var node Node
-	var found bool
-
-	err := d.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("nodes"))
-		if b == nil {
-			return fmt.Errorf("bucket not found")
-		}
-
-		return b.ForEach(func(k, v []byte) error {
-			var n Node
-			if err := json.Unmarshal(v, &n); err != nil {
-				return err
-			}
-			if n.Title == title && n.ProjectID == projectID {
-				node = n
-				found = true
-			}
-			return nil
-		})
+	err := d.View(func(tx *Tx) error {
+		var err error
+		node, err = tx.GetNodeByTitle(title, projectID)
+		return err
 	})
+	return node, err
+}
 
+// GetNodeByTitle resolves a title within a project. Matching ignores case
+// and spacing, and also finds nodes by their aliases. If several nodes
+// match, an exact title match is preferred, then a title rather than an
+// alias, and otherwise the oldest node wins.
+func (t *Tx) GetNodeByTitle(title string, projectID int) (Node, error) {
+	if t.encrypted {
+		nodes, err := t.GetNodes()
+		if err != nil {
+			return Node{}, err
+		}
+		return scanNodeByTitle(nodes, title, projectID)
+	}
+	ids, err := t.nodeIDsByTitle(title, projectID)
 	if err != nil {
 		return Node{}, err
 	}
-
-	if !found {
+	if len(ids) == 0 {
 		return Node{}, fmt.Errorf("node not found")
 	}
 
-	return node, nil
+	nodes := make([]Node, 0, len(ids))
+	for _, id := range ids {
+		node, err := t.GetNode(id)
+		if err != nil {
+			return Node{}, err
+		}
+		nodes = append(nodes, node)
+	}
+	return bestTitleMatch(title, nodes), nil
+}
+
+// bestTitleMatch picks from nodes, in ID order, the one whose title is
+// exactly title, or else the oldest whose title matches, or else the
+// oldest, which matched by an alias.
+func bestTitleMatch(title string, nodes []Node) Node {
+	for _, node := range nodes {
+		if node.Title == title {
+			return node
+		}
+	}
+	key := normalizeTitle(title)
+	for _, node := range nodes {
+		if normalizeTitle(node.Title) == key {
+			return node
+		}
+	}
+	return nodes[0]
 }
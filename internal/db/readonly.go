@@ -0,0 +1,78 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrReadOnly is returned for changes to a database opened by NewDbCopy.
+var ErrReadOnly = errors.New("the database is open read-only")
+
+// A process that has the database open holds an exclusive lock on it, which
+// keeps out readers as well as writers. To look at notes while that process
+// runs, the file is copied as it stands and the copy opened instead. A copy
+// taken while a commit is being written may be torn, so it is checked and
+// taken again if it does not hold together.
+
+const (
+	copyAttempts   = 3
+	copyRetryDelay = 100 * time.Millisecond
+)
+
+// NewDbCopy opens a private copy of the database at path, for reading it
+// while another process has it open. The copy does not follow later changes
+// to path, refuses changes of its own with ErrReadOnly once it has been
+// initialised, and is deleted on Close. A nil opts uses the zero Options.
+func NewDbCopy(path string, opts *Options) (*Db, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	// The copy sits next to the database so that it is no more exposed
+	// than the database itself.
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".readonly-*")
+	if err != nil {
+		return nil, err
+	}
+	tmp := f.Name()
+	f.Close()
+
+	for attempt := 1; ; attempt++ {
+		d, err := openCopy(path, tmp)
+		if err == nil {
+			d.readOnly = true
+			d.copyPath = tmp
+			if opts != nil {
+				d.opts = *opts
+			}
+			return d, nil
+		}
+		if attempt == copyAttempts {
+			os.Remove(tmp)
+			return nil, err
+		}
+		time.Sleep(copyRetryDelay)
+	}
+}
+
+// openCopy copies path over tmp and opens the copy if it is intact.
+func openCopy(path, tmp string) (*Db, error) {
+	if err := copyFile(path, tmp); err != nil {
+		return nil, err
+	}
+	db, err := openBolt(tmp, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.View(checkPages); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Db{db: db}, nil
+}
+
+// ReadOnly reports whether d is a copy opened by NewDbCopy.
+func (d *Db) ReadOnly() bool {
+	return d.readOnly
+}
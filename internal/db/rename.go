@@ -0,0 +1,156 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/pixambi/gbrain/internal/markup"
+)
+
+// RenamePlan describes renaming a node: the node as it is after the
+// rename, and every node whose links to it are rewritten.
+type RenamePlan struct {
+	Node    Node
+	Changes []LinkChange
+}
+
+// LinkChange is a node whose links are rewritten by a rename. It may be the
+// renamed node itself.
+type LinkChange struct {
+	NodeID int
+	Title  string
+	Links  int
+}
+
+// planRename works out renaming node to title, given every project and node
+// in the store. It returns the plan and the nodes to store, the renamed
+// node first.
+//
+// It fails with a *DuplicateTitleError if another node has the title.
+// Every link that resolves to node is rewritten to the new title, qualified
+// with the project's name in other projects, unless it would still match
+// it anyway, and the old title is kept as an alias so that links written
+// elsewhere, or later from memory, still arrive.
+func planRename(projects []Project, nodes []Node, node Node, title string) (RenamePlan, []Node, error) {
+	if normalizeTitle(title) == "" {
+		return RenamePlan{}, nil, fmt.Errorf("node title is required")
+	}
+	siblings := scanProjectNodes(nodes, node.ProjectID)
+	if normalizeTitle(title) != normalizeTitle(node.Title) {
+		if existing, taken := titleOwner(siblings, title, node.ID); taken {
+			return RenamePlan{}, nil, &DuplicateTitleError{Title: title, Existing: existing}
+		}
+	}
+	renamed := node
+	renamed.Title = title
+	renamed.Aliases = renameAliases(node, title)
+	if _, shared := titleOwner(siblings, node.Title, node.ID); shared {
+		// The old title is another node's too, and links to it should go
+		// there once this one is out of the way.
+		renamed.Aliases = slices.DeleteFunc(renamed.Aliases, func(alias string) bool {
+			return SameTitle(alias, node.Title)
+		})
+	}
+
+	resolver := newLinkResolver(projects, nodes)
+	rewrite := func(projectID int) func(string) (string, bool) {
+		return func(link string) (string, bool) {
+			text := resolver.linkText(renamed, projectID)
+			if normalizeTitle(link) == normalizeTitle(text) {
+				return "", false
+			}
+			target, ok := resolver.resolve(link, projectID)
+			return text, ok && target.ID == node.ID
+		}
+	}
+	var plan RenamePlan
+	changed := []Node{renamed}
+	for _, source := range nodes {
+		content, links := markup.RewriteLinks(source.Content, rewrite(source.ProjectID))
+		if links == 0 {
+			continue
+		}
+		if source.ID == node.ID {
+			changed[0].Content = content
+			source.Title = title
+		} else {
+			source.Content = content
+			changed = append(changed, source)
+		}
+		plan.Changes = append(plan.Changes, LinkChange{NodeID: source.ID, Title: source.Title, Links: links})
+	}
+	plan.Node = changed[0]
+	return plan, changed, nil
+}
+
+// renameAliases returns node's aliases once it is renamed to title: the old
+// title is added, and any alias that the new title covers is dropped.
+func renameAliases(node Node, title string) []string {
+	keys := []string{normalizeTitle(title)}
+	var aliases []string
+	for _, alias := range append(slices.Clone(node.Aliases), node.Title) {
+		if key := normalizeTitle(alias); !slices.Contains(keys, key) {
+			keys = append(keys, key)
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases
+}
+
+// PreviewRename returns what RenameNode would do, without doing it.
+func (d *Db) PreviewRename(id int, title string) (RenamePlan, error) {
+	var plan RenamePlan
+	err := d.View(func(tx *Tx) error {
+		var err error
+		plan, err = tx.PreviewRename(id, title)
+		return err
+	})
+	return plan, err
+}
+
+// RenameNode gives a node a new title, rewrites the links to it, in its
+// project and from others, and keeps the old title as an alias, all in one transaction.
+func (d *Db) RenameNode(id int, title string) (RenamePlan, error) {
+	var plan RenamePlan
+	err := d.Update(func(tx *Tx) error {
+		var err error
+		plan, err = tx.RenameNode(id, title)
+		return err
+	})
+	return plan, err
+}
+
+func (t *Tx) PreviewRename(id int, title string) (RenamePlan, error) {
+	plan, _, err := t.planRename(id, title)
+	return plan, err
+}
+
+func (t *Tx) RenameNode(id int, title string) (RenamePlan, error) {
+	plan, nodes, err := t.planRename(id, title)
+	if err != nil {
+		return plan, err
+	}
+	for i := range nodes {
+		if err := t.putNode(&nodes[i], true); err != nil {
+			return plan, err
+		}
+	}
+	plan.Node = nodes[0]
+	return plan, nil
+}
+
+func (t *Tx) planRename(id int, title string) (RenamePlan, []Node, error) {
+	node, err := t.GetNode(id)
+	if err != nil {
+		return RenamePlan{}, nil, err
+	}
+	projects, err := t.GetProjects()
+	if err != nil {
+		return RenamePlan{}, nil, err
+	}
+	nodes, err := t.GetNodes()
+	if err != nil {
+		return RenamePlan{}, nil, err
+	}
+	return planRename(projects, nodes, node, title)
+}
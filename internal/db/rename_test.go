@@ -0,0 +1,126 @@
+package db
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func renameFixture() ([]Project, []Node) {
+	projects := []Project{{ID: 1, Name: "P"}, {ID: 2, Name: "Other"}}
+	nodes := []Node{
+		{ID: 1, ProjectID: 1, Title: "A", Content: "me [[A]]"},
+		{ID: 2, ProjectID: 1, Title: "B", Content: "see [[a]], [[A|shown]] and [[A#Head]]"},
+		{ID: 3, ProjectID: 1, Title: "C", Content: "no links"},
+		{ID: 4, ProjectID: 2, Title: "O", Content: "from afar [[P/A]]"},
+		{ID: 5, ProjectID: 2, Title: "A", Content: "a namesake, linked as [[A]]"},
+	}
+	return projects, nodes
+}
+
+func TestPlanRenameRewritesLinks(t *testing.T) {
+	projects, nodes := renameFixture()
+	plan, changed, err := planRename(projects, nodes, nodes[0], "Alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{
+		1: "me [[Alpha]]",
+		2: "see [[Alpha]], [[Alpha|shown]] and [[Alpha#Head]]",
+		4: "from afar [[P/Alpha]]",
+	}
+	if len(changed) != len(want) {
+		t.Errorf("changed %+v", changed)
+	}
+	for _, node := range changed {
+		if node.Content != want[node.ID] {
+			t.Errorf("node %d = %q, want %q", node.ID, node.Content, want[node.ID])
+		}
+	}
+	if plan.Node.Title != "Alpha" || !slices.Equal(plan.Node.Aliases, []string{"A"}) {
+		t.Errorf("renamed node = %+v", plan.Node)
+	}
+	links := 0
+	for _, change := range plan.Changes {
+		links += change.Links
+	}
+	if len(plan.Changes) != 3 || links != 5 {
+		t.Errorf("plan.Changes = %+v", plan.Changes)
+	}
+}
+
+func TestPlanRenameAliases(t *testing.T) {
+	projects, nodes := renameFixture()
+	node := nodes[0]
+	node.Aliases = []string{"Alpha", "Old"}
+
+	// The new title covers an alias, and the old one becomes one.
+	plan, _, err := planRename(projects, nodes, node, "alpha")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(plan.Node.Aliases, []string{"Old", "A"}) {
+		t.Errorf("aliases = %q", plan.Node.Aliases)
+	}
+
+	// Changing only the case needs no alias and rewrites nothing.
+	plan, changed, err := planRename(projects, nodes, nodes[0], "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Node.Aliases) != 0 || len(changed) != 1 || len(plan.Changes) != 0 {
+		t.Errorf("case change: plan %+v, changed %+v", plan, changed)
+	}
+}
+
+func TestPlanRenameDuplicates(t *testing.T) {
+	projects, nodes := renameFixture()
+
+	var dup *DuplicateTitleError
+	if _, _, err := planRename(projects, nodes, nodes[0], " b "); !errors.As(err, &dup) || dup.Existing.ID != 2 {
+		t.Errorf("rename to a sibling's title = %v", err)
+	}
+	// Another project's titles do not count.
+	if _, _, err := planRename(projects, nodes, nodes[0], "O"); err != nil {
+		t.Errorf("rename to a title in another project = %v", err)
+	}
+	if _, _, err := planRename(projects, nodes, nodes[0], "  "); err == nil {
+		t.Error("rename to a blank title succeeded")
+	}
+
+	// A node sharing its title with an older one leaves the old title to
+	// it, along with the links.
+	nodes = append(nodes, Node{ID: 6, ProjectID: 1, Title: "A", Content: "younger"})
+	plan, changed, err := planRename(projects, nodes, nodes[5], "Younger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Node.Aliases) != 0 || len(changed) != 1 {
+		t.Errorf("rename of a duplicate: plan %+v, changed %+v", plan, changed)
+	}
+}
+
+func TestRenameNode(t *testing.T) {
+	for name, s := range map[string]Store{"db": newTestDb(t, nil), "mem": NewMemStore(nil)} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.AddProject(Project{Name: "P"}); err != nil {
+				t.Fatal(err)
+			}
+			a := mustNode(t, s, 1, "A", "")
+			b := mustNode(t, s, 1, "B", "see [[A]]")
+			if _, err := s.RenameNode(a.ID, "Alpha"); err != nil {
+				t.Fatal(err)
+			}
+			if b, err := s.GetNode(b.ID); err != nil || b.Content != "see [[Alpha]]" {
+				t.Errorf("linking node = %+v, %v", b, err)
+			}
+			// The old title still finds the node.
+			if node, err := s.GetNodeByTitle("A", 1); err != nil || node.ID != a.ID {
+				t.Errorf("GetNodeByTitle(old title) = %+v, %v", node, err)
+			}
+			if _, err := s.RenameNode(a.ID, "B"); err == nil {
+				t.Error("RenameNode to a taken title succeeded")
+			}
+		})
+	}
+}
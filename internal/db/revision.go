@@ -0,0 +1,294 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Every save appends a revision to a per-node bucket:
+//
+//	revisions/<nodeID>/<revisionID> -> JSON Revision
+//
+// Revision IDs come from the nested bucket's sequence so they keep
+// increasing even after old revisions are pruned.
+
+type Revision struct {
+	ID      int
+	NodeID  int
+	Time    time.Time
+	Title   string
+	Content string
+}
+
+// revisionPath is the path of a node's revision bucket.
+func revisionPath(nodeID int) string {
+	return fmt.Sprintf("revisions/%d", nodeID)
+}
+
+func (t *Tx) revisionBucket(nodeID int, create bool) (*bbolt.Bucket, error) {
+	root, err := t.bucket("revisions")
+	if err != nil {
+		return nil, err
+	}
+	if !create {
+		return root.Bucket(itob(nodeID)), nil
+	}
+	return root.CreateBucketIfNotExists(itob(nodeID))
+}
+
+// appendRevision records the current state of node unless it is identical to
+// the latest revision, then applies the retention policy.
+func (t *Tx) appendRevision(node Node) error {
+	b, err := t.revisionBucket(node.ID, true)
+	if err != nil {
+		return err
+	}
+
+	if k, v := b.Cursor().Last(); v != nil {
+		var last Revision
+		if err := t.decodeRecord(revisionPath(node.ID), k, v, &last); err != nil {
+			return err
+		}
+		if last.Title == node.Title && last.Content == node.Content {
+			return nil
+		}
+	}
+
+	id, err := nextID(b)
+	if err != nil {
+		return err
+	}
+	err = t.putRecord(b, revisionPath(node.ID), itob(id), Revision{
+		ID:      id,
+		NodeID:  node.ID,
+		Time:    time.Now(),
+		Title:   node.Title,
+		Content: node.Content,
+	})
+	if err != nil {
+		return err
+	}
+	return t.pruneRevisions(b, node.ID)
+}
+
+// pruneRevisions drops revisions beyond the configured count or age.
+func (t *Tx) pruneRevisions(b *bbolt.Bucket, nodeID int) error {
+	var keys [][]byte
+	var revisions []Revision
+	err := b.ForEach(func(k, v []byte) error {
+		var rev Revision
+		if err := t.decodeRecord(revisionPath(nodeID), k, v, &rev); err != nil {
+			return err
+		}
+		keys = append(keys, append([]byte(nil), k...))
+		revisions = append(revisions, rev)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys[:expiredRevisions(revisions, t.opts, time.Now())] {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expiredRevisions returns how many of revisions, oldest first, fall outside
+// the retention policy in opts. The newest revision is always kept.
+func expiredRevisions(revisions []Revision, opts Options, now time.Time) int {
+	n := 0
+	for ; len(revisions)-n > 1; n++ {
+		if opts.RevisionLimit > 0 && len(revisions)-n > opts.RevisionLimit {
+			continue
+		}
+		if opts.RevisionMaxAge <= 0 || !revisions[n].Time.Before(now.Add(-opts.RevisionMaxAge)) {
+			break
+		}
+	}
+	return n
+}
+
+// deleteRevisions removes a node's history and returns how many revisions
+// it held.
+func (t *Tx) deleteRevisions(nodeID int) (int, error) {
+	root, err := t.bucket("revisions")
+	if err != nil {
+		return 0, err
+	}
+	b := root.Bucket(itob(nodeID))
+	if b == nil {
+		return 0, nil
+	}
+	n := countKeys(b)
+	return n, root.DeleteBucket(itob(nodeID))
+}
+
+// GetRevisions returns a node's revisions, newest first.
+func (d *Db) GetRevisions(nodeID int) ([]Revision, error) {
+	var revisions []Revision
+	err := d.View(func(tx *Tx) error {
+		var err error
+		revisions, err = tx.GetRevisions(nodeID)
+		return err
+	})
+	return revisions, err
+}
+
+func (d *Db) GetRevision(nodeID, revisionID int) (Revision, error) {
+	var revision Revision
+	err := d.View(func(tx *Tx) error {
+		var err error
+		revision, err = tx.GetRevision(nodeID, revisionID)
+		return err
+	})
+	return revision, err
+}
+
+// RestoreRevision makes an earlier revision the current version of a node.
+// The restore is itself recorded as a new revision.
+func (d *Db) RestoreRevision(nodeID, revisionID int) (Node, error) {
+	var node Node
+	err := d.Update(func(tx *Tx) error {
+		var err error
+		node, err = tx.RestoreRevision(nodeID, revisionID)
+		return err
+	})
+	return node, err
+}
+
+func (t *Tx) GetRevisions(nodeID int) ([]Revision, error) {
+	b, err := t.revisionBucket(nodeID, false)
+	if err != nil || b == nil {
+		return nil, err
+	}
+
+	var revisions []Revision
+	c := b.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		var rev Revision
+		if err := t.decodeRecord(revisionPath(nodeID), k, v, &rev); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, nil
+}
+
+func (t *Tx) GetRevision(nodeID, revisionID int) (Revision, error) {
+	var rev Revision
+
+	b, err := t.revisionBucket(nodeID, false)
+	if err != nil {
+		return rev, err
+	}
+	if b == nil {
+		return rev, fmt.Errorf("revision not found")
+	}
+	v := b.Get(itob(revisionID))
+	if v == nil {
+		return rev, fmt.Errorf("revision not found")
+	}
+	err = t.decodeRecord(revisionPath(nodeID), itob(revisionID), v, &rev)
+	return rev, err
+}
+
+func (t *Tx) RestoreRevision(nodeID, revisionID int) (Node, error) {
+	rev, err := t.GetRevision(nodeID, revisionID)
+	if err != nil {
+		return Node{}, err
+	}
+	node, err := t.GetNode(nodeID)
+	if err != nil {
+		return Node{}, err
+	}
+	node.Title = rev.Title
+	node.Content = rev.Content
+	if err := t.UpdateNode(&node); err != nil {
+		return Node{}, err
+	}
+	return node, nil
+}
+
+// migrateSeedRevisions records the current state of every node as its first
+// revision so that history starts from the upgrade rather than being empty.
+func migrateSeedRevisions(tx *Tx) error {
+	if _, err := tx.tx.CreateBucketIfNotExists([]byte("revisions")); err != nil {
+		return err
+	}
+	nodes, err := tx.GetNodes()
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := tx.appendRevision(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateTimestamps backfills node and project timestamps. Nodes take them
+// from their revision history; projects span the nodes they contain.
+func migrateTimestamps(tx *Tx) error {
+	nodesBucket, err := tx.bucket("nodes")
+	if err != nil {
+		return err
+	}
+	projectsBucket, err := tx.bucket("projects")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	type span struct{ first, last time.Time }
+	spans := make(map[int]span)
+
+	nodes, err := tx.GetNodes()
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		revisions, err := tx.GetRevisions(node.ID)
+		if err != nil {
+			return err
+		}
+		node.Created, node.Modified = now, now
+		if len(revisions) > 0 {
+			node.Created = revisions[len(revisions)-1].Time
+			node.Modified = revisions[0].Time
+		}
+
+		if err := tx.putRecord(nodesBucket, "nodes", itob(node.ID), node); err != nil {
+			return err
+		}
+
+		s, ok := spans[node.ProjectID]
+		if !ok || node.Created.Before(s.first) {
+			s.first = node.Created
+		}
+		if node.Modified.After(s.last) {
+			s.last = node.Modified
+		}
+		spans[node.ProjectID] = s
+	}
+
+	projects, err := tx.GetProjects()
+	if err != nil {
+		return err
+	}
+	for _, project := range projects {
+		project.Created, project.Modified = now, now
+		if s, ok := spans[project.ID]; ok {
+			project.Created, project.Modified = s.first, s.last
+		}
+		if err := tx.putRecord(projectsBucket, "projects", itob(project.ID), project); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMigrateTimestamps(t *testing.T) {
+	d := newTestDb(t, nil)
+	for _, name := range []string{"P", "Empty"} {
+		if err := d.AddProject(Project{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := mustNode(t, d, 1, "A", "a")
+	b := mustNode(t, d, 1, "B", "b")
+	c := mustNode(t, d, 1, "C", "no history")
+
+	day := func(n int) time.Time { return time.Date(2020, 1, n, 0, 0, 0, 0, time.UTC) }
+	history := map[int][]time.Time{
+		a.ID: {day(3), day(5), day(9)},
+		b.ID: {day(2), day(4)},
+	}
+
+	before := time.Now()
+	err := d.Update(func(tx *Tx) error {
+		root, err := tx.bucket("revisions")
+		if err != nil {
+			return err
+		}
+		if err := root.DeleteBucket(itob(c.ID)); err != nil {
+			return err
+		}
+		for id, times := range history {
+			if err := root.DeleteBucket(itob(id)); err != nil {
+				return err
+			}
+			rb, err := tx.revisionBucket(id, true)
+			if err != nil {
+				return err
+			}
+			for i, when := range times {
+				rev := Revision{ID: i + 1, NodeID: id, Time: when, Title: "x"}
+				if err := tx.putRecord(rb, revisionPath(id), itob(rev.ID), rev); err != nil {
+					return err
+				}
+			}
+		}
+		return migrateTimestamps(tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, times := range history {
+		node, err := d.GetNode(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !node.Created.Equal(times[0]) || !node.Modified.Equal(times[len(times)-1]) {
+			t.Errorf("%s: created %v, modified %v", node.Title, node.Created, node.Modified)
+		}
+	}
+
+	// Without a history a node is stamped with the time of the migration.
+	node, err := d.GetNode(c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Created.Before(before) || !node.Modified.Equal(node.Created) {
+		t.Errorf("C: created %v, modified %v", node.Created, node.Modified)
+	}
+
+	p, err := d.GetProject(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Created.Equal(day(2)) || !p.Modified.Equal(node.Modified) {
+		t.Errorf("P: created %v, modified %v", p.Created, p.Modified)
+	}
+	empty, err := d.GetProject(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.Created.Before(before) || !empty.Modified.Equal(empty.Created) {
+		t.Errorf("Empty: created %v, modified %v", empty.Created, empty.Modified)
+	}
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pixambi/gbrain/internal/markup"
+)
+
+// The scan functions answer the same queries as the persistent indexes by
+// looking at every node, in ID order. MemStore uses them for everything and
+// Db uses them when it is encrypted, since its indexes would otherwise hold
+// titles, tags and words in plain text.
+
+func scanProjectNodes(nodes []Node, projectID int) []Node {
+	var result []Node
+	for _, node := range nodes {
+		if node.ProjectID == projectID {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+func scanNodeByTitle(nodes []Node, title string, projectID int) (Node, error) {
+	key := normalizeTitle(title)
+	var matches []Node
+	for _, node := range nodes {
+		if node.ProjectID == projectID && slices.Contains(titleKeys(node), key) {
+			matches = append(matches, node)
+		}
+	}
+	if len(matches) == 0 {
+		return Node{}, fmt.Errorf("node not found")
+	}
+	return bestTitleMatch(title, matches), nil
+}
+
+func scanBacklinks(nodes []Node, target Node, project Project) []Backlink {
+	keys := titleKeys(target)
+	var backlinks []Backlink
+	for _, source := range nodes {
+		if source.ID == target.ID {
+			continue
+		}
+		if slices.ContainsFunc(linkTargets(source), func(title string) bool {
+			return linkReaches(title, source.ProjectID, project, keys)
+		}) {
+			backlinks = append(backlinks, newBacklink(source, project, keys))
+		}
+	}
+	return backlinks
+}
+
+func scanSearch(nodes []Node, query string, opts SearchOptions) ([]SearchResult, error) {
+	clauses := parseQuery(query)
+	if len(clauses) == 0 || len(nodes) == 0 {
+		return nil, nil
+	}
+
+	index := make(map[int]map[string][]int, len(nodes))
+	docs := make(map[int]searchDoc, len(nodes))
+	byID := make(map[int]Node, len(nodes))
+	var stats searchStats
+	for _, node := range nodes {
+		terms, length := documentTerms(node)
+		index[node.ID] = terms
+		docs[node.ID] = searchDoc{ProjectID: node.ProjectID, Length: length}
+		byID[node.ID] = node
+		stats.Docs++
+		stats.Tokens += length
+	}
+
+	scores, err := rankSearch(clauses, stats, opts,
+		func(clause queryClause) (postings, error) { return scanClausePostings(index, clause), nil },
+		func(id int) (searchDoc, error) { return docs[id], nil })
+	if err != nil {
+		return nil, err
+	}
+	return collectResults(scores, clauses, opts, func(id int) (Node, error) { return byID[id], nil })
+}
+
+// scanClausePostings is clausePostings over per-node term positions.
+func scanClausePostings(index map[int]map[string][]int, clause queryClause) postings {
+	if clause.prefix {
+		result := make(postings)
+		for id, terms := range index {
+			for term, positions := range terms {
+				if strings.HasPrefix(term, clause.terms[0]) {
+					result[id] = append(result[id], positions...)
+				}
+			}
+			sort.Ints(result[id])
+		}
+		return result
+	}
+
+	perTerm := make([]postings, len(clause.terms))
+	for i, term := range clause.terms {
+		perTerm[i] = make(postings)
+		for id, terms := range index {
+			if positions, ok := terms[term]; ok {
+				perTerm[i][id] = positions
+			}
+		}
+	}
+	return phrasePostings(perTerm)
+}
+
+func scanTags(nodes []Node) []TagCount {
+	counts := make(map[string]int)
+	for _, node := range nodes {
+		for _, tag := range node.AllTags() {
+			counts[tag]++
+		}
+	}
+	var tags []TagCount
+	for _, name := range slices.Sorted(maps.Keys(counts)) {
+		tags = append(tags, TagCount{Name: name, Count: counts[name]})
+	}
+	return tags
+}
+
+func scanNodesByTag(nodes []Node, tag string) []Node {
+	tag = markup.NormalizeTag(tag)
+	var result []Node
+	for _, node := range nodes {
+		if slices.Contains(node.AllTags(), tag) {
+			result = append(result, node)
+		}
+	}
+	sortByTitle(result)
+	return result
+}
+
+// scanNodesByProperty visits the wanted values in the same order as the
+// index lookup so that nodes with equal titles come out in the same order.
+func scanNodesByProperty(nodes []Node, key, value string) []Node {
+	key = markup.NormalizeKey(key)
+	seen := make(map[int]bool)
+	var result []Node
+	for _, want := range markup.ParseValue(value).IndexKeys() {
+		for _, node := range nodes {
+			stored, ok := node.Properties[key]
+			if !ok || seen[node.ID] {
+				continue
+			}
+			for _, v := range stored.IndexKeys() {
+				if string(propertyIndexKey(v)) == string(propertyIndexKey(want)) {
+					seen[node.ID] = true
+					result = append(result, node)
+					break
+				}
+			}
+		}
+	}
+	sortByTitle(result)
+	return result
+}
+
+func scanPropertyKeys(nodes []Node) []string {
+	keys := make(map[string]bool)
+	for _, node := range nodes {
+		for key := range node.Properties {
+			keys[key] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(keys))
+}
@@ -0,0 +1,441 @@
+package db
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/pixambi/gbrain/internal/markup"
+	"go.etcd.io/bbolt"
+)
+
+// The search bucket is an inverted index over node titles and content:
+//
+//	search/terms/<term>/<nodeID> -> JSON token positions
+//	search/docs/<nodeID>         -> JSON searchDoc
+//	search/stats                 -> JSON searchStats
+//
+// Positions are token ordinals rather than byte offsets so that phrase
+// matching only has to look for consecutive numbers.
+
+// BM25 tuning parameters, at their conventional defaults.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// maxTermLength bounds index keys; longer tokens are almost always pasted
+// hashes or URLs and are not worth indexing.
+const maxTermLength = 64
+
+const searchSnippetRadius = 60
+
+type SearchOptions struct {
+	ProjectID int // Restrict results to one project; 0 searches everything
+	Limit     int // Maximum number of results; 0 means no limit
+}
+
+type SearchResult struct {
+	Node       Node
+	Score      float64
+	Snippet    string
+	Highlights [][2]int // Byte ranges within Snippet that matched the query
+}
+
+type searchDoc struct {
+	ProjectID int
+	Length    int
+}
+
+type searchStats struct {
+	Docs   int
+	Tokens int
+}
+
+// documentTerms returns the positions of every term in node. Title tokens
+// come first, followed by a gap so that phrases cannot span the title and
+// the body.
+func documentTerms(node Node) (map[string][]int, int) {
+	terms := make(map[string][]int)
+	pos := 0
+	for _, text := range []string{node.Title, node.Content} {
+		for _, token := range markup.Tokenize(text) {
+			if len(token.Term) <= maxTermLength {
+				terms[token.Term] = append(terms[token.Term], pos)
+			}
+			pos++
+		}
+		pos++
+	}
+	return terms, pos
+}
+
+func (t *Tx) searchBuckets() (root, terms, docs *bbolt.Bucket, err error) {
+	root, err = t.bucket("search")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if terms, err = root.CreateBucketIfNotExists([]byte("terms")); err != nil {
+		return nil, nil, nil, err
+	}
+	if docs, err = root.CreateBucketIfNotExists([]byte("docs")); err != nil {
+		return nil, nil, nil, err
+	}
+	return root, terms, docs, nil
+}
+
+func readSearchStats(root *bbolt.Bucket) (searchStats, error) {
+	var stats searchStats
+	if v := root.Get([]byte("stats")); v != nil {
+		if err := json.Unmarshal(v, &stats); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+func writeSearchStats(root *bbolt.Bucket, stats searchStats) error {
+	buf, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return root.Put([]byte("stats"), buf)
+}
+
+func (t *Tx) indexSearch(node Node) error {
+	root, termsBucket, docs, err := t.searchBuckets()
+	if err != nil {
+		return err
+	}
+
+	terms, length := documentTerms(node)
+	for term, positions := range terms {
+		tb, err := termsBucket.CreateBucketIfNotExists([]byte(term))
+		if err != nil {
+			return err
+		}
+		buf, err := json.Marshal(positions)
+		if err != nil {
+			return err
+		}
+		if err := tb.Put(itob(node.ID), buf); err != nil {
+			return err
+		}
+	}
+
+	buf, err := json.Marshal(searchDoc{ProjectID: node.ProjectID, Length: length})
+	if err != nil {
+		return err
+	}
+	if err := docs.Put(itob(node.ID), buf); err != nil {
+		return err
+	}
+
+	stats, err := readSearchStats(root)
+	if err != nil {
+		return err
+	}
+	stats.Docs++
+	stats.Tokens += length
+	return writeSearchStats(root, stats)
+}
+
+func (t *Tx) unindexSearch(node Node) error {
+	root, termsBucket, docs, err := t.searchBuckets()
+	if err != nil {
+		return err
+	}
+	v := docs.Get(itob(node.ID))
+	if v == nil {
+		return nil
+	}
+	var doc searchDoc
+	if err := json.Unmarshal(v, &doc); err != nil {
+		return err
+	}
+
+	terms, _ := documentTerms(node)
+	for term := range terms {
+		tb := termsBucket.Bucket([]byte(term))
+		if tb == nil {
+			continue
+		}
+		if err := tb.Delete(itob(node.ID)); err != nil {
+			return err
+		}
+		if k, _ := tb.Cursor().First(); k == nil {
+			if err := termsBucket.DeleteBucket([]byte(term)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := docs.Delete(itob(node.ID)); err != nil {
+		return err
+	}
+
+	stats, err := readSearchStats(root)
+	if err != nil {
+		return err
+	}
+	stats.Docs--
+	stats.Tokens -= doc.Length
+	return writeSearchStats(root, stats)
+}
+
+// queryClause is one required part of a query: a single term, a term
+// prefix, or a phrase of several terms.
+type queryClause struct {
+	terms  []string
+	prefix bool
+}
+
+// parseQuery splits a query into clauses. Quoted text is a phrase, a
+// trailing * makes a word a prefix match, and words that tokenize into
+// several terms (such as "bolt-db") are treated as phrases.
+func parseQuery(query string) []queryClause {
+	var clauses []queryClause
+	for i, part := range strings.Split(query, `"`) {
+		if i%2 == 1 {
+			if terms := markup.Terms(part); len(terms) > 0 {
+				clauses = append(clauses, queryClause{terms: terms})
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			prefix := strings.HasSuffix(word, "*")
+			terms := markup.Terms(word)
+			if len(terms) == 0 {
+				continue
+			}
+			clauses = append(clauses, queryClause{terms: terms, prefix: prefix && len(terms) == 1})
+		}
+	}
+	return clauses
+}
+
+// matchesQuery reports whether an indexed term satisfies any clause, for
+// highlighting.
+func matchesQuery(clauses []queryClause, term string) bool {
+	for _, clause := range clauses {
+		for _, t := range clause.terms {
+			if term == t || (clause.prefix && strings.HasPrefix(term, t)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type postings map[int][]int
+
+func readPostings(tb *bbolt.Bucket, into postings) error {
+	return tb.ForEach(func(k, v []byte) error {
+		var positions []int
+		if err := json.Unmarshal(v, &positions); err != nil {
+			return err
+		}
+		id := btoi(k)
+		into[id] = append(into[id], positions...)
+		return nil
+	})
+}
+
+// clausePostings returns, for every node matching clause, the positions at
+// which it matched.
+func clausePostings(terms *bbolt.Bucket, clause queryClause) (postings, error) {
+	if clause.prefix {
+		result := make(postings)
+		prefix := []byte(clause.terms[0])
+		c := terms.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = c.Next() {
+			if err := readPostings(terms.Bucket(k), result); err != nil {
+				return nil, err
+			}
+		}
+		for id := range result {
+			sort.Ints(result[id])
+		}
+		return result, nil
+	}
+
+	perTerm := make([]postings, len(clause.terms))
+	for i, term := range clause.terms {
+		perTerm[i] = make(postings)
+		if tb := terms.Bucket([]byte(term)); tb != nil {
+			if err := readPostings(tb, perTerm[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return phrasePostings(perTerm), nil
+}
+
+// phrasePostings combines the postings of consecutive phrase terms. A
+// phrase matches at position p when term i appears at p+i for every i.
+func phrasePostings(perTerm []postings) postings {
+	if len(perTerm) == 1 {
+		return perTerm[0]
+	}
+	result := make(postings)
+	for id, starts := range perTerm[0] {
+		for _, p := range starts {
+			found := true
+			for i := 1; i < len(perTerm) && found; i++ {
+				found = containsInt(perTerm[i][id], p+i)
+			}
+			if found {
+				result[id] = append(result[id], p)
+			}
+		}
+	}
+	return result
+}
+
+func containsInt(sorted []int, x int) bool {
+	i := sort.SearchInts(sorted, x)
+	return i < len(sorted) && sorted[i] == x
+}
+
+// Search ranks nodes matching every clause of query using BM25.
+func (d *Db) Search(query string, opts SearchOptions) ([]SearchResult, error) {
+	var results []SearchResult
+	err := d.View(func(tx *Tx) error {
+		var err error
+		results, err = tx.Search(query, opts)
+		return err
+	})
+	return results, err
+}
+
+func (t *Tx) Search(query string, opts SearchOptions) ([]SearchResult, error) {
+	if t.encrypted {
+		nodes, err := t.GetNodes()
+		if err != nil {
+			return nil, err
+		}
+		return scanSearch(nodes, query, opts)
+	}
+	clauses := parseQuery(query)
+	if len(clauses) == 0 {
+		return nil, nil
+	}
+
+	root, err := t.bucket("search")
+	if err != nil {
+		return nil, err
+	}
+	terms, docs := root.Bucket([]byte("terms")), root.Bucket([]byte("docs"))
+	if terms == nil || docs == nil {
+		return nil, nil
+	}
+	stats, err := readSearchStats(root)
+	if err != nil || stats.Docs == 0 {
+		return nil, err
+	}
+	scores, err := rankSearch(clauses, stats, opts,
+		func(clause queryClause) (postings, error) { return clausePostings(terms, clause) },
+		func(id int) (searchDoc, error) {
+			var doc searchDoc
+			if v := docs.Get(itob(id)); v != nil {
+				if err := json.Unmarshal(v, &doc); err != nil {
+					return doc, err
+				}
+			}
+			return doc, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return collectResults(scores, clauses, opts, t.GetNode)
+}
+
+// rankSearch scores every document matching all clauses with BM25. matched
+// returns the postings for a clause and doc looks up a document's length and
+// project, so that each store can supply them from its own index.
+func rankSearch(clauses []queryClause, stats searchStats, opts SearchOptions,
+	matched func(queryClause) (postings, error), doc func(id int) (searchDoc, error)) (map[int]float64, error) {
+	avgLength := float64(stats.Tokens) / float64(stats.Docs)
+
+	scores := make(map[int]float64)
+	for i, clause := range clauses {
+		matches, err := matched(clause)
+		if err != nil {
+			return nil, err
+		}
+		df := float64(len(matches))
+		idf := math.Log(1 + (float64(stats.Docs)-df+0.5)/(df+0.5))
+
+		next := make(map[int]float64)
+		for id, positions := range matches {
+			score, ok := scores[id]
+			if i > 0 && !ok {
+				continue
+			}
+			d, err := doc(id)
+			if err != nil {
+				return nil, err
+			}
+			if opts.ProjectID != 0 && d.ProjectID != opts.ProjectID {
+				continue
+			}
+			tf := float64(len(positions))
+			norm := 1 - bm25B + bm25B*float64(d.Length)/avgLength
+			next[id] = score + idf*tf*(bm25K1+1)/(tf+bm25K1*norm)
+		}
+		scores = next
+	}
+	return scores, nil
+}
+
+// collectResults loads the scored nodes, best first, and cuts the list to
+// the requested limit.
+func collectResults(scores map[int]float64, clauses []queryClause, opts SearchOptions, getNode func(int) (Node, error)) ([]SearchResult, error) {
+	results := make([]SearchResult, 0, len(scores))
+	for id, score := range scores {
+		node, err := getNode(id)
+		if err != nil {
+			return nil, err
+		}
+		result := SearchResult{Node: node, Score: score}
+		result.Snippet, result.Highlights = searchSnippet(node.Content, clauses)
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Node.ID < results[j].Node.ID
+	})
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+	return results, nil
+}
+
+// searchSnippet returns the text around the first match in content and the
+// ranges within it to highlight. Matches that are only in the title yield
+// the start of the content.
+func searchSnippet(content string, clauses []queryClause) (string, [][2]int) {
+	start, end := 0, 0
+	for _, token := range markup.Tokenize(content) {
+		if matchesQuery(clauses, token.Term) {
+			start, end = token.Start, token.End
+			break
+		}
+	}
+	radius := searchSnippetRadius
+	if end == 0 {
+		radius *= 2
+	}
+	snippet := markup.Snippet(content, start, end, radius)
+
+	var highlights [][2]int
+	for _, token := range markup.Tokenize(snippet) {
+		if matchesQuery(clauses, token.Term) {
+			highlights = append(highlights, [2]int{token.Start, token.End})
+		}
+	}
+	return snippet, highlights
+}
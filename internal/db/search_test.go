@@ -0,0 +1,196 @@
+package db
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// searchStores returns an empty store of every kind, with an encrypted
+// database standing in for the scanning search.
+func searchStores(t *testing.T) map[string]Store {
+	t.Helper()
+	encrypted := newTestDb(t, nil)
+	if err := encrypted.EnableEncryption([]byte("pw")); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Store{
+		"db":        newTestDb(t, nil),
+		"encrypted": encrypted,
+		"mem":       NewMemStore(nil),
+		"markdown":  newTestMarkdownStore(t, t.TempDir()),
+	}
+}
+
+// searchTitles returns the titles of the results of query, best first.
+func searchTitles(t *testing.T, s Store, query string, opts SearchOptions) []string {
+	t.Helper()
+	results, err := s.Search(query, opts)
+	if err != nil {
+		t.Fatalf("Search(%q): %v", query, err)
+	}
+	var titles []string
+	for _, result := range results {
+		titles = append(titles, result.Node.Title)
+	}
+	return titles
+}
+
+func TestSearch(t *testing.T) {
+	long := strings.Repeat("x", maxTermLength)
+	for name, s := range searchStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, project := range []string{"P", "Q"} {
+				if err := s.AddProject(Project{Name: project}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, node := range []Node{
+				{ProjectID: 1, Title: "Bolt", Content: "bolt bolt bolt"},
+				{ProjectID: 1, Title: "Notes", Content: "the bolt is mentioned once among a good many other words here"},
+				{ProjectID: 1, Title: "Fox", Content: "the quick brown fox jumps; a broken fence"},
+				{ProjectID: 1, Title: "Alpha", Content: "beta"},
+				{ProjectID: 1, Title: "Hyphen", Content: "we use bolt-db here, " + long + " and " + long + "y"},
+				{ProjectID: 2, Title: "Elsewhere", Content: "bolt in another project"},
+			} {
+				if err := s.AddNode(node); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tests := []struct {
+				query string
+				opts  SearchOptions
+				want  []string
+			}{
+				// More occurrences in a shorter note rank higher.
+				{"bolt", SearchOptions{}, []string{"Bolt", "Elsewhere", "Hyphen", "Notes"}},
+				{"bolt", SearchOptions{ProjectID: 2}, []string{"Elsewhere"}},
+				{"bolt", SearchOptions{Limit: 1}, []string{"Bolt"}},
+				{"BOLT another", SearchOptions{}, []string{"Elsewhere"}},
+				{`"brown fox"`, SearchOptions{}, []string{"Fox"}},
+				{`"fox brown"`, SearchOptions{}, nil},
+				{"bro*", SearchOptions{}, []string{"Fox"}},
+				{"bro", SearchOptions{}, nil},
+				{"bolt-db", SearchOptions{}, []string{"Hyphen"}},
+				{"db-bolt", SearchOptions{}, nil},
+				// Phrases do not run from the title into the content.
+				{`"alpha beta"`, SearchOptions{}, nil},
+				{"alpha beta", SearchOptions{}, []string{"Alpha"}},
+				{long, SearchOptions{}, []string{"Hyphen"}},
+				{long + "y", SearchOptions{}, nil},
+				{`""  *`, SearchOptions{}, nil},
+			}
+			for _, tt := range tests {
+				if got := searchTitles(t, s, tt.query, tt.opts); !slices.Equal(got, tt.want) {
+					t.Errorf("Search(%q, %+v) = %q, want %q", tt.query, tt.opts, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	for name, s := range searchStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.AddProject(Project{Name: "P"}); err != nil {
+				t.Fatal(err)
+			}
+			content := strings.Repeat("filler words go here. ", 20) + "Then the Fox and the foxes ran. " + strings.Repeat("more text. ", 20)
+			mustNode(t, s, 1, "Note", content)
+
+			results, err := s.Search("fox*", SearchOptions{})
+			if err != nil || len(results) != 1 {
+				t.Fatalf("Search = %+v, %v", results, err)
+			}
+			result := results[0]
+			if len(result.Snippet) >= len(content) || !strings.Contains(result.Snippet, "Then the Fox and the foxes ran") {
+				t.Errorf("snippet = %q", result.Snippet)
+			}
+			var highlighted []string
+			for _, h := range result.Highlights {
+				highlighted = append(highlighted, result.Snippet[h[0]:h[1]])
+			}
+			if want := []string{"Fox", "foxes"}; !slices.Equal(highlighted, want) {
+				t.Errorf("highlighted %q, want %q", highlighted, want)
+			}
+		})
+	}
+}
+
+func TestSearchIndexFollowsChanges(t *testing.T) {
+	d := newTestDb(t, nil)
+	if err := d.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	node := mustNode(t, d, 1, "Note", "apple")
+	mustNode(t, d, 1, "Other", "apple pie")
+	check := func(when, query string, want ...string) {
+		t.Helper()
+		if got := searchTitles(t, d, query, SearchOptions{}); !slices.Equal(got, want) {
+			t.Errorf("%s: Search(%q) = %q, want %q", when, query, got, want)
+		}
+	}
+
+	node.Content = "banana"
+	if err := d.UpdateNode(node); err != nil {
+		t.Fatal(err)
+	}
+	check("after update", "apple", "Other")
+	check("after update", "banana", "Note")
+
+	if err := d.DeleteNode(node.ID); err != nil {
+		t.Fatal(err)
+	}
+	check("after delete", "banana")
+	trash, err := d.GetTrash()
+	if err != nil || len(trash) != 1 {
+		t.Fatalf("GetTrash = %+v, %v", trash, err)
+	}
+	if err := d.RestoreTrash(trash[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	check("after restore", "banana", "Note")
+
+	if err := d.EnableEncryption([]byte("pw")); err != nil {
+		t.Fatal(err)
+	}
+	check("encrypted", "banana", "Note")
+	node, err = d.GetNode(node.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Content = "cherry"
+	if err := d.UpdateNode(node); err != nil {
+		t.Fatal(err)
+	}
+	check("encrypted after update", "cherry", "Note")
+	if err := d.DisableEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	check("decrypted", "cherry", "Note")
+	check("decrypted", "banana")
+
+	// The index kept up ranks as one built from scratch does.
+	queries := []string{"apple", "pie", "cherry", "note", "other"}
+	before := make(map[string][]SearchResult)
+	for _, query := range queries {
+		if before[query], err = d.Search(query, SearchOptions{}); err != nil || len(before[query]) == 0 {
+			t.Fatalf("Search(%q) = %+v, %v", query, before[query], err)
+		}
+	}
+	if err := d.RebuildIndexes(); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range queries {
+		after, err := d.Search(query, SearchOptions{})
+		if err != nil || len(after) != len(before[query]) {
+			t.Fatalf("Search(%q) after rebuilding = %+v, %v", query, after, err)
+		}
+		for i := range after {
+			if after[i].Node.ID != before[query][i].Node.ID || after[i].Score != before[query][i].Score {
+				t.Errorf("Search(%q) result %d = %+v before rebuilding, %+v after", query, i, before[query][i], after[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package db
+
+import "time"
+
+// Store is everything the application needs from a note store. *Db keeps
+// notes in a bbolt file, *MarkdownStore in a directory of markdown files and
+// *MemStore in memory. All follow the same rules for IDs, timestamps, title
+// matching, search ranking, revisions, the trash and attachments, so callers
+// can use any of them.
+type Store interface {
+	ProjectStore
+	NodeStore
+	QueryStore
+	RevisionStore
+	TrashStore
+	AttachmentStore
+	PreferenceStore
+	Close() error
+}
+
+type ProjectStore interface {
+	GetProjects() ([]Project, error)
+	GetProject(id int) (Project, error)
+	AddProject(project Project) error
+	UpdateProject(project Project) error
+	DeleteProject(id int) (DeleteReport, error)
+}
+
+type NodeStore interface {
+	GetNodes() ([]Node, error)
+	GetNodesByProjectID(projectID int) ([]Node, error)
+	GetNode(id int) (Node, error)
+	GetNodeByTitle(title string, projectID int) (Node, error)
+	ResolveLink(title string, projectID int) (Node, error)
+	AddNode(node Node) error
+	UpdateNode(node Node) error
+	PreviewRename(id int, title string) (RenamePlan, error)
+	RenameNode(id int, title string) (RenamePlan, error)
+	MergeNodes(intoID, fromID int) (Node, error)
+	PreviewMove(ids []int, projectID int, opts MoveOptions) (MovePlan, error)
+	MoveNodes(ids []int, projectID int, opts MoveOptions) (MovePlan, error)
+	DeleteNode(id int) error
+}
+
+// QueryStore covers lookups that go through the derived indexes.
+type QueryStore interface {
+	GetBacklinks(nodeID int) ([]Backlink, error)
+	Search(query string, opts SearchOptions) ([]SearchResult, error)
+	GetTags() ([]TagCount, error)
+	GetNodesByTag(tag string) ([]Node, error)
+	GetNodesByProperty(key, value string) ([]Node, error)
+	GetPropertyKeys() ([]string, error)
+}
+
+type RevisionStore interface {
+	GetRevisions(nodeID int) ([]Revision, error)
+	GetRevision(nodeID, revisionID int) (Revision, error)
+	RestoreRevision(nodeID, revisionID int) (Node, error)
+}
+
+type TrashStore interface {
+	GetTrash() ([]TrashItem, error)
+	RestoreTrash(id int) error
+	PurgeTrash(id int) (DeleteReport, error)
+	PurgeExpiredTrash(maxAge time.Duration) (DeleteReport, error)
+}
+
+type AttachmentStore interface {
+	AddAttachment(nodeID int, name string, data []byte) (Attachment, error)
+	GetAttachments(nodeID int) ([]Attachment, error)
+	GetAttachmentData(nodeID int, name string) (Attachment, []byte, error)
+	RemoveAttachment(nodeID int, name string) error
+}
+
+type PreferenceStore interface {
+	GetPreference(key string) (string, error)
+	SetPreference(key, value string) error
+}
+
+// Locker is implemented by stores that can be encrypted at rest. While
+// locked, every read and write fails with ErrLocked.
+type Locker interface {
+	Encrypted() bool
+	Locked() bool
+	Unlock(passphrase []byte) error
+	Lock()
+}
+
+// Backuper is implemented by stores that can be backed up while in use.
+type Backuper interface {
+	Backup(dir string, keep int) (string, error)
+}
+
+var (
+	_ Backuper = (*Db)(nil)
+	_ Locker   = (*Db)(nil)
+	_ Store    = (*Db)(nil)
+	_ Store    = (*MemStore)(nil)
+	_ Store    = (*MarkdownStore)(nil)
+)
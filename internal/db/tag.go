@@ -0,0 +1,130 @@
+package db
+
+import "github.com/pixambi/gbrain/internal/markup"
+
+// Tags are indexed across every project:
+//
+//	tags/<tag>/<nodeID> -> ""
+
+type TagCount struct {
+	Name  string
+	Count int
+}
+
+// AllTags returns the node's explicit tags merged with the #tags in its
+// content, normalized and sorted.
+func (n Node) AllTags() []string {
+	var inline []string
+	for _, tag := range markup.ParseTags(n.Content) {
+		inline = append(inline, tag.Name)
+	}
+	return markup.MergeTags(n.Tags, inline)
+}
+
+func (t *Tx) indexTags(node Node) error {
+	root, err := t.bucket("tags")
+	if err != nil {
+		return err
+	}
+	for _, tag := range node.AllTags() {
+		tb, err := root.CreateBucketIfNotExists([]byte(tag))
+		if err != nil {
+			return err
+		}
+		if err := tb.Put(itob(node.ID), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Tx) unindexTags(node Node) error {
+	root, err := t.bucket("tags")
+	if err != nil {
+		return err
+	}
+	for _, tag := range node.AllTags() {
+		tb := root.Bucket([]byte(tag))
+		if tb == nil {
+			continue
+		}
+		if err := tb.Delete(itob(node.ID)); err != nil {
+			return err
+		}
+		if k, _ := tb.Cursor().First(); k == nil {
+			if err := root.DeleteBucket([]byte(tag)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetTags returns every tag in use with the number of nodes carrying it,
+// in alphabetical order.
+func (d *Db) GetTags() ([]TagCount, error) {
+	var tags []TagCount
+	err := d.View(func(tx *Tx) error {
+		var err error
+		tags, err = tx.GetTags()
+		return err
+	})
+	return tags, err
+}
+
+// GetNodesByTag returns the nodes carrying a tag across every project,
+// sorted by title.
+func (d *Db) GetNodesByTag(tag string) ([]Node, error) {
+	var nodes []Node
+	err := d.View(func(tx *Tx) error {
+		var err error
+		nodes, err = tx.GetNodesByTag(tag)
+		return err
+	})
+	return nodes, err
+}
+
+func (t *Tx) GetTags() ([]TagCount, error) {
+	if t.encrypted {
+		nodes, err := t.GetNodes()
+		return scanTags(nodes), err
+	}
+	root, err := t.bucket("tags")
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []TagCount
+	c := root.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		tags = append(tags, TagCount{Name: string(k), Count: countKeys(root.Bucket(k))})
+	}
+	return tags, nil
+}
+
+func (t *Tx) GetNodesByTag(tag string) ([]Node, error) {
+	if t.encrypted {
+		nodes, err := t.GetNodes()
+		return scanNodesByTag(nodes, tag), err
+	}
+	root, err := t.bucket("tags")
+	if err != nil {
+		return nil, err
+	}
+	tb := root.Bucket([]byte(markup.NormalizeTag(tag)))
+	if tb == nil {
+		return nil, nil
+	}
+
+	var nodes []Node
+	err = tb.ForEach(func(k, _ []byte) error {
+		node, err := t.GetNode(btoi(k))
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+		return nil
+	})
+	sortByTitle(nodes)
+	return nodes, err
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAllTags(t *testing.T) {
+	node := Node{Tags: []string{"Zeta", "#Go"}, Content: "# Heading\nabout #go and #db/bolt, issue #7"}
+	if got, want := node.AllTags(), []string{"db/bolt", "go", "zeta"}; !slices.Equal(got, want) {
+		t.Errorf("AllTags = %q, want %q", got, want)
+	}
+}
+
+func TestTags(t *testing.T) {
+	for name, s := range searchStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, project := range []string{"P", "Q"} {
+				if err := s.AddProject(Project{Name: project}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.AddNode(Node{ProjectID: 1, Title: "A", Tags: []string{"Go"}, Content: "#db notes"}); err != nil {
+				t.Fatal(err)
+			}
+			mustNode(t, s, 1, "B", "#go and #GO again")
+			c := mustNode(t, s, 2, "C", "#db")
+			mustNode(t, s, 2, "D", "no tags, just # a heading")
+
+			wantTags := func(want ...TagCount) {
+				t.Helper()
+				tags, err := s.GetTags()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(tags, want) {
+					t.Errorf("GetTags = %+v, want %+v", tags, want)
+				}
+			}
+			wantTagged := func(tag string, want ...string) {
+				t.Helper()
+				nodes, err := s.GetNodesByTag(tag)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, node := range nodes {
+					got = append(got, node.Title)
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("GetNodesByTag(%q) = %q, want %q", tag, got, want)
+				}
+			}
+
+			wantTags(TagCount{"db", 2}, TagCount{"go", 2})
+			wantTagged("#Go", "A", "B")
+			wantTagged("db", "A", "C")
+			wantTagged("missing")
+
+			// Dropping the explicit tag leaves the inline one.
+			a, err := s.GetNodeByTitle("A", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.Tags = nil
+			if err := s.UpdateNode(a); err != nil {
+				t.Fatal(err)
+			}
+			wantTags(TagCount{"db", 2}, TagCount{"go", 1})
+			wantTagged("go", "B")
+
+			// A tag nothing carries any more is gone altogether.
+			if err := s.DeleteNode(c.ID); err != nil {
+				t.Fatal(err)
+			}
+			if a, err = s.GetNode(a.ID); err != nil {
+				t.Fatal(err)
+			}
+			a.Content = "plain"
+			if err := s.UpdateNode(a); err != nil {
+				t.Fatal(err)
+			}
+			wantTags(TagCount{"go", 1})
+			wantTagged("db")
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// Deleted nodes and projects are kept in the trash bucket until they are
+// restored or purged:
+//
+//	trash/<trashID> -> JSON TrashItem
+//
+// Revisions and attachments of trashed nodes are left in place until the
+// item is purged.
+
+type TrashKind int
+
+const (
+	TrashNode TrashKind = iota
+	TrashProject
+)
+
+type TrashItem struct {
+	ID        int
+	DeletedAt time.Time
+	Kind      TrashKind
+	// Project is the trashed project, or for a trashed node the project it
+	// belonged to.
+	Project Project
+	Nodes   []Node
+}
+
+func (t *Tx) addTrash(item TrashItem) (int, error) {
+	b, err := t.bucket("trash")
+	if err != nil {
+		return 0, err
+	}
+	id, err := nextID(b)
+	if err != nil {
+		return 0, err
+	}
+	item.ID = id
+	item.DeletedAt = time.Now()
+
+	return id, t.putRecord(b, "trash", itob(id), item)
+}
+
+// GetTrash returns the contents of the trash, most recently deleted first.
+func (d *Db) GetTrash() ([]TrashItem, error) {
+	var items []TrashItem
+	err := d.View(func(tx *Tx) error {
+		var err error
+		items, err = tx.GetTrash()
+		return err
+	})
+	return items, err
+}
+
+// RestoreTrash puts a trashed node or project back where it came from. A
+// node whose title has been taken in the meantime gets a number added.
+func (d *Db) RestoreTrash(id int) error {
+	return d.Update(func(tx *Tx) error {
+		return tx.RestoreTrash(id)
+	})
+}
+
+// PurgeTrash permanently deletes a trash item and everything stored against
+// its nodes.
+func (d *Db) PurgeTrash(id int) (DeleteReport, error) {
+	var report DeleteReport
+	err := d.Update(func(tx *Tx) error {
+		var err error
+		report, err = tx.PurgeTrash(id)
+		return err
+	})
+	return report, err
+}
+
+// PurgeExpiredTrash purges every item deleted more than maxAge ago.
+func (d *Db) PurgeExpiredTrash(maxAge time.Duration) (DeleteReport, error) {
+	var report DeleteReport
+	err := d.Update(func(tx *Tx) error {
+		var err error
+		report, err = tx.PurgeExpiredTrash(maxAge)
+		return err
+	})
+	return report, err
+}
+
+func (t *Tx) GetTrash() ([]TrashItem, error) {
+	b, err := t.bucket("trash")
+	if err != nil {
+		return nil, err
+	}
+
+	var items []TrashItem
+	c := b.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		var item TrashItem
+		if err := t.decodeRecord("trash", k, v, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (t *Tx) getTrashItem(id int) (TrashItem, error) {
+	var item TrashItem
+
+	b, err := t.bucket("trash")
+	if err != nil {
+		return item, err
+	}
+	v := b.Get(itob(id))
+	if v == nil {
+		return item, fmt.Errorf("trash item not found")
+	}
+	err = t.decodeRecord("trash", itob(id), v, &item)
+	return item, err
+}
+
+func (t *Tx) RestoreTrash(id int) error {
+	item, err := t.getTrashItem(id)
+	if err != nil {
+		return err
+	}
+
+	switch item.Kind {
+	case TrashProject:
+		if err := t.putProject(&item.Project, false); err != nil {
+			return err
+		}
+	case TrashNode:
+		if _, err := t.GetProject(item.Project.ID); err != nil {
+			return fmt.Errorf("project '%s' no longer exists; restore it from the trash first", item.Project.Name)
+		}
+	}
+
+	for _, node := range item.Nodes {
+		// The title may have been taken while the node was in the trash.
+		if node.Title, err = t.freeTitle(node.Title, node.ProjectID, node.ID); err != nil {
+			return err
+		}
+		if err := t.putNode(&node, false); err != nil {
+			return err
+		}
+	}
+
+	b, err := t.bucket("trash")
+	if err != nil {
+		return err
+	}
+	return b.Delete(itob(id))
+}
+
+func (t *Tx) PurgeTrash(id int) (DeleteReport, error) {
+	item, err := t.getTrashItem(id)
+	if err != nil {
+		return DeleteReport{}, err
+	}
+
+	report := DeleteReport{TrashID: id}
+	if item.Kind == TrashProject {
+		report.ProjectID = item.Project.ID
+	}
+	for _, node := range item.Nodes {
+		if err := t.purgeNode(node.ID, &report); err != nil {
+			return report, err
+		}
+	}
+
+	b, err := t.bucket("trash")
+	if err != nil {
+		return report, err
+	}
+	return report, b.Delete(itob(id))
+}
+
+func (t *Tx) PurgeExpiredTrash(maxAge time.Duration) (DeleteReport, error) {
+	var report DeleteReport
+
+	items, err := t.GetTrash()
+	if err != nil {
+		return report, err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	for _, item := range items {
+		if !item.DeletedAt.Before(cutoff) {
+			continue
+		}
+		purged, err := t.PurgeTrash(item.ID)
+		if err != nil {
+			return report, err
+		}
+		report.NodeIDs = append(report.NodeIDs, purged.NodeIDs...)
+		report.Revisions += purged.Revisions
+		report.Attachments += purged.Attachments
+	}
+	return report, nil
+}
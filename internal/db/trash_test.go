@@ -0,0 +1,140 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrashRestore(t *testing.T) {
+	for name, s := range map[string]Store{"db": newTestDb(t, nil), "mem": NewMemStore(nil)} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.AddProject(Project{Name: "P"}); err != nil {
+				t.Fatal(err)
+			}
+			node := mustNode(t, s, 1, "Note", "text")
+			if err := s.DeleteNode(node.ID); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.GetNode(node.ID); err == nil {
+				t.Fatal("deleted node is still there")
+			}
+			// The title is taken while the node is away.
+			mustNode(t, s, 1, "note", "newer")
+
+			trash, err := s.GetTrash()
+			if err != nil || len(trash) != 1 || trash[0].Kind != TrashNode {
+				t.Fatalf("GetTrash = %+v, %v", trash, err)
+			}
+			if err := s.RestoreTrash(trash[0].ID); err != nil {
+				t.Fatal(err)
+			}
+			restored, err := s.GetNode(node.ID)
+			if err != nil || restored.Title != "Note (2)" || restored.Content != "text" {
+				t.Errorf("restored node = %+v, %v", restored, err)
+			}
+			if trash, _ := s.GetTrash(); len(trash) != 0 {
+				t.Errorf("trash after restore = %+v", trash)
+			}
+		})
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	for name, s := range map[string]Store{"db": newTestDb(t, nil), "mem": NewMemStore(nil)} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.AddProject(Project{Name: "P"}); err != nil {
+				t.Fatal(err)
+			}
+			node := mustNode(t, s, 1, "Note", "first")
+			node.Content = "second"
+			if err := s.UpdateNode(node); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.AddAttachment(node.ID, "a.txt", []byte("data")); err != nil {
+				t.Fatal(err)
+			}
+			other := mustNode(t, s, 1, "Other", "")
+
+			report, err := s.DeleteProject(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.NodeIDs) != 2 || report.TrashID == 0 {
+				t.Fatalf("DeleteProject = %+v", report)
+			}
+			purged, err := s.PurgeTrash(report.TrashID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if purged.ProjectID != 1 || len(purged.NodeIDs) != 2 || purged.Revisions != 3 || purged.Attachments != 1 {
+				t.Errorf("PurgeTrash = %+v", purged)
+			}
+			if revisions, _ := s.GetRevisions(node.ID); len(revisions) != 0 {
+				t.Errorf("revisions after purge = %+v", revisions)
+			}
+			if attachments, _ := s.GetAttachments(node.ID); len(attachments) != 0 {
+				t.Errorf("attachments after purge = %+v", attachments)
+			}
+			if trash, _ := s.GetTrash(); len(trash) != 0 {
+				t.Errorf("trash after purge = %+v", trash)
+			}
+			if err := s.RestoreTrash(report.TrashID); err == nil {
+				t.Error("restored a purged item")
+			}
+			if _, err := s.GetNode(other.ID); err == nil {
+				t.Error("purged node is still there")
+			}
+		})
+	}
+}
+
+func TestPurgeExpiredTrash(t *testing.T) {
+	for name, s := range map[string]Store{"db": newTestDb(t, nil), "mem": NewMemStore(nil)} {
+		t.Run(name, func(t *testing.T) {
+			if err := s.AddProject(Project{Name: "P"}); err != nil {
+				t.Fatal(err)
+			}
+			node := mustNode(t, s, 1, "Note", "")
+			if err := s.DeleteNode(node.ID); err != nil {
+				t.Fatal(err)
+			}
+			if report, err := s.PurgeExpiredTrash(time.Hour); err != nil || len(report.NodeIDs) != 0 {
+				t.Errorf("PurgeExpiredTrash(hour) = %+v, %v", report, err)
+			}
+			if report, err := s.PurgeExpiredTrash(-time.Hour); err != nil || len(report.NodeIDs) != 1 {
+				t.Errorf("PurgeExpiredTrash(-hour) = %+v, %v", report, err)
+			}
+		})
+	}
+}
+
+func TestRestoreNodeWithoutProject(t *testing.T) {
+	s := NewMemStore(nil)
+	if err := s.AddProject(Project{Name: "P"}); err != nil {
+		t.Fatal(err)
+	}
+	node := mustNode(t, s, 1, "Note", "")
+	if err := s.DeleteNode(node.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.DeleteProject(1); err != nil {
+		t.Fatal(err)
+	}
+	trash, err := s.GetTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Newest first: the project, then the node.
+	if err := s.RestoreTrash(trash[1].ID); err == nil {
+		t.Error("restored a node into a deleted project")
+	}
+	if err := s.RestoreTrash(trash[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RestoreTrash(trash[1].ID); err != nil {
+		t.Error(err)
+	}
+	if _, err := s.GetNode(node.ID); err != nil {
+		t.Errorf("node not restored: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// Tx groups several record operations into a single bbolt transaction so
+// that they are applied all together or not at all. A Tx is only valid
+// inside the function passed to Db.Update or Db.View.
+type Tx struct {
+	tx   *bbolt.Tx
+	opts Options
+	// encrypted is set for encrypted databases, whose values are sealed
+	// under key and whose indexes are left empty. A nil key means the
+	// database is locked.
+	encrypted bool
+	key       *cipherKey
+	// unbound also reads values sealed before schema version 11, without
+	// additional data. Only the migration that rebinds them sets it.
+	unbound bool
+	// projects caches linkProjects; writing a project resets it.
+	projects []Project
+}
+
+// Update runs fn in a read-write transaction. If fn returns an error every
+// change made through tx is rolled back. A read-only copy fails with
+// ErrReadOnly.
+func (d *Db) Update(fn func(tx *Tx) error) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.readOnly && d.ready {
+		return ErrReadOnly
+	}
+	return d.db.Update(func(btx *bbolt.Tx) error {
+		return fn(d.newTx(btx))
+	})
+}
+
+// View runs fn in a read-only transaction.
+func (d *Db) View(fn func(tx *Tx) error) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.db.View(func(btx *bbolt.Tx) error {
+		return fn(d.newTx(btx))
+	})
+}
+
+// newTx must be called with d.mu held.
+func (d *Db) newTx(btx *bbolt.Tx) *Tx {
+	return &Tx{tx: btx, opts: d.opts, encrypted: d.encrypted, key: d.key}
+}
+
+func (t *Tx) bucket(name string) (*bbolt.Bucket, error) {
+	b := t.tx.Bucket([]byte(name))
+	if b == nil {
+		return nil, fmt.Errorf("bucket %s not found", name)
+	}
+	return b, nil
+}
+
+// DeleteReport describes everything removed by a cascading delete or
+// purge.
+type DeleteReport struct {
+	ProjectID   int
+	NodeIDs     []int
+	Revisions   int
+	Attachments int
+	TrashID     int // Trash item holding the deleted records, if any
+}
@@ -0,0 +1,199 @@
+// Package diff computes line-based differences between two texts.
+package diff
+
+import (
+	"slices"
+	"strings"
+)
+
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// Line is one line of a diff. Insert lines come from the new text, Delete
+// lines from the old text and Equal lines from both.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// Lines returns the shortest edit script turning a into b, one entry per
+// line, using a longest-common-subsequence table.
+func Lines(a, b string) []Line {
+	x, y := splitLines(a), splitLines(b)
+
+	// lcs[i][j] is the length of the LCS of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []Line
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			lines = append(lines, Line{Equal, x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{Delete, x[i]})
+			i++
+		default:
+			lines = append(lines, Line{Insert, y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		lines = append(lines, Line{Delete, x[i]})
+	}
+	for ; j < len(y); j++ {
+		lines = append(lines, Line{Insert, y[j]})
+	}
+	return lines
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// hunk replaces the lines base[start:end] with lines.
+type hunk struct {
+	start, end int
+	lines      []string
+}
+
+// hunks turns the edit script from base to another text into the ranges of
+// base that it replaces.
+func hunks(script []Line) []hunk {
+	var result []hunk
+	pos := 0
+	var current *hunk
+	for _, line := range script {
+		if line.Op == Equal {
+			current = nil
+			pos++
+			continue
+		}
+		if current == nil {
+			result = append(result, hunk{start: pos, end: pos})
+			current = &result[len(result)-1]
+		}
+		if line.Op == Delete {
+			pos++
+			current.end = pos
+		} else {
+			current.lines = append(current.lines, line.Text)
+		}
+	}
+	return result
+}
+
+// apply returns base[start:end] with the hunks, which must lie inside it,
+// applied.
+func apply(base []string, start, end int, hs []hunk) []string {
+	var lines []string
+	pos := start
+	for _, h := range hs {
+		lines = append(lines, base[pos:h.start]...)
+		lines = append(lines, h.lines...)
+		pos = h.end
+	}
+	return append(lines, base[pos:end]...)
+}
+
+// overlaps reports whether h overlaps the group of changes to
+// base[start:end].
+func overlaps(h hunk, start, end int) bool {
+	return h.start < end || h.start == end && (h.start == h.end || start == end)
+}
+
+// Conflict markers written by Merge around changes that could not be
+// combined.
+const (
+	MarkerMine   = "<<<<<<< mine"
+	MarkerSplit  = "======="
+	MarkerTheirs = ">>>>>>> theirs"
+)
+
+// Merge combines the changes made to base in mine and in theirs, line by
+// line. Where both changed the same lines differently, both versions are
+// kept between conflict markers, and the number of such places is
+// returned.
+func Merge(base, mine, theirs string) (string, int) {
+	x := splitLines(base)
+	a, b := hunks(Lines(base, mine)), hunks(Lines(base, theirs))
+
+	var lines []string
+	conflicts := 0
+	pos := 0
+	for len(a) > 0 || len(b) > 0 {
+		// Start a group at the earliest hunk and pull in every hunk from
+		// either side that overlaps it. Changes to neighbouring lines do not
+		// overlap, but an insertion does where it borders another change,
+		// since which goes first is unclear.
+		start := len(x)
+		if len(a) > 0 {
+			start = a[0].start
+		}
+		if len(b) > 0 {
+			start = min(start, b[0].start)
+		}
+		end := start
+		var ga, gb []hunk
+		for {
+			if len(a) > 0 && overlaps(a[0], start, end) {
+				end = max(end, a[0].end)
+				ga, a = append(ga, a[0]), a[1:]
+			} else if len(b) > 0 && overlaps(b[0], start, end) {
+				end = max(end, b[0].end)
+				gb, b = append(gb, b[0]), b[1:]
+			} else {
+				break
+			}
+		}
+
+		lines = append(lines, x[pos:start]...)
+		ours, their := apply(x, start, end, ga), apply(x, start, end, gb)
+		switch {
+		case len(gb) == 0:
+			lines = append(lines, ours...)
+		case len(ga) == 0 || slices.Equal(ours, their):
+			lines = append(lines, their...)
+		default:
+			conflicts++
+			lines = append(lines, MarkerMine)
+			lines = append(lines, ours...)
+			lines = append(lines, MarkerSplit)
+			lines = append(lines, their...)
+			lines = append(lines, MarkerTheirs)
+		}
+		pos = end
+	}
+	lines = append(lines, x[pos:]...)
+
+	if len(lines) == 0 {
+		return "", conflicts
+	}
+	merged := strings.Join(lines, "\n")
+	if strings.HasSuffix(mine, "\n") || strings.HasSuffix(theirs, "\n") {
+		merged += "\n"
+	}
+	return merged, conflicts
+}
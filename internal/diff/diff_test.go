@@ -0,0 +1,62 @@
+package diff
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	got := Lines("a\nb\nc\n", "a\nB\nc\nd\n")
+	want := []Line{{Equal, "a"}, {Delete, "b"}, {Insert, "B"}, {Equal, "c"}, {Insert, "d"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("Lines = %v, want %v", got, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	conflict := func(mine, theirs string) string {
+		return MarkerMine + "\n" + mine + MarkerSplit + "\n" + theirs + MarkerTheirs + "\n"
+	}
+	tests := []struct {
+		name               string
+		base, mine, theirs string
+		want               string
+		conflicts          int
+	}{
+		{"separate lines", "a\nb\nc\n", "a\nB\nc\n", "a\nb\nC\n", "a\nB\nC\n", 0},
+		{"same change", "a\nb\nc\n", "a\nB\nc\n", "a\nB\nc\n", "a\nB\nc\n", 0},
+		{"only mine", "a\nb\n", "a\nB\n", "a\nb\n", "a\nB\n", 0},
+		{"only theirs", "a\nb\n", "a\nb\n", "A\nb\n", "A\nb\n", 0},
+		{"appends to empty", "", "x\n", "", "x\n", 0},
+		{"insertions at both ends", "a\n", "a\nm\n", "t\na\n", "t\na\nm\n", 0},
+		{"deletions apart", "a\nb\nc\nd\ne\n", "a\nc\nd\ne\n", "a\nb\nc\nd\n", "a\nc\nd\n", 0},
+		{
+			"same line changed differently",
+			"a\nb\nc\n", "a\nB\nc\n", "a\nX\nc\n",
+			"a\n" + conflict("B\n", "X\n") + "c\n", 1,
+		},
+		{
+			"edit against delete",
+			"a\nb\nc\n", "a\nB\nc\n", "a\nc\n",
+			"a\n" + conflict("B\n", "") + "c\n", 1,
+		},
+		{
+			"insertions at the same place",
+			"a\nb\n", "a\nm\nb\n", "a\nt\nb\n",
+			"a\n" + conflict("m\n", "t\n") + "b\n", 1,
+		},
+		{
+			"two conflicts",
+			"a\nb\nc\nd\ne\n", "A\nb\nc\nd\nE\n", "1\nb\nc\nd\n5\n",
+			conflict("A\n", "1\n") + "b\nc\nd\n" + conflict("E\n", "5\n"), 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflicts := Merge(tt.base, tt.mine, tt.theirs)
+			if got != tt.want || conflicts != tt.conflicts {
+				t.Errorf("Merge = %q, %d; want %q, %d", got, conflicts, tt.want, tt.conflicts)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package markup
+
+import (
+	"regexp"
+	"strings"
+)
+
+// linkPattern matches [[Title#Heading|shown text]], where the heading and
+// the display text are optional and the title may be left out to link
+// within the note. A heading starting with ^ names a block instead.
+var linkPattern = regexp.MustCompile(`\[\[([^\[\]|#]*)(?:#([^\[\]|]*))?(?:\|([^\[\]]*))?\]\]`)
+
+// blockPattern matches a ^block-id at the end of a line, which marks the
+// line as a block that [[Title#^block-id]] links to.
+var blockPattern = regexp.MustCompile(`(?m)(?:^|[ \t])\^([\w-]+)[ \t]*$`)
+
+// headingPattern matches a Markdown heading line.
+var headingPattern = regexp.MustCompile(`(?m)^#{1,6}[ \t]+(.*?)(?:[ \t]+#+)?[ \t]*$`)
+
+type Link struct {
+	// Title is the note linked to, as written, or empty for a link to a
+	// heading or block in the same note.
+	Title   string
+	Heading string // Heading the link points into, if any
+	Block   string // Block ID, without the ^, if the link points to a block
+	Text    string // Display text given after |, if any
+	// Position holds the byte offsets of the whole link.
+	Position [2]int
+	// Embed is set for ![[name]] references, which name an attachment of
+	// the note rather than another note. The whole name is in Title.
+	Embed bool
+
+	titleEnd int // Byte offset just after the title
+}
+
+// ParseLinks returns every [[Title]] link and ![[name]] embed in content in
+// document order. Position holds the byte offsets of the whole link
+// including brackets and any leading !.
+func ParseLinks(content string) []Link {
+	var links []Link
+
+	for _, match := range linkPattern.FindAllStringSubmatchIndex(content, -1) {
+		link := Link{
+			Title:    content[match[2]:match[3]],
+			Position: [2]int{match[0], match[1]},
+			titleEnd: match[3],
+		}
+		if match[0] > 0 && content[match[0]-1] == '!' {
+			link.Embed = true
+			link.Position[0]--
+			link.Title = content[match[0]+2 : match[1]-2]
+			links = append(links, link)
+			continue
+		}
+		if match[4] >= 0 {
+			anchor := strings.TrimSpace(content[match[4]:match[5]])
+			if block, ok := strings.CutPrefix(anchor, "^"); ok {
+				link.Block = strings.TrimSpace(block)
+			} else {
+				link.Heading = anchor
+			}
+		}
+		if match[6] >= 0 {
+			link.Text = strings.TrimSpace(content[match[6]:match[7]])
+		}
+		if strings.TrimSpace(link.Title) == "" && link.Heading == "" && link.Block == "" {
+			continue
+		}
+		links = append(links, link)
+	}
+
+	return links
+}
+
+// Display returns what a link is shown as: its display text if it has one,
+// or else its title followed by the heading or block it points to.
+func (l Link) Display() string {
+	if l.Text != "" {
+		return l.Text
+	}
+	var anchor string
+	switch {
+	case l.Block != "":
+		anchor = "^" + l.Block
+	case l.Heading != "":
+		anchor = l.Heading
+	}
+	title := strings.TrimSpace(l.Title)
+	switch {
+	case anchor == "":
+		return title
+	case title == "":
+		return anchor
+	}
+	return title + " › " + anchor
+}
+
+// RewriteLinks replaces the title of each [[Title]] link in content for
+// which rewrite returns a new one, keeping any heading, block and display
+// text and leaving embeds and links within the note alone. It returns the
+// new content and the number of links changed.
+func RewriteLinks(content string, rewrite func(title string) (string, bool)) (string, int) {
+	var b strings.Builder
+	changed := 0
+	last := 0
+	for _, link := range ParseLinks(content) {
+		if link.Embed || strings.TrimSpace(link.Title) == "" {
+			continue
+		}
+		title, ok := rewrite(link.Title)
+		if !ok {
+			continue
+		}
+		b.WriteString(content[last : link.Position[0]+2])
+		b.WriteString(title)
+		last = link.titleEnd
+		changed++
+	}
+	if changed == 0 {
+		return content, 0
+	}
+	b.WriteString(content[last:])
+	return b.String(), changed
+}
+
+// FindHeading returns the byte offset of the start of the first heading
+// line in content whose text is heading, ignoring case and spacing.
+func FindHeading(content, heading string) (int, bool) {
+	want := strings.Join(strings.Fields(strings.ToLower(heading)), " ")
+	for _, match := range headingPattern.FindAllStringSubmatchIndex(content, -1) {
+		text := strings.Join(strings.Fields(strings.ToLower(content[match[2]:match[3]])), " ")
+		if text == want {
+			return match[0], true
+		}
+	}
+	return 0, false
+}
+
+// FindBlock returns the byte offset of the start of the line in content
+// marked with ^id.
+func FindBlock(content, id string) (int, bool) {
+	for _, match := range blockPattern.FindAllStringSubmatchIndex(content, -1) {
+		if content[match[2]:match[3]] == id {
+			return strings.LastIndex(content[:match[2]], "\n") + 1, true
+		}
+	}
+	return 0, false
+}
+
+// Snippet returns the text around content[start:end] on a single line,
+// extended by up to radius bytes either side and trimmed to word boundaries.
+func Snippet(content string, start, end, radius int) string {
+	from := max(start-radius, 0)
+	to := min(end+radius, len(content))
+
+	// Step back to the start of a word and forward to the end of one so the
+	// snippet does not open or close mid-word.
+	if from > 0 {
+		if i := strings.IndexAny(content[from:start], " \t\n"); i >= 0 {
+			from += i + 1
+		}
+	}
+	if to < len(content) {
+		if i := strings.LastIndexAny(content[end:to], " \t\n"); i >= 0 {
+			to = end + i
+		}
+	}
+
+	s := strings.Join(strings.Fields(content[from:to]), " ")
+	if from > 0 {
+		s = "…" + s
+	}
+	if to < len(content) {
+		s += "…"
+	}
+	return s
+}
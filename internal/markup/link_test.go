@@ -0,0 +1,90 @@
+package markup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLinks(t *testing.T) {
+	content := "See [[Note]], [[ Other #Part two | the part ]], [[Note#^b-1]], [[#Local]], ![[pic.png]] and [[]]."
+	want := []Link{
+		{Title: "Note"},
+		{Title: " Other ", Heading: "Part two", Text: "the part"},
+		{Title: "Note", Block: "b-1"},
+		{Heading: "Local"},
+		{Title: "pic.png", Embed: true},
+	}
+	links := ParseLinks(content)
+	if len(links) != len(want) {
+		t.Fatalf("ParseLinks = %+v", links)
+	}
+	for i, link := range links {
+		w := want[i]
+		if link.Title != w.Title || link.Heading != w.Heading || link.Block != w.Block || link.Text != w.Text || link.Embed != w.Embed {
+			t.Errorf("link %d = %+v, want %+v", i, link, w)
+		}
+		raw := content[link.Position[0]:link.Position[1]]
+		if !strings.HasSuffix(raw, "]]") || !strings.HasPrefix(raw, "[[") && !strings.HasPrefix(raw, "![[") {
+			t.Errorf("link %d spans %q", i, raw)
+		}
+	}
+}
+
+func TestLinkDisplay(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"[[Note]]", "Note"},
+		{"[[Note|shown]]", "shown"},
+		{"[[ Note #Heading]]", "Note › Heading"},
+		{"[[Note#^block]]", "Note › ^block"},
+		{"[[#Heading]]", "Heading"},
+	}
+	for _, tt := range tests {
+		links := ParseLinks(tt.link)
+		if len(links) != 1 {
+			t.Fatalf("ParseLinks(%q) = %+v", tt.link, links)
+		}
+		if got := links[0].Display(); got != tt.want {
+			t.Errorf("Display(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteLinks(t *testing.T) {
+	content := "[[Old]] [[Old#Part|text]] [[old#^b]] [[Other]] ![[Old]] [[#Old]]"
+	got, n := RewriteLinks(content, func(title string) (string, bool) {
+		if strings.EqualFold(title, "old") {
+			return "New", true
+		}
+		return "", false
+	})
+	want := "[[New]] [[New#Part|text]] [[New#^b]] [[Other]] ![[Old]] [[#Old]]"
+	if got != want || n != 3 {
+		t.Errorf("RewriteLinks = %q, %d, want %q, 3", got, n, want)
+	}
+	if got, n := RewriteLinks(content, func(string) (string, bool) { return "", false }); got != content || n != 0 {
+		t.Errorf("RewriteLinks without changes = %q, %d", got, n)
+	}
+}
+
+func TestFindHeading(t *testing.T) {
+	content := "intro\n# Title\n\n##  Part   Two ##\ntext\n"
+	if i, ok := FindHeading(content, "part two"); !ok || i != strings.Index(content, "##") {
+		t.Errorf("FindHeading = %d, %v", i, ok)
+	}
+	if _, ok := FindHeading(content, "intro"); ok {
+		t.Error("FindHeading matched a line that is not a heading")
+	}
+}
+
+func TestFindBlock(t *testing.T) {
+	content := "first line\nsecond line ^b-2\nthird^nope\n"
+	if i, ok := FindBlock(content, "b-2"); !ok || i != strings.Index(content, "second") {
+		t.Errorf("FindBlock = %d, %v", i, ok)
+	}
+	if _, ok := FindBlock(content, "nope"); ok {
+		t.Error("FindBlock matched an id not set off by a space")
+	}
+}
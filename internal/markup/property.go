@@ -0,0 +1,192 @@
+package markup
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is the type a property value was recognised as.
+type Kind int
+
+const (
+	Text Kind = iota
+	Number
+	Bool
+	Date
+	List
+)
+
+// Value is a typed property value. Text always holds the value as written;
+// the field matching Kind holds the parsed form.
+type Value struct {
+	Kind   Kind
+	Text   string
+	Number float64   `json:",omitempty"`
+	Bool   bool      `json:",omitempty"`
+	Date   time.Time `json:",omitempty"`
+	List   []string  `json:",omitempty"`
+}
+
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	time.RFC3339,
+}
+
+// ParseValue recognises booleans, numbers, dates and [a, b] lists, falling
+// back to text. Surrounding quotes are removed.
+func ParseValue(raw string) Value {
+	text := unquote(strings.TrimSpace(raw))
+	v := Value{Kind: Text, Text: text}
+
+	if raw := strings.TrimSpace(raw); strings.HasPrefix(raw, "[") && strings.HasSuffix(raw, "]") && !strings.HasPrefix(raw, "[[") {
+		v.Kind = List
+		for _, item := range strings.Split(raw[1:len(raw)-1], ",") {
+			if item = unquote(strings.TrimSpace(item)); item != "" {
+				v.List = append(v.List, item)
+			}
+		}
+		return v
+	}
+
+	switch strings.ToLower(text) {
+	case "true", "yes":
+		v.Kind, v.Bool = Bool, true
+		return v
+	case "false", "no":
+		v.Kind, v.Bool = Bool, false
+		return v
+	}
+	if n, err := strconv.ParseFloat(text, 64); err == nil {
+		v.Kind, v.Number = Number, n
+		return v
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			v.Kind, v.Date = Date, t
+			return v
+		}
+	}
+	return v
+}
+
+// String renders the value for display.
+func (v Value) String() string {
+	switch v.Kind {
+	case List:
+		return strings.Join(v.List, ", ")
+	case Date:
+		if v.Date.Hour() == 0 && v.Date.Minute() == 0 {
+			return v.Date.Format("2006-01-02")
+		}
+		return v.Date.Format("2006-01-02 15:04")
+	}
+	return v.Text
+}
+
+// IndexKeys returns the normalized forms the value is indexed under; lists
+// yield one key per item.
+func (v Value) IndexKeys() []string {
+	switch v.Kind {
+	case List:
+		keys := make([]string, 0, len(v.List))
+		for _, item := range v.List {
+			keys = append(keys, strings.ToLower(item))
+		}
+		return keys
+	case Number:
+		return []string{strconv.FormatFloat(v.Number, 'f', -1, 64)}
+	case Bool:
+		return []string{strconv.FormatBool(v.Bool)}
+	case Date:
+		return []string{v.Date.Format("2006-01-02")}
+	}
+	if v.Text == "" {
+		return nil
+	}
+	return []string{strings.ToLower(v.Text)}
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// NormalizeKey lower-cases a property key and collapses spacing.
+func NormalizeKey(key string) string {
+	return strings.Join(strings.Fields(strings.ToLower(key)), " ")
+}
+
+// SplitFrontmatter separates a leading block fenced by "---" lines from the
+// rest of content. offset is where body starts within content. If there is
+// no frontmatter, body is content and offset is 0.
+func SplitFrontmatter(content string) (frontmatter, body string, offset int) {
+	if !strings.HasPrefix(content, "---\n") && !strings.HasPrefix(content, "---\r\n") {
+		return "", content, 0
+	}
+	start := strings.Index(content, "\n") + 1
+	for pos := start; pos < len(content); {
+		end := strings.Index(content[pos:], "\n")
+		line := content[pos:]
+		next := len(content)
+		if end >= 0 {
+			line = content[pos : pos+end]
+			next = pos + end + 1
+		}
+		if l := strings.TrimRight(line, "\r"); l == "---" || l == "..." {
+			return content[start:pos], content[next:], next
+		}
+		pos = next
+	}
+	return "", content, 0
+}
+
+var (
+	frontmatterField = regexp.MustCompile(`^([\p{L}_][\p{L}\p{N}_ -]*):\s*(.*)$`)
+	frontmatterItem  = regexp.MustCompile(`^\s+-\s+(.*)$`)
+	inlineField      = regexp.MustCompile(`(?m)^[ \t]*(?:[-*][ \t]+)?([\p{L}_][\p{L}\p{N}_ -]*)::[ \t]*(.*?)[ \t]*$`)
+)
+
+// ParseProperties reads key: value pairs from content's frontmatter, which
+// may include indented "- item" lists, and key:: value fields on any line of
+// the body. Later occurrences of a key replace earlier ones.
+func ParseProperties(content string) map[string]Value {
+	props := make(map[string]Value)
+	frontmatter, body, _ := SplitFrontmatter(content)
+
+	var listKey string
+	for _, line := range strings.Split(frontmatter, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if m := frontmatterItem.FindStringSubmatch(line); m != nil && listKey != "" {
+			v := props[listKey]
+			v.Kind = List
+			v.List = append(v.List, unquote(strings.TrimSpace(m[1])))
+			v.Text = strings.Join(v.List, ", ")
+			props[listKey] = v
+			continue
+		}
+		listKey = ""
+		m := frontmatterField.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		key := NormalizeKey(m[1])
+		if strings.TrimSpace(m[2]) == "" {
+			// A bare "key:" introduces a block list.
+			listKey = key
+			props[key] = Value{Kind: List}
+			continue
+		}
+		props[key] = ParseValue(m[2])
+	}
+
+	for _, m := range inlineField.FindAllStringSubmatch(body, -1) {
+		props[NormalizeKey(m[1])] = ParseValue(m[2])
+	}
+	return props
+}
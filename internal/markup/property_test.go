@@ -0,0 +1,106 @@
+package markup
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want Value
+	}{
+		{" plain text ", Value{Kind: Text, Text: "plain text"}},
+		{`"quoted"`, Value{Kind: Text, Text: "quoted"}},
+		{"", Value{Kind: Text}},
+		{"Yes", Value{Kind: Bool, Text: "Yes", Bool: true}},
+		{"false", Value{Kind: Bool, Text: "false"}},
+		{"3.50", Value{Kind: Number, Text: "3.50", Number: 3.5}},
+		{"'42'", Value{Kind: Number, Text: "42", Number: 42}},
+		{"2024-01-05", Value{Kind: Date, Text: "2024-01-05", Date: time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)}},
+		{"2024-01-05 09:30", Value{Kind: Date, Text: "2024-01-05 09:30", Date: time.Date(2024, 1, 5, 9, 30, 0, 0, time.UTC)}},
+		{`[a, "b c", , d]`, Value{Kind: List, Text: `[a, "b c", , d]`, List: []string{"a", "b c", "d"}}},
+		{"[[Note]]", Value{Kind: Text, Text: "[[Note]]"}},
+	}
+	for _, tt := range tests {
+		if got := ParseValue(tt.raw); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseValue(%q) = %+v, want %+v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestValueIndexKeys(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+	}{
+		{"Open", []string{"open"}},
+		{"", nil},
+		{"3.0", []string{"3"}},
+		{"no", []string{"false"}},
+		{"2024-01-05T09:30", []string{"2024-01-05"}},
+		{"[Go, Bolt]", []string{"go", "bolt"}},
+	}
+	for _, tt := range tests {
+		if got := ParseValue(tt.raw).IndexKeys(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("IndexKeys of %q = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeKey(t *testing.T) {
+	if got := NormalizeKey("  Due   Date "); got != "due date" {
+		t.Errorf("NormalizeKey = %q", got)
+	}
+}
+
+func TestSplitFrontmatter(t *testing.T) {
+	tests := []struct {
+		content, frontmatter, body string
+	}{
+		{"---\na: 1\n---\nbody", "a: 1\n", "body"},
+		{"---\r\na: 1\r\n...\r\nbody", "a: 1\r\n", "body"},
+		{"---\n---\n", "", ""},
+		{"---\na: 1\nno closing fence", "", "---\na: 1\nno closing fence"},
+		{"text\n---\na: 1\n---\n", "", "text\n---\na: 1\n---\n"},
+	}
+	for _, tt := range tests {
+		frontmatter, body, offset := SplitFrontmatter(tt.content)
+		if frontmatter != tt.frontmatter || body != tt.body || tt.content[offset:] != body {
+			t.Errorf("SplitFrontmatter(%q) = %q, %q, %d", tt.content, frontmatter, body, offset)
+		}
+	}
+}
+
+func TestParseProperties(t *testing.T) {
+	content := "---\n" +
+		"Status: draft\n" +
+		"Due Date: 2024-01-05\n" +
+		"tags:\n" +
+		"  - go\n" +
+		"  - 'bolt db'\n" +
+		"not a field\n" +
+		"---\n" +
+		"# Note\n" +
+		"status:: done\n" +
+		"- priority:: 2\n" +
+		"  owner::   Ann  \n" +
+		"a sentence, with key:: in it\n"
+	got := ParseProperties(content)
+	want := map[string]Value{
+		"status":   ParseValue("done"),
+		"due date": ParseValue("2024-01-05"),
+		"tags":     {Kind: List, Text: "go, bolt db", List: []string{"go", "bolt db"}},
+		"priority": ParseValue("2"),
+		"owner":    ParseValue("Ann"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseProperties = %+v, want %+v", got, want)
+	}
+
+	// Without frontmatter a "key: value" line is just text.
+	if got := ParseProperties("status: done\n"); len(got) != 0 {
+		t.Errorf("ParseProperties read %+v from the body", got)
+	}
+}
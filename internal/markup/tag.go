@@ -0,0 +1,54 @@
+package markup
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// tagPattern matches #tag where the # starts a word, so headings ("# Title"),
+// URL fragments and [[Title#Heading]] links are not mistaken for tags. Tags
+// must start with a letter or underscore so that "#42" stays an issue
+// number.
+var tagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&#/\[])#([\p{L}_][\p{L}\p{N}_/-]*)`)
+
+type Tag struct {
+	Name     string
+	Position [2]int // Byte offsets of the tag including the #
+}
+
+// ParseTags returns every #tag in content in document order.
+func ParseTags(content string) []Tag {
+	var tags []Tag
+	for _, match := range tagPattern.FindAllStringSubmatchIndex(content, -1) {
+		tags = append(tags, Tag{
+			Name:     content[match[2]:match[3]],
+			Position: [2]int{match[2] - 1, match[3]},
+		})
+	}
+	return tags
+}
+
+// NormalizeTag lower-cases a tag and strips any leading #.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// MergeTags normalizes, de-duplicates and sorts tags from any number of
+// lists, dropping empty ones.
+func MergeTags(lists ...[]string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, list := range lists {
+		for _, tag := range list {
+			tag = NormalizeTag(tag)
+			if tag == "" || seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			merged = append(merged, tag)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
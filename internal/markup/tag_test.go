@@ -0,0 +1,58 @@
+package markup
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"#go at the start", []string{"go"}},
+		{"nested #lang/Go-1_x, done", []string{"lang/Go-1_x"}},
+		{"(#paren) and #café", []string{"paren", "café"}},
+		{"#_private", []string{"_private"}},
+		{"# Heading\n## Sub", nil},
+		{"issue #42", nil},
+		{"[[Note#Heading]] and [[#Local]]", nil},
+		{"https://example.com/#fragment and a/#b", nil},
+		{"word#inside, &#38; and ##twice", nil},
+	}
+	for _, tt := range tests {
+		var names []string
+		for _, tag := range ParseTags(tt.content) {
+			names = append(names, tag.Name)
+			if raw := tt.content[tag.Position[0]:tag.Position[1]]; raw != "#"+tag.Name {
+				t.Errorf("%q: tag %q spans %q", tt.content, tag.Name, raw)
+			}
+		}
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("ParseTags(%q) = %q, want %q", tt.content, names, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+	for tag, want := range map[string]string{
+		"Go":         "go",
+		" #Lang/Go ": "lang/go",
+		"#":          "",
+		"":           "",
+	} {
+		if got := NormalizeTag(tag); got != want {
+			t.Errorf("NormalizeTag(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	got := MergeTags([]string{"Zeta", "#go", ""}, nil, []string{"GO", "alpha", " # "})
+	if want := []string{"alpha", "go", "zeta"}; !slices.Equal(got, want) {
+		t.Errorf("MergeTags = %q, want %q", got, want)
+	}
+	if got := MergeTags(); got != nil {
+		t.Errorf("MergeTags() = %q", got)
+	}
+}
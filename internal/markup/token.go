@@ -0,0 +1,43 @@
+package markup
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Token is a normalized word and the byte offsets it was found at.
+type Token struct {
+	Term  string
+	Start int
+	End   int
+}
+
+// Tokenize splits text into lower-cased runs of letters and digits.
+func Tokenize(text string) []Token {
+	var tokens []Token
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			tokens = append(tokens, Token{Term: strings.ToLower(text[start:i]), Start: start, End: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, Token{Term: strings.ToLower(text[start:]), Start: start, End: len(text)})
+	}
+	return tokens
+}
+
+// Terms returns the normalized terms of text in order.
+func Terms(text string) []string {
+	tokens := Tokenize(text)
+	terms := make([]string, len(tokens))
+	for i, token := range tokens {
+		terms[i] = token.Term
+	}
+	return terms
+}
@@ -1,16 +1,27 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/pixambi/gbrain/cmd"
+	"github.com/pixambi/gbrain/internal/config"
 	"github.com/pixambi/gbrain/internal/db"
 )
 
 func main() {
+	notesDir := flag.String("notes", "", "store notes as markdown files in `dir` instead of ~/.gbrain/gbrain.db")
+	readOnly := flag.Bool("readonly", false, "browse a read-only copy of the database, for use while another gbrain has it open")
+	flag.Usage = usage
+	flag.Parse()
+
 	// Create data directory if it doesn't exist
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -22,23 +33,114 @@ func main() {
 		log.Fatalf("Error creating data directory: %v", err)
 	}
 
+	cfg, err := config.Load(filepath.Join(dataDir, "config.json"))
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if *notesDir == "" {
+		*notesDir = cfg.NotesDir
+	}
+	*notesDir = expandHome(homeDir, *notesDir)
+	backupDir := filepath.Join(dataDir, "backups")
+	if cfg.BackupDir != "" {
+		backupDir = expandHome(homeDir, cfg.BackupDir)
+	}
+
+	opts := &db.Options{
+		RevisionLimit:  cfg.RevisionLimit,
+		RevisionMaxAge: time.Duration(cfg.RevisionMaxAgeDays) * 24 * time.Hour,
+		TrashMaxAge:    time.Duration(cfg.TrashMaxAgeDays) * 24 * time.Hour,
+	}
 	dbPath := filepath.Join(dataDir, "gbrain.db")
-	db, err := db.NewDb(dbPath)
+	if flag.NArg() > 0 {
+		e := env{dbPath: dbPath, notesDir: *notesDir, backupDir: backupDir, backupKeep: cfg.BackupKeep, opts: opts}
+		if err := runCommand(e, flag.Args()); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
+	store, err := openStore(dbPath, *notesDir, opts, *readOnly)
+	if errors.Is(err, db.ErrInUse) && !*readOnly && confirm(
+		"The database is open in another gbrain, and notes can only be changed in one at a time.\n"+
+			"Open a read-only copy instead? [y/N] ") {
+		*readOnly = true
+		store, err = openStore(dbPath, *notesDir, opts, true)
+	}
+	if errors.Is(err, db.ErrInUse) {
+		log.Fatalf("Error opening database: %v; quit it first, or run gbrain -readonly to browse a copy", err)
+	}
 	if err != nil {
 		log.Fatalf("Error opening database: %v", err)
 	}
-	defer db.Close()
+	defer store.Close()
 
-	// Initialize the database
-	if err := db.Init(); err != nil {
-		log.Fatalf("Error initializing database: %v", err)
+	if err := backupOnStart(store, backupDir, cfg.BackupKeep); err != nil {
+		log.Printf("Warning: backing up the database: %v", err)
 	}
 
 	// Create and start the application
-	m := cmd.NewApp(*db)
+	m := cmd.NewApp(store, cmd.Options{
+		LockAfter:  time.Duration(cfg.LockAfterMinutes) * time.Minute,
+		BackupDir:  backupDir,
+		BackupKeep: cfg.BackupKeep,
+		ReadOnly:   *readOnly,
+	})
 	p := tea.NewProgram(m, tea.WithAltScreen())
 
 	if _, err := p.Run(); err != nil {
 		log.Fatalf("Error running program: %v", err)
 	}
 }
+
+// backupOnStart takes a backup of the database into dir, keeping the newest
+// keep, if backups are turned on. Markdown directories are left to other
+// tools, and a read-only copy to the gbrain that has the database open.
+func backupOnStart(store db.Store, dir string, keep int) error {
+	d, ok := store.(*db.Db)
+	if !ok || d.ReadOnly() || keep <= 0 {
+		return nil
+	}
+	_, err := d.Backup(dir, keep)
+	return err
+}
+
+// expandHome replaces a leading ~/ in path with homeDir.
+func expandHome(homeDir, path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return filepath.Join(homeDir, rest)
+	}
+	return path
+}
+
+// openStore opens and initialises the markdown directory notesDir if it is
+// set, and otherwise the database at dbPath, or a read-only copy of it.
+func openStore(dbPath, notesDir string, opts *db.Options, readOnly bool) (db.Store, error) {
+	if notesDir != "" {
+		if readOnly {
+			return nil, fmt.Errorf("-readonly only applies to the database, not to a notes directory")
+		}
+		store, err := db.NewMarkdownStore(notesDir, opts)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.Init(); err != nil {
+			return nil, fmt.Errorf("initializing %s: %w", notesDir, err)
+		}
+		return store, nil
+	}
+
+	open := db.NewDb
+	if readOnly {
+		open = db.NewDbCopy
+	}
+	store, err := open(dbPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Init(); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("initializing database: %w", err)
+	}
+	return store, nil
+}